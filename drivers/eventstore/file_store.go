@@ -0,0 +1,799 @@
+package eventstore
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+// ErrFileStoreClosed is the error returned when the file store is used after Close
+var ErrFileStoreClosed = errors.New("file store closed")
+
+// ErrVersionExists is the error returned when storing an event whose aggregate id and version are
+// already present in the store, it wraps sourcing.ErrVersionConflict
+var ErrVersionExists = fmt.Errorf("event version already exists: %w", sourcing.ErrVersionConflict)
+
+// ErrInvalidSegment is the error returned when a file is not a file store segment
+var ErrInvalidSegment = errors.New("invalid segment file")
+
+// ErrCorruptRecord is the error returned when a record fails to decode after passing its checksum,
+// or when a damaged record is found before the end of the segment on open
+var ErrCorruptRecord = errors.New("corrupt record")
+
+// FsyncPolicy determines when the file store flushes writes to stable storage
+type FsyncPolicy int
+
+const (
+	// FsyncAlways syncs the segment before Store and Replace return, no acknowledged write is lost
+	FsyncAlways FsyncPolicy = iota
+	// FsyncInterval syncs the segment periodically from a background goroutine, writes acknowledged
+	// within the interval may be lost on power failure
+	FsyncInterval
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever
+)
+
+const (
+	segmentMagic      = "GSEV\x01"
+	recordHeaderSize  = 8 // uint32 payload length + uint32 checksum
+	recordKindAppend  = byte(1)
+	recordKindReplace = byte(2)
+	recordKindDelete  = byte(3)
+	maxRecordSize     = 1 << 30
+	defaultSyncEvery  = time.Second
+	filePerm          = 0o644
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// indexEntry locates a single encoded event inside the segment
+type indexEntry struct {
+	version   uint64
+	offset    int64
+	length    int
+	tombstone bool
+}
+
+// FileStore is a dependency free event store that persists events in a single append-only segment
+// file. It is intended for CLIs, edge devices and local development.
+//
+// Every Store, Append or Replace call is written as one record, a little-endian uint32 payload
+// length followed by a CRC-32C checksum of the payload, so a batch of events, even one spanning
+// several streams, is either fully persisted or not at all. The index by aggregate id, aggregate
+// type and version is held in memory and rebuilt when the store is opened; a torn record at the end of the segment is truncated away during that scan, while damage
+// anywhere else makes OpenFileStore fail with ErrCorruptRecord.
+//
+// Replace appends a record that supersedes the original event, the original bytes remain in the
+// segment until it is rewritten by other means.
+//
+// The instrumentation and logger set with WithFileStoreInstrumentation and WithFileStoreLogger
+// report every operation of the "eventstore.file" store, with the segment path as the table, see
+// gosignal.StartStoreOperation.
+type FileStore struct {
+	mu       sync.RWMutex
+	path     string
+	file     *os.File
+	size     int64
+	index    map[string]map[string][]indexEntry // aggregate id, then aggregate type
+	policy   FsyncPolicy
+	interval time.Duration
+	done     chan struct{}
+	wg       sync.WaitGroup
+	closed   bool
+	instr    gosignal.Instrumentation
+	logger   *slog.Logger
+}
+
+// FileStoreOption configures a FileStore
+type FileStoreOption func(*FileStore)
+
+// WithFsyncPolicy sets the fsync policy, FsyncAlways is the default
+func WithFsyncPolicy(policy FsyncPolicy) FileStoreOption {
+	return func(fs *FileStore) {
+		fs.policy = policy
+	}
+}
+
+// WithFsyncInterval syncs the segment every interval, it implies FsyncInterval
+func WithFsyncInterval(interval time.Duration) FileStoreOption {
+	return func(fs *FileStore) {
+		fs.policy = FsyncInterval
+		fs.interval = interval
+	}
+}
+
+// WithFileStoreInstrumentation reports the store's operations to the instrumentation
+func WithFileStoreInstrumentation(instr gosignal.Instrumentation) FileStoreOption {
+	return func(fs *FileStore) {
+		fs.instr = instr
+	}
+}
+
+// WithFileStoreLogger logs the store's operations
+func WithFileStoreLogger(logger *slog.Logger) FileStoreOption {
+	return func(fs *FileStore) {
+		fs.logger = logger
+	}
+}
+
+// OpenFileStore opens or creates the segment at path, recovering from any torn write at its end
+func OpenFileStore(path string, options ...FileStoreOption) (*FileStore, error) {
+	fs := &FileStore{
+		path:     path,
+		index:    make(map[string]map[string][]indexEntry),
+		policy:   FsyncAlways,
+		interval: defaultSyncEvery,
+		done:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(fs)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, filePerm)
+	if err != nil {
+		return nil, err
+	}
+	fs.file = f
+
+	if err := fs.recover(); err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+
+	if fs.policy == FsyncInterval && fs.interval > 0 {
+		fs.wg.Add(1)
+		go fs.syncLoop()
+	}
+
+	return fs, nil
+}
+
+// recover validates the segment header, rebuilds the index and truncates a torn trailing record.
+// Only the last record can be torn by a crash, a damaged record followed by more data is reported
+// as ErrCorruptRecord instead of discarding the records after it.
+func (fs *FileStore) recover() error {
+	info, err := fs.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		if _, err := fs.file.WriteAt([]byte(segmentMagic), 0); err != nil {
+			return err
+		}
+		fs.size = int64(len(segmentMagic))
+		return fs.file.Sync()
+	}
+
+	magic := make([]byte, len(segmentMagic))
+	if _, err := fs.file.ReadAt(magic, 0); err != nil || string(magic) != segmentMagic {
+		return ErrInvalidSegment
+	}
+
+	size := info.Size()
+	r := bufio.NewReader(io.NewSectionReader(fs.file, int64(len(segmentMagic)), size))
+	offset := int64(len(segmentMagic))
+	header := make([]byte, recordHeaderSize)
+
+	for offset < size {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break // torn header
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		end := offset + recordHeaderSize + int64(length)
+
+		if length == 0 {
+			// space allocated but never written, as left by a crash while extending the file
+			zeroed, err := fs.zeroedFrom(offset, size)
+			if err != nil {
+				return err
+			}
+			if !zeroed {
+				return fmt.Errorf("%w: empty record at offset %d", ErrCorruptRecord, offset)
+			}
+			break
+		}
+
+		if end > size {
+			break // torn payload
+		}
+		if length > maxRecordSize {
+			return fmt.Errorf("%w: record of %d bytes at offset %d", ErrCorruptRecord, length, offset)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+
+		if crc32.Checksum(payload, castagnoli) != checksum {
+			if end == size {
+				break // the last record was only partly written
+			}
+			return fmt.Errorf("%w: checksum mismatch at offset %d", ErrCorruptRecord, offset)
+		}
+
+		if err := fs.indexRecord(offset+recordHeaderSize, payload); err != nil {
+			return err
+		}
+
+		offset = end
+	}
+
+	if offset != size {
+		if err := fs.file.Truncate(offset); err != nil {
+			return fmt.Errorf("truncating torn write at offset %d: %w", offset, err)
+		}
+		if err := fs.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	fs.size = offset
+
+	return nil
+}
+
+// zeroedFrom reports whether the segment only holds zero bytes from offset to size
+func (fs *FileStore) zeroedFrom(offset, size int64) (bool, error) {
+	r := bufio.NewReader(io.NewSectionReader(fs.file, offset, size-offset))
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != 0 {
+			return false, nil
+		}
+	}
+}
+
+func (fs *FileStore) syncLoop() {
+	defer fs.wg.Done()
+
+	ticker := time.NewTicker(fs.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fs.mu.RLock()
+			_ = fs.file.Sync() // best effort, the next tick or Close will retry
+			fs.mu.RUnlock()
+		case <-fs.done:
+			return
+		}
+	}
+}
+
+// Close syncs and closes the segment
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	if fs.closed {
+		fs.mu.Unlock()
+		return nil
+	}
+	fs.closed = true
+	close(fs.done)
+	fs.mu.Unlock()
+
+	fs.wg.Wait()
+
+	return errors.Join(fs.file.Sync(), fs.file.Close())
+}
+
+// startOperation reports an operation on the segment to the instrumentation and the logger
+func (fs *FileStore) startOperation(ctx context.Context, operation string, attrs ...slog.Attr) (context.Context, func(error)) {
+	return gosignal.StartStoreOperation(ctx, fs.instr, fs.logger, "eventstore.file", operation, fs.path, attrs...)
+}
+
+// Store stores a list of events, the whole list is persisted atomically
+func (fs *FileStore) Store(ctx context.Context, events []gosignal.Event) (err error) {
+	ctx, done := fs.startOperation(ctx, "store", eventsLogAttrs(events)...)
+	defer func() { done(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrFileStoreClosed
+	}
+
+	if err := fs.checkNew(events); err != nil {
+		return err
+	}
+
+	return fs.append(recordKindAppend, events)
+}
+
+// Append stores the events of all streams in one record, after checking every stream is at its
+// expected version and holds no tombstone
+func (fs *FileStore) Append(ctx context.Context, streams []sourcing.StreamAppend) (err error) {
+	var events []gosignal.Event
+	for _, stream := range streams {
+		events = append(events, stream.Events...)
+	}
+
+	ctx, done := fs.startOperation(ctx, "append", eventsLogAttrs(events)...)
+	defer func() { done(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrFileStoreClosed
+	}
+
+	for _, stream := range streams {
+		if version := fs.version(stream.AggregateID, stream.AggregateType); version != stream.ExpectedVersion {
+			return errors.Join(sourcing.ErrVersionConflict,
+				fmt.Errorf("aggregate %s is at version %d, expected %d", stream.AggregateID, version, stream.ExpectedVersion))
+		}
+	}
+
+	if err := fs.checkNew(events); err != nil {
+		return err
+	}
+
+	return fs.append(recordKindAppend, events)
+}
+
+// checkNew returns ErrVersionExists when an event's version is stored or repeated, and
+// sourcing.ErrAggregateDeleted when it continues a deleted stream. The caller must hold the lock.
+func (fs *FileStore) checkNew(events []gosignal.Event) error {
+	if err := rejectDeleted(events, fs.tombstoned); err != nil {
+		return err
+	}
+
+	seen := make(map[versionKey]bool)
+	for _, event := range events {
+		key := versionKey{aggregateType: event.AggregateType, aggregateID: event.AggregateID, version: event.Version}
+		if _, exists := fs.find(event.AggregateID, event.AggregateType, event.Version); exists || seen[key] {
+			return errors.Join(ErrVersionExists,
+				fmt.Errorf("aggregate %s with version %d", event.AggregateID, event.Version))
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// Load loads all events for a given aggregate id matching the options, the events of all aggregate
+// types are merged by version when the options don't name one
+func (fs *FileStore) Load(ctx context.Context, aggID string, options sourcing.LoadEventsOptions) (_ []gosignal.Event, err error) {
+	ctx, done := fs.startOperation(ctx, "load", slog.String(gosignal.LogKeyAggregateID, aggID))
+	defer func() { done(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if fs.closed {
+		return nil, ErrFileStoreClosed
+	}
+
+	var events []gosignal.Event
+	for aggregateType, entries := range fs.index[aggID] {
+		if options.AggregateType != "" && aggregateType != options.AggregateType {
+			continue
+		}
+
+		for _, entry := range entries {
+			if options.MinVersion != nil && entry.version < *options.MinVersion {
+				continue
+			}
+			if options.MaxVersion != nil && entry.version > *options.MaxVersion {
+				break
+			}
+
+			buf := make([]byte, entry.length)
+			if _, err := fs.file.ReadAt(buf, entry.offset); err != nil {
+				return nil, err
+			}
+
+			event, err := decodeEvent(buf)
+			if err != nil {
+				return nil, err
+			}
+
+			if !matchesLoadOptions(event, options) {
+				continue
+			}
+
+			events = append(events, event)
+		}
+	}
+
+	sortByVersion(events)
+
+	return events, nil
+}
+
+// Replace replaces an event with a new version, this mostly exists for legal compliance
+// purposes, your event store should be append-only. The event is stored under the given aggregate
+// id and version regardless of the id and version it carries. The event's aggregate type selects
+// the stream, without one the first aggregate type holding the version is used.
+func (fs *FileStore) Replace(ctx context.Context, id string, version uint64, event gosignal.Event) (err error) {
+	ctx, done := fs.startOperation(ctx, "replace", slog.String(gosignal.LogKeyAggregateID, id),
+		slog.Uint64(gosignal.LogKeyVersion, version), slog.String(gosignal.LogKeyEventType, event.Type))
+	defer func() { done(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrFileStoreClosed
+	}
+
+	aggregateType := fs.replacedType(id, event.AggregateType, version)
+	if _, ok := fs.find(id, aggregateType, version); !ok {
+		return errors.Join(sourcing.ErrVersionNotFound, fmt.Errorf("aggregate %s with version %d", id, version))
+	}
+
+	event.AggregateID = id
+	event.AggregateType = aggregateType
+	event.Version = version
+
+	return fs.append(recordKindReplace, []gosignal.Event{event})
+}
+
+// Delete deletes all events of the aggregate, limited to the aggregate type if it is not empty.
+// The deletion is recorded in the segment, the deleted events' bytes remain in it until it is
+// rewritten by other means.
+func (fs *FileStore) Delete(ctx context.Context, aggID string, aggregateType string) (err error) {
+	ctx, done := fs.startOperation(ctx, "delete", slog.String(gosignal.LogKeyAggregateID, aggID))
+	defer func() { done(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrFileStoreClosed
+	}
+
+	if _, ok := fs.index[aggID]; !ok {
+		return nil
+	}
+
+	return fs.append(recordKindDelete, []gosignal.Event{{AggregateID: aggID, AggregateType: aggregateType}})
+}
+
+// AggregateIDs returns the ids of all aggregates in the store, sorted
+func (fs *FileStore) AggregateIDs(ctx context.Context) (_ []string, err error) {
+	ctx, done := fs.startOperation(ctx, "aggregate_ids")
+	defer func() { done(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if fs.closed {
+		return nil, ErrFileStoreClosed
+	}
+
+	ids := make([]string, 0, len(fs.index))
+	for id := range fs.index {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// AggregateIDsOfType returns the ids of all aggregates with events of the aggregate type, sorted
+func (fs *FileStore) AggregateIDsOfType(ctx context.Context, aggregateType string) (_ []string, err error) {
+	ctx, done := fs.startOperation(ctx, "aggregate_ids_of_type", slog.String(gosignal.LogKeyAggregateType, aggregateType))
+	defer func() { done(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if fs.closed {
+		return nil, ErrFileStoreClosed
+	}
+
+	var ids []string
+	for id, streams := range fs.index {
+		if _, ok := streams[aggregateType]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// append writes a record and indexes it, the caller must hold the write lock
+func (fs *FileStore) append(kind byte, events []gosignal.Event) error {
+	payload := []byte{kind}
+	payload = binary.AppendUvarint(payload, uint64(len(events)))
+	for _, event := range events {
+		encoded, err := encodeEvent(event)
+		if err != nil {
+			return err
+		}
+		payload = binary.AppendUvarint(payload, uint64(len(encoded)))
+		payload = append(payload, encoded...)
+	}
+
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("record of %d bytes exceeds the maximum of %d", len(payload), maxRecordSize)
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, castagnoli))
+	record = append(record, payload...)
+
+	if _, err := fs.file.WriteAt(record, fs.size); err != nil {
+		// drop whatever part of the record made it to disk so the next write starts clean
+		return errors.Join(err, fs.file.Truncate(fs.size))
+	}
+
+	if fs.policy == FsyncAlways {
+		if err := fs.file.Sync(); err != nil {
+			return errors.Join(err, fs.file.Truncate(fs.size))
+		}
+	}
+
+	if err := fs.indexRecord(fs.size+recordHeaderSize, payload); err != nil {
+		return err
+	}
+	fs.size += int64(len(record))
+
+	return nil
+}
+
+// indexRecord adds the events of a record payload located at offset to the index
+func (fs *FileStore) indexRecord(offset int64, payload []byte) error {
+	if len(payload) == 0 {
+		return ErrCorruptRecord
+	}
+
+	kind := payload[0]
+	pos := 1
+
+	count, n := binary.Uvarint(payload[pos:])
+	if n <= 0 {
+		return ErrCorruptRecord
+	}
+	pos += n
+
+	for i := uint64(0); i < count; i++ {
+		length, n := binary.Uvarint(payload[pos:])
+		if n <= 0 || uint64(len(payload)-pos-n) < length {
+			return ErrCorruptRecord
+		}
+		pos += n
+
+		event, err := decodeEvent(payload[pos : pos+int(length)])
+		if err != nil {
+			return err
+		}
+
+		entry := indexEntry{version: event.Version, offset: offset + int64(pos), length: int(length),
+			tombstone: event.Type == sourcing.TombstoneEventType}
+		switch kind {
+		case recordKindDelete:
+			fs.unindex(event.AggregateID, event.AggregateType)
+		case recordKindReplace:
+			fs.insert(event.AggregateID, fs.replacedType(event.AggregateID, event.AggregateType, event.Version), entry, true)
+		default:
+			fs.insert(event.AggregateID, event.AggregateType, entry, false)
+		}
+
+		pos += int(length)
+	}
+
+	return nil
+}
+
+// unindex removes the aggregate's events from the index, only those of the aggregate type if set
+func (fs *FileStore) unindex(aggID, aggregateType string) {
+	if aggregateType == "" {
+		delete(fs.index, aggID)
+		return
+	}
+
+	delete(fs.index[aggID], aggregateType)
+	if len(fs.index[aggID]) == 0 {
+		delete(fs.index, aggID)
+	}
+}
+
+// version returns the version a stream is at, one past its last event, across all aggregate types
+// if the type is empty
+func (fs *FileStore) version(aggID, aggregateType string) uint64 {
+	var version uint64
+	for t, entries := range fs.index[aggID] {
+		if (aggregateType == "" || t == aggregateType) && len(entries) > 0 {
+			version = max(version, entries[len(entries)-1].version+1)
+		}
+	}
+	return version
+}
+
+// tombstoned reports whether a stream holds a tombstone, across all aggregate types if the type is
+// empty
+func (fs *FileStore) tombstoned(aggID, aggregateType string) bool {
+	for t, entries := range fs.index[aggID] {
+		if aggregateType != "" && t != aggregateType {
+			continue
+		}
+		if slices.ContainsFunc(entries, func(entry indexEntry) bool { return entry.tombstone }) {
+			return true
+		}
+	}
+	return false
+}
+
+// replacedType returns the aggregate type of the stream a replacement goes to, the first type
+// holding the version when the replacement has none
+func (fs *FileStore) replacedType(aggID, aggregateType string, version uint64) string {
+	if aggregateType != "" {
+		return aggregateType
+	}
+
+	types := make([]string, 0, len(fs.index[aggID]))
+	for t := range fs.index[aggID] {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, t := range types {
+		if _, ok := fs.find(aggID, t, version); ok {
+			return t
+		}
+	}
+	return ""
+}
+
+// find returns the position of a version in a stream's index
+func (fs *FileStore) find(aggID, aggregateType string, version uint64) (int, bool) {
+	entries := fs.index[aggID][aggregateType]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].version >= version })
+	return i, i < len(entries) && entries[i].version == version
+}
+
+// insert keeps a stream's index ordered by version, replacing an existing entry if requested
+func (fs *FileStore) insert(aggID, aggregateType string, entry indexEntry, replace bool) {
+	if fs.index[aggID] == nil {
+		fs.index[aggID] = make(map[string][]indexEntry)
+	}
+
+	i, exists := fs.find(aggID, aggregateType, entry.version)
+	if exists && replace {
+		fs.index[aggID][aggregateType][i] = entry
+		return
+	}
+	fs.index[aggID][aggregateType] = slices.Insert(fs.index[aggID][aggregateType], i, entry)
+}
+
+// sortByVersion orders events merged from several streams by version, then aggregate type
+func sortByVersion(events []gosignal.Event) {
+	slices.SortFunc(events, func(a, b gosignal.Event) int {
+		if c := cmp.Compare(a.Version, b.Version); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.AggregateType, b.AggregateType)
+	})
+}
+
+func matchesLoadOptions(event gosignal.Event, options sourcing.LoadEventsOptions) bool {
+	if options.AggregateType != "" && event.AggregateType != options.AggregateType {
+		return false
+	}
+	if len(options.EventTypes) > 0 && !slices.Contains(options.EventTypes, event.Type) {
+		return false
+	}
+	if options.FromTime != nil && event.Timestamp.Before(*options.FromTime) {
+		return false
+	}
+	if options.ToTime != nil && event.Timestamp.After(*options.ToTime) {
+		return false
+	}
+	return true
+}
+
+func encodeEvent(event gosignal.Event) ([]byte, error) {
+	ts, err := event.Timestamp.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := binary.AppendUvarint(nil, event.Version)
+	for _, field := range [][]byte{ts, []byte(event.Type), []byte(event.AggregateID), []byte(event.AggregateType), event.Data} {
+		buf = binary.AppendUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+	}
+
+	// appended last so records written before schema versions existed still decode
+	buf = binary.AppendUvarint(buf, event.SchemaVersion)
+
+	return buf, nil
+}
+
+func decodeEvent(buf []byte) (gosignal.Event, error) {
+	var event gosignal.Event
+
+	version, pos := binary.Uvarint(buf)
+	if pos <= 0 {
+		return event, ErrCorruptRecord
+	}
+	event.Version = version
+
+	fields := make([][]byte, 5)
+	for i := range fields {
+		length, n := binary.Uvarint(buf[pos:])
+		if n <= 0 || uint64(len(buf)-pos-n) < length {
+			return event, ErrCorruptRecord
+		}
+		pos += n
+		fields[i] = buf[pos : pos+int(length)]
+		pos += int(length)
+	}
+
+	if err := event.Timestamp.UnmarshalBinary(fields[0]); err != nil {
+		return event, errors.Join(ErrCorruptRecord, err)
+	}
+	event.Type = string(fields[1])
+	event.AggregateID = string(fields[2])
+	event.AggregateType = string(fields[3])
+	if len(fields[4]) > 0 {
+		event.Data = append([]byte(nil), fields[4]...)
+	}
+
+	if pos < len(buf) {
+		schemaVersion, n := binary.Uvarint(buf[pos:])
+		if n <= 0 {
+			return event, ErrCorruptRecord
+		}
+		event.SchemaVersion = schemaVersion
+	}
+
+	return event, nil
+}
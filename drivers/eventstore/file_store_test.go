@@ -0,0 +1,471 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+func openTestFileStore(t *testing.T, path string, options ...FileStoreOption) *FileStore {
+	t.Helper()
+	fs, err := OpenFileStore(path, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fs.Close() })
+	return fs
+}
+
+func testEvents(aggID string, from, to uint64, base time.Time) []gosignal.Event {
+	var events []gosignal.Event
+	for v := from; v <= to; v++ {
+		events = append(events, gosignal.Event{
+			Type:          "incremented",
+			Data:          []byte{byte(v)},
+			Version:       v,
+			Timestamp:     base.Add(time.Duration(v) * time.Millisecond),
+			AggregateID:   aggID,
+			AggregateType: "counter",
+		})
+	}
+	return events
+}
+
+func TestFileStoreStoreAndLoad(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fs := openTestFileStore(t, path)
+	if err := fs.Store(ctx, testEvents("a", 0, 4, base)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, testEvents("b", 0, 1, base)); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := fs.Load(ctx, "a", sourcing.LoadEventsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	for i, event := range events {
+		if event.Version != uint64(i) || event.AggregateID != "a" || event.AggregateType != "counter" {
+			t.Fatalf("unexpected event at %d: %+v", i, event)
+		}
+		if !event.Timestamp.Equal(base.Add(time.Duration(i) * time.Millisecond)) {
+			t.Fatalf("timestamp lost precision: %s", event.Timestamp)
+		}
+	}
+
+	if err := fs.Store(ctx, testEvents("a", 4, 4, base)); !errors.Is(err, ErrVersionExists) {
+		t.Fatalf("expected ErrVersionExists, got %v", err)
+	}
+}
+
+func TestFileStoreLoadOptions(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs := openTestFileStore(t, filepath.Join(t.TempDir(), "events.log"))
+
+	events := testEvents("a", 0, 9, base)
+	events[3].Type = "reset"
+	if err := fs.Store(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	minVer, maxVer := uint64(2), uint64(6)
+	from, to := base.Add(3*time.Millisecond), base.Add(8*time.Millisecond)
+
+	tests := []struct {
+		name    string
+		options sourcing.LoadEventsOptions
+		want    []uint64
+	}{
+		{"version range", sourcing.LoadEventsOptions{MinVersion: &minVer, MaxVersion: &maxVer}, []uint64{2, 3, 4, 5, 6}},
+		{"event types", sourcing.LoadEventsOptions{EventTypes: []string{"reset"}}, []uint64{3}},
+		{"time range", sourcing.LoadEventsOptions{FromTime: &from, ToTime: &to}, []uint64{3, 4, 5, 6, 7, 8}},
+		{"other aggregate type", sourcing.LoadEventsOptions{AggregateType: "other"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fs.Load(ctx, "a", tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d events, got %d", len(tt.want), len(got))
+			}
+			for i, v := range tt.want {
+				if got[i].Version != v {
+					t.Fatalf("expected version %d at %d, got %d", v, i, got[i].Version)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStoreReplaceSurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, testEvents("a", 0, 2, base)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Replace(ctx, "a", 1, gosignal.Event{Type: "redacted", Timestamp: base}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Replace(ctx, "a", 7, gosignal.Event{Type: "redacted"}); !errors.Is(err, sourcing.ErrVersionNotFound) {
+		t.Fatalf("expected ErrVersionNotFound, got %v", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openTestFileStore(t, path)
+	events, err := fs.Load(ctx, "a", sourcing.LoadEventsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[1].Type != "redacted" || events[1].Version != 1 || events[1].Data != nil {
+		t.Fatalf("expected replaced event, got %+v", events[1])
+	}
+}
+
+func TestFileStoreAggregateTypesShareIDs(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := gosignal.Event{Type: "placed", AggregateID: "1", AggregateType: "order"}
+	customer := gosignal.Event{Type: "registered", AggregateID: "1", AggregateType: "customer"}
+	if err := fs.Store(ctx, []gosignal.Event{order}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, []gosignal.Event{customer}); err != nil {
+		t.Fatalf("expected another aggregate type to reuse the id, got %v", err)
+	}
+	if err := fs.Replace(ctx, "1", 0, gosignal.Event{Type: "redacted", AggregateType: "order"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openTestFileStore(t, path)
+	if events, _ := fs.Load(ctx, "1", sourcing.LoadEventsOptions{AggregateType: "customer"}); len(events) != 1 || events[0].Type != "registered" {
+		t.Fatalf("expected the customer's event to survive replacing the order, got %+v", events)
+	}
+	if events, _ := fs.Load(ctx, "1", sourcing.LoadEventsOptions{AggregateType: "order"}); len(events) != 1 || events[0].Type != "redacted" {
+		t.Fatalf("expected the replaced order event, got %+v", events)
+	}
+	if events, _ := fs.Load(ctx, "1", sourcing.LoadEventsOptions{}); len(events) != 2 {
+		t.Fatalf("expected both streams without a type, got %+v", events)
+	}
+}
+
+func TestFileStoreDeleteSurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/events.log"
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, append(testEvents("a", 0, 2, base), testEvents("b", 0, 0, base)...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(ctx, "a", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(ctx, "a", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openTestFileStore(t, path)
+	ids, err := fs.AggregateIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("expected only aggregate b to remain, got %v", ids)
+	}
+	if ids, _ := fs.AggregateIDsOfType(ctx, "counter"); len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("expected the reopened index to list b by type, got %v", ids)
+	}
+
+	// a deleted aggregate's versions can be written again
+	if err := fs.Store(ctx, testEvents("a", 0, 0, base)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStoreAppend(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fs := openTestFileStore(t, path)
+	if err := fs.Store(ctx, testEvents("a", 0, 1, base)); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := []sourcing.StreamAppend{
+		{AggregateID: "b", AggregateType: "counter", Events: testEvents("b", 0, 0, base)},
+		{AggregateID: "a", AggregateType: "counter", ExpectedVersion: 1, Events: testEvents("a", 1, 1, base)},
+	}
+	if err := fs.Append(ctx, stale); !errors.Is(err, sourcing.ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+	if ids, _ := fs.AggregateIDs(ctx); len(ids) != 1 {
+		t.Fatalf("expected nothing stored on conflict, got aggregates %v", ids)
+	}
+
+	current := []sourcing.StreamAppend{
+		{AggregateID: "b", AggregateType: "counter", Events: testEvents("b", 0, 0, base)},
+		{AggregateID: "a", AggregateType: "counter", ExpectedVersion: 2, Events: testEvents("a", 2, 3, base)},
+	}
+	if err := fs.Append(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+
+	tombstone := gosignal.Event{Type: sourcing.TombstoneEventType, Version: 1, AggregateID: "b", AggregateType: "counter"}
+	if err := fs.Store(ctx, []gosignal.Event{tombstone}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openTestFileStore(t, path)
+	if events, _ := fs.Load(ctx, "a", sourcing.LoadEventsOptions{}); len(events) != 4 {
+		t.Fatalf("expected 4 events for a after reopening, got %d", len(events))
+	}
+	deleted := []sourcing.StreamAppend{{AggregateID: "b", AggregateType: "counter", ExpectedVersion: 2, Events: testEvents("b", 2, 2, base)}}
+	if err := fs.Append(ctx, deleted); !errors.Is(err, sourcing.ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted, got %v", err)
+	}
+	if err := fs.Store(ctx, testEvents("b", 2, 2, base)); !errors.Is(err, sourcing.ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted on store, got %v", err)
+	}
+}
+
+func TestFileStoreInstrumentation(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	instr := &gosignal.MemoryInstrumentation{}
+
+	fs := openTestFileStore(t, path, WithFileStoreInstrumentation(instr))
+	if err := fs.Store(ctx, testEvents("a", 0, 1, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, testEvents("a", 1, 1, time.Now())); !errors.Is(err, ErrVersionExists) {
+		t.Fatalf("expected ErrVersionExists, got %v", err)
+	}
+
+	spans := instr.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+	if s := spans[0]; s.Name != "gosignal.eventstore.file.store" || !s.Ended || s.Err != nil {
+		t.Fatalf("unexpected store span %+v", s)
+	}
+	if got := instr.Counter("gosignal.eventstore.file.store.errors"); got != 1 {
+		t.Fatalf("expected the duplicate store to be counted, got %d", got)
+	}
+}
+
+func TestFileStoreRecoversFromTornWrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fs, err := OpenFileStore(path, WithFsyncPolicy(FsyncNever))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, testEvents("a", 0, 1, base)); err != nil {
+		t.Fatal(err)
+	}
+	goodSize := fs.size
+	if err := fs.Store(ctx, testEvents("a", 2, 3, base)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash half way through writing the second record
+	if err := os.Truncate(path, goodSize+(fs.size-goodSize)/2); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openTestFileStore(t, path)
+	events, err := fs.Load(ctx, "a", sourcing.LoadEventsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected the torn batch to be dropped entirely, got %d events", len(events))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != goodSize {
+		t.Fatalf("expected segment to be truncated to %d, got %d", goodSize, info.Size())
+	}
+
+	// the store must accept the lost versions again after recovery
+	if err := fs.Store(ctx, testEvents("a", 2, 3, base)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStoreDropsRecordWithBadChecksum(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, testEvents("a", 0, 0, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a byte inside the payload of the only record
+	if _, err := f.WriteAt([]byte{0xff}, fs.size-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openTestFileStore(t, path)
+	events, err := fs.Load(ctx, "a", sourcing.LoadEventsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected corrupt record to be discarded, got %d events", len(events))
+	}
+}
+
+func TestFileStoreRejectsCorruptionBeforeValidRecords(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, testEvents("a", 0, 0, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	firstEnd := fs.size
+	if err := fs.Store(ctx, testEvents("a", 1, 1, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	size := fs.size
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a byte inside the payload of the first record, the second one is intact
+	if _, err := f.WriteAt([]byte{0xff}, firstEnd-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFileStore(path); !errors.Is(err, ErrCorruptRecord) {
+		t.Fatalf("expected ErrCorruptRecord, got %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Fatalf("expected the segment to be left untouched at %d bytes, got %d", size, info.Size())
+	}
+}
+
+func TestFileStoreTruncatesZeroedTail(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Store(ctx, testEvents("a", 0, 0, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	size := fs.size
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash after the file was extended but before the record was written
+	if err := os.Truncate(path, size+64); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openTestFileStore(t, path)
+	if events, err := fs.Load(ctx, "a", sourcing.LoadEventsOptions{}); err != nil || len(events) != 1 {
+		t.Fatalf("expected the valid record to survive, got %d events, %v", len(events), err)
+	}
+	if fs.size != size {
+		t.Fatalf("expected the segment to be truncated to %d, got %d", size, fs.size)
+	}
+}
+
+func TestOpenFileStoreRejectsForeignFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not-a-segment")
+	if err := os.WriteFile(path, []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFileStore(path); !errors.Is(err, ErrInvalidSegment) {
+		t.Fatalf("expected ErrInvalidSegment, got %v", err)
+	}
+}
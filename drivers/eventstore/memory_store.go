@@ -0,0 +1,287 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+// MemoryStore is an event store that keeps events in memory, intended for tests and prototyping.
+// Streams are kept per aggregate type and id, so aggregate types can share ids. The zero value is
+// ready to use.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	events map[string]map[string][]gosignal.Event // aggregate id, then aggregate type
+}
+
+// Store stores a list of events, rejecting versions that already exist for the aggregate and events
+// for a deleted one
+func (ms *MemoryStore) Store(ctx context.Context, events []gosignal.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.store(events)
+}
+
+// Append stores the events of all streams atomically, after checking every stream is at its
+// expected version and not deleted
+func (ms *MemoryStore) Append(ctx context.Context, streams []sourcing.StreamAppend) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var events []gosignal.Event
+	for _, stream := range streams {
+		if version := ms.version(stream.AggregateID, stream.AggregateType); version != stream.ExpectedVersion {
+			return errors.Join(sourcing.ErrVersionConflict,
+				fmt.Errorf("aggregate %s is at version %d, expected %d", stream.AggregateID, version, stream.ExpectedVersion))
+		}
+		events = append(events, stream.Events...)
+	}
+
+	return ms.store(events)
+}
+
+// versionKey identifies a version of one stream
+type versionKey struct {
+	aggregateType, aggregateID string
+	version                    uint64
+}
+
+// rejectDeleted returns sourcing.ErrAggregateDeleted when an event continues a stream holding a
+// tombstone, stored or earlier in the events. tombstoned reports the stored tombstones.
+func rejectDeleted(events []gosignal.Event, tombstoned func(aggID, aggregateType string) bool) error {
+	type streamKey struct{ aggregateType, aggregateID string }
+	deleted := make(map[streamKey]bool)
+
+	for _, event := range events {
+		key := streamKey{aggregateType: event.AggregateType, aggregateID: event.AggregateID}
+		checked, ok := deleted[key]
+		if !ok {
+			checked = tombstoned(event.AggregateID, event.AggregateType)
+			deleted[key] = checked
+		}
+		if checked {
+			return errors.Join(sourcing.ErrAggregateDeleted, fmt.Errorf("aggregate id: %s", event.AggregateID))
+		}
+		if event.Type == sourcing.TombstoneEventType {
+			deleted[key] = true
+		}
+	}
+
+	return nil
+}
+
+// store stores the events, the caller must hold the write lock
+func (ms *MemoryStore) store(events []gosignal.Event) error {
+	if err := rejectDeleted(events, ms.tombstoned); err != nil {
+		return err
+	}
+
+	if ms.events == nil {
+		ms.events = make(map[string]map[string][]gosignal.Event)
+	}
+
+	seen := make(map[versionKey]bool)
+	for _, event := range events {
+		key := versionKey{aggregateType: event.AggregateType, aggregateID: event.AggregateID, version: event.Version}
+		if _, exists := ms.find(event.AggregateID, event.AggregateType, event.Version); exists || seen[key] {
+			return errors.Join(ErrVersionExists,
+				fmt.Errorf("aggregate %s with version %d", event.AggregateID, event.Version))
+		}
+		seen[key] = true
+	}
+
+	for _, event := range events {
+		if ms.events[event.AggregateID] == nil {
+			ms.events[event.AggregateID] = make(map[string][]gosignal.Event)
+		}
+		i, _ := ms.find(event.AggregateID, event.AggregateType, event.Version)
+		ms.events[event.AggregateID][event.AggregateType] = slices.Insert(ms.events[event.AggregateID][event.AggregateType], i, event)
+	}
+
+	return nil
+}
+
+// Load loads all events for a given aggregate id matching the options, the events of all aggregate
+// types are merged by version when the options don't name one
+func (ms *MemoryStore) Load(ctx context.Context, aggID string, options sourcing.LoadEventsOptions) ([]gosignal.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var events []gosignal.Event
+	for aggregateType, stream := range ms.events[aggID] {
+		if options.AggregateType != "" && aggregateType != options.AggregateType {
+			continue
+		}
+		for _, event := range stream {
+			if options.MinVersion != nil && event.Version < *options.MinVersion {
+				continue
+			}
+			if options.MaxVersion != nil && event.Version > *options.MaxVersion {
+				break
+			}
+			if matchesLoadOptions(event, options) {
+				events = append(events, event)
+			}
+		}
+	}
+
+	sortByVersion(events)
+
+	return events, nil
+}
+
+// Replace replaces an event with a new version, this mostly exists for legal compliance
+// purposes, your event store should be append-only. The event's aggregate type selects the
+// stream, without one the first aggregate type holding the version is used.
+func (ms *MemoryStore) Replace(ctx context.Context, id string, version uint64, event gosignal.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	aggregateType := event.AggregateType
+	if aggregateType == "" {
+		for _, t := range ms.types(id) {
+			if _, ok := ms.find(id, t, version); ok {
+				aggregateType = t
+				break
+			}
+		}
+	}
+
+	i, ok := ms.find(id, aggregateType, version)
+	if !ok {
+		return errors.Join(sourcing.ErrVersionNotFound, fmt.Errorf("aggregate %s with version %d", id, version))
+	}
+
+	event.AggregateID = id
+	event.AggregateType = aggregateType
+	event.Version = version
+	ms.events[id][aggregateType][i] = event
+
+	return nil
+}
+
+// Delete deletes all events of the aggregate, limited to the aggregate type if it is not empty
+func (ms *MemoryStore) Delete(ctx context.Context, aggID string, aggregateType string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if aggregateType == "" {
+		delete(ms.events, aggID)
+		return nil
+	}
+
+	delete(ms.events[aggID], aggregateType)
+	if len(ms.events[aggID]) == 0 {
+		delete(ms.events, aggID)
+	}
+
+	return nil
+}
+
+// AggregateIDs returns the ids of all aggregates in the store, sorted
+func (ms *MemoryStore) AggregateIDs(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	ids := make([]string, 0, len(ms.events))
+	for id := range ms.events {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// AggregateIDsOfType returns the ids of all aggregates with events of the aggregate type, sorted
+func (ms *MemoryStore) AggregateIDsOfType(ctx context.Context, aggregateType string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var ids []string
+	for id, streams := range ms.events {
+		if _, ok := streams[aggregateType]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// types returns the aggregate types holding events for the aggregate id, sorted
+func (ms *MemoryStore) types(aggID string) []string {
+	types := make([]string, 0, len(ms.events[aggID]))
+	for aggregateType := range ms.events[aggID] {
+		types = append(types, aggregateType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// version returns the version a stream is at, one past its last event, across all aggregate types
+// if the type is empty
+func (ms *MemoryStore) version(aggID string, aggregateType string) uint64 {
+	var version uint64
+	for t, stream := range ms.events[aggID] {
+		if (aggregateType == "" || t == aggregateType) && len(stream) > 0 {
+			version = max(version, stream[len(stream)-1].Version+1)
+		}
+	}
+	return version
+}
+
+// tombstoned reports whether a stream holds a tombstone, across all aggregate types if the type is
+// empty
+func (ms *MemoryStore) tombstoned(aggID string, aggregateType string) bool {
+	for t, stream := range ms.events[aggID] {
+		if aggregateType != "" && t != aggregateType {
+			continue
+		}
+		if slices.ContainsFunc(stream, func(event gosignal.Event) bool { return event.Type == sourcing.TombstoneEventType }) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the position of a version in a stream
+func (ms *MemoryStore) find(aggID, aggregateType string, version uint64) (int, bool) {
+	stream := ms.events[aggID][aggregateType]
+	i := sort.Search(len(stream), func(i int) bool { return stream[i].Version >= version })
+	return i, i < len(stream) && stream[i].Version == version
+}
@@ -0,0 +1,157 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ms MemoryStore
+
+	// stored out of order, loaded in version order
+	if err := ms.Store(ctx, testEvents("a", 3, 4, base)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Store(ctx, testEvents("a", 0, 2, base)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Store(ctx, testEvents("a", 4, 4, base)); !errors.Is(err, ErrVersionExists) {
+		t.Fatalf("expected ErrVersionExists, got %v", err)
+	}
+
+	maxVer := uint64(3)
+	events, err := ms.Load(ctx, "a", sourcing.LoadEventsOptions{MaxVersion: &maxVer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, event := range events {
+		if event.Version != uint64(i) {
+			t.Fatalf("expected version %d at %d, got %d", i, i, event.Version)
+		}
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+
+	if err := ms.Replace(ctx, "a", 1, gosignal.Event{Type: "redacted", AggregateType: "counter"}); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := ms.Load(ctx, "a", sourcing.LoadEventsOptions{EventTypes: []string{"redacted"}}); len(events) != 1 || events[0].Version != 1 {
+		t.Fatalf("expected replaced event at version 1, got %+v", events)
+	}
+
+	if err := ms.Delete(ctx, "a", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := ms.Load(ctx, "a", sourcing.LoadEventsOptions{}); len(events) != 5 {
+		t.Fatalf("expected delete of another aggregate type to keep events, got %d", len(events))
+	}
+	if err := ms.Delete(ctx, "a", "counter"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := ms.AggregateIDs(ctx); len(ids) != 0 {
+		t.Fatalf("expected no aggregates after delete, got %v", ids)
+	}
+}
+
+func TestMemoryStoreAggregateTypesShareIDs(t *testing.T) {
+	ctx := context.Background()
+	var ms MemoryStore
+
+	order := gosignal.Event{Type: "placed", AggregateID: "1", AggregateType: "order"}
+	customer := gosignal.Event{Type: "registered", AggregateID: "1", AggregateType: "customer"}
+	if err := ms.Store(ctx, []gosignal.Event{order}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Store(ctx, []gosignal.Event{customer}); err != nil {
+		t.Fatalf("expected another aggregate type to reuse the id, got %v", err)
+	}
+
+	if events, _ := ms.Load(ctx, "1", sourcing.LoadEventsOptions{AggregateType: "customer"}); len(events) != 1 || events[0].Type != "registered" {
+		t.Fatalf("expected only the customer's event, got %+v", events)
+	}
+	if events, _ := ms.Load(ctx, "1", sourcing.LoadEventsOptions{}); len(events) != 2 {
+		t.Fatalf("expected both streams without a type, got %+v", events)
+	}
+
+	if err := ms.Replace(ctx, "1", 0, gosignal.Event{Type: "redacted", AggregateType: "order"}); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := ms.Load(ctx, "1", sourcing.LoadEventsOptions{AggregateType: "customer"}); events[0].Type != "registered" {
+		t.Fatalf("expected replacing the order to leave the customer alone, got %+v", events)
+	}
+
+	if err := ms.Delete(ctx, "1", "order"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := ms.AggregateIDsOfType(ctx, "customer"); len(ids) != 1 {
+		t.Fatalf("expected the customer to survive deleting the order, got %v", ids)
+	}
+}
+
+func TestMemoryStoreAppend(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ms MemoryStore
+
+	if err := ms.Store(ctx, testEvents("a", 0, 1, base)); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := []sourcing.StreamAppend{
+		{AggregateID: "b", AggregateType: "counter", Events: testEvents("b", 0, 0, base)},
+		{AggregateID: "a", AggregateType: "counter", ExpectedVersion: 1, Events: testEvents("a", 1, 1, base)},
+	}
+	if err := ms.Append(ctx, stale); !errors.Is(err, sourcing.ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+	if ids, _ := ms.AggregateIDs(ctx); len(ids) != 1 {
+		t.Fatalf("expected nothing stored on conflict, got aggregates %v", ids)
+	}
+
+	current := []sourcing.StreamAppend{
+		{AggregateID: "b", AggregateType: "counter", Events: testEvents("b", 0, 0, base)},
+		{AggregateID: "a", AggregateType: "counter", ExpectedVersion: 2, Events: testEvents("a", 2, 3, base)},
+		{AggregateID: "a", AggregateType: "other", Events: nil},
+	}
+	if err := ms.Append(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := ms.Load(ctx, "a", sourcing.LoadEventsOptions{}); len(events) != 4 {
+		t.Fatalf("expected 4 events for a, got %d", len(events))
+	}
+	if ids, _ := ms.AggregateIDsOfType(ctx, "counter"); len(ids) != 2 {
+		t.Fatalf("expected both counters listed, got %v", ids)
+	}
+	if ids, _ := ms.AggregateIDsOfType(ctx, "other"); len(ids) != 0 {
+		t.Fatalf("expected no aggregates of another type, got %v", ids)
+	}
+
+	tombstone := gosignal.Event{Type: sourcing.TombstoneEventType, Version: 1, AggregateID: "b", AggregateType: "counter"}
+	if err := ms.Store(ctx, []gosignal.Event{tombstone}); err != nil {
+		t.Fatal(err)
+	}
+	deleted := []sourcing.StreamAppend{{AggregateID: "b", AggregateType: "counter", ExpectedVersion: 2, Events: testEvents("b", 2, 2, base)}}
+	if err := ms.Append(ctx, deleted); !errors.Is(err, sourcing.ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted, got %v", err)
+	}
+	if err := ms.Store(ctx, testEvents("b", 2, 2, base)); !errors.Is(err, sourcing.ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted on store, got %v", err)
+	}
+
+	batch := append([]gosignal.Event{{Type: sourcing.TombstoneEventType, Version: 4, AggregateID: "a", AggregateType: "counter"}},
+		testEvents("a", 5, 5, base)...)
+	if err := ms.Store(ctx, batch); !errors.Is(err, sourcing.ErrAggregateDeleted) {
+		t.Fatalf("expected events after a tombstone in the batch to be rejected, got %v", err)
+	}
+	if events, _ := ms.Load(ctx, "a", sourcing.LoadEventsOptions{}); len(events) != 4 {
+		t.Fatalf("expected nothing stored from the rejected batch, got %d events", len(events))
+	}
+}
@@ -0,0 +1,141 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/drivers/sqltimestamp"
+)
+
+// SQLPendingStore is a sourcing.PendingPublicationStore keeping events that couldn't be published
+// in a table with the same schema as SQLStore, typically next to the events table in the same
+// database. The id column orders pending events by insertion. Instrumentation and Logger are used
+// like SQLStore's, for the "eventstore.sql_pending" store.
+type SQLPendingStore struct {
+	DB                      *sql.DB
+	TableName               string
+	PositionalPlaceholderFn func(int) string
+	TimestampEncoding       sqltimestamp.Encoding
+	Instrumentation         gosignal.Instrumentation
+	Logger                  *slog.Logger
+}
+
+// events returns a SQLStore on the table, for building statements
+func (ps SQLPendingStore) events() SQLStore {
+	return SQLStore{
+		TableName:               ps.TableName,
+		PositionalPlaceholderFn: ps.PositionalPlaceholderFn,
+		TimestampEncoding:       ps.TimestampEncoding,
+	}
+}
+
+// startOperation reports an operation on the table to the instrumentation and the logger
+func (ps SQLPendingStore) startOperation(ctx context.Context, operation string, attrs ...slog.Attr) (context.Context, func(error)) {
+	return gosignal.StartStoreOperation(ctx, ps.Instrumentation, ps.Logger, "eventstore.sql_pending", operation, ps.TableName, attrs...)
+}
+
+// AddPending records events for later publication in one transaction
+func (ps SQLPendingStore) AddPending(ctx context.Context, events []gosignal.Event) (err error) {
+	ctx, done := ps.startOperation(ctx, "add", eventsLogAttrs(events)...)
+	defer func() { done(err) }()
+
+	if ps.TableName == "" {
+		return ErrTableNameNotSet
+	}
+
+	tx, err := ps.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := ps.events().insertEvents(ctx, tx, events); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+// HasPending reports whether events of the event's aggregate are pending
+func (ps SQLPendingStore) HasPending(ctx context.Context, event gosignal.Event) (_ bool, err error) {
+	ctx, done := ps.startOperation(ctx, "has", slog.String(gosignal.LogKeyAggregateID, event.AggregateID))
+	defer func() { done(err) }()
+
+	if ps.TableName == "" {
+		return false, ErrTableNameNotSet
+	}
+
+	cb := conditionBuilder{pph: ps.events().pph}
+	cb.add("aggregate_id =", event.AggregateID)
+	cb.add("aggregate_type =", event.AggregateType)
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", ps.TableName) + cb.build()
+
+	var count int
+	if err := ps.DB.QueryRowContext(ctx, query, cb.opts...).Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// LoadPending returns up to limit pending events in insertion order
+func (ps SQLPendingStore) LoadPending(ctx context.Context, limit int) (events []gosignal.Event, err error) {
+	ctx, done := ps.startOperation(ctx, "load")
+	defer func() { done(err) }()
+
+	if ps.TableName == "" {
+		return nil, ErrTableNameNotSet
+	}
+
+	query := fmt.Sprintf(`SELECT type, data, version, timestamp, aggregate_id, aggregate_type, schema_version FROM %s ORDER BY id LIMIT %s`,
+		ps.TableName, ps.events().pph(1))
+
+	rows, err := ps.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, rows.Close(), rows.Err())
+	}()
+
+	for rows.Next() {
+		var event gosignal.Event
+		var timestamp interface{}
+		if err := rows.Scan(&event.Type, &event.Data, &event.Version, &timestamp, &event.AggregateID, &event.AggregateType, &event.SchemaVersion); err != nil {
+			return nil, err
+		}
+
+		if event.Timestamp, err = ps.TimestampEncoding.Decode(timestamp); err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// RemovePending removes a published event
+func (ps SQLPendingStore) RemovePending(ctx context.Context, event gosignal.Event) (err error) {
+	ctx, done := ps.startOperation(ctx, "remove",
+		slog.String(gosignal.LogKeyAggregateID, event.AggregateID), slog.Uint64(gosignal.LogKeyVersion, event.Version))
+	defer func() { done(err) }()
+
+	if ps.TableName == "" {
+		return ErrTableNameNotSet
+	}
+
+	cb := conditionBuilder{pph: ps.events().pph}
+	cb.add("aggregate_id =", event.AggregateID)
+	cb.add("version =", event.Version)
+	cb.add("aggregate_type =", event.AggregateType)
+
+	query := fmt.Sprintf("DELETE FROM %s", ps.TableName) + cb.build()
+
+	_, err = ps.DB.ExecContext(ctx, query, cb.opts...)
+	return err
+}
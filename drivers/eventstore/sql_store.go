@@ -5,17 +5,30 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"reflect"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/drivers/sqltimestamp"
 	"github.com/Howard3/gosignal/sourcing"
 )
 
 // ErrTableNameNotSet is the error returned when the table name is not set
 var ErrTableNameNotSet = errors.New("table name not set")
 
+// Bind parameter limits per statement for common dialects, for use with SQLStore.MaxBatchParams
+const (
+	MaxParamsSQLite    = 999 // SQLite before 3.32, the most restrictive and therefore the default
+	MaxParamsSQLServer = 2100
+	MaxParamsPostgres  = 65535
+	MaxParamsMySQL     = 65535
+)
+
+// insertColumnCount is the number of bind parameters used by each inserted event
+const insertColumnCount = 7
+
 type conditionBuilder struct {
 	args []string
 	opts []interface{}
@@ -23,8 +36,22 @@ type conditionBuilder struct {
 }
 
 func (cb *conditionBuilder) add(arg string, opt interface{}) {
-	cb.args = append(cb.args, arg)
 	cb.opts = append(cb.opts, opt)
+	cb.args = append(cb.args, fmt.Sprintf("%s %s", arg, cb.pph(len(cb.opts))))
+}
+
+// addIn adds a condition matching any of the values, nothing is added for an empty list
+func (cb *conditionBuilder) addIn(column string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		cb.opts = append(cb.opts, v)
+		placeholders[i] = cb.pph(len(cb.opts))
+	}
+	cb.args = append(cb.args, fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ", ")))
 }
 
 // addIfNotNil adds a condition if the value is not nil
@@ -43,15 +70,16 @@ func (cb *conditionBuilder) build() string {
 		} else {
 			conditions += " AND "
 		}
-		conditions += fmt.Sprintf("%s %s", v, cb.pph(i+1))
+		conditions += v
 	}
 
 	return conditions
 }
 
 // SQLStore is an opinionated store that uses a SQL database to store events
-// it mirrors the gosignal.Event struct for fields when storing the events. Only ony table must
-// be used per aggregate type as there is no expectation of columns for the aggregate type.
+// it mirrors the gosignal.Event struct for fields when storing the events. Multiple aggregate
+// types can share one table, the aggregate_type column holds the stream category and loads are
+// filtered on it when sourcing.LoadEventsOptions.AggregateType is set.
 //
 // it should use a schema that matches the following:
 // ```sql
@@ -62,14 +90,35 @@ func (cb *conditionBuilder) build() string {
 //		data BYTEA NOT NULL,
 //		version INT NOT NULL,
 //		timestamp INT NOT NULL,
-//		aggregate_id VARCHAR(255) NOT NULL
+//		aggregate_id VARCHAR(255) NOT NULL,
+//		aggregate_type VARCHAR(255) NOT NULL DEFAULT '',
+//		schema_version INT NOT NULL DEFAULT 0,
+//		UNIQUE (aggregate_type, aggregate_id, version)
 //	);
 //
 // ```
+//
+// The timestamp column type must match TimestampEncoding: INT for sqltimestamp.UnixSeconds (the
+// default), BIGINT for sqltimestamp.UnixNano, TIMESTAMP for sqltimestamp.Native and a text column
+// for sqltimestamp.RFC3339.
+//
+// The unique constraint provides optimistic concurrency, set IsUniqueViolation to recognise the
+// driver's error for it so Store reports sourcing.ErrVersionConflict.
+//
+// MaxBatchParams caps the bind parameters of each multi-row INSERT used by Store, it defaults to
+// MaxParamsSQLite. Setting it below the parameters of a single row inserts one event per statement.
+//
+// Instrumentation and Logger, when set, report every operation of the "eventstore.sql" store, see
+// gosignal.StartStoreOperation.
 type SQLStore struct {
 	DB                      *sql.DB
 	TableName               string
 	PositionalPlaceholderFn func(int) string
+	TimestampEncoding       sqltimestamp.Encoding
+	MaxBatchParams          int
+	IsUniqueViolation       func(error) bool
+	Instrumentation         gosignal.Instrumentation
+	Logger                  *slog.Logger
 }
 
 func PositionalPlaceholderDollarSign(i int) string {
@@ -83,44 +132,227 @@ func (ss SQLStore) pph(i int) string {
 	return PositionalPlaceholderDollarSign(i)
 }
 
+// startOperation reports an operation on the table to the instrumentation and the logger, attrs
+// are added to the log record
+func (ss SQLStore) startOperation(ctx context.Context, operation string, attrs ...slog.Attr) (context.Context, func(error)) {
+	return gosignal.StartStoreOperation(ctx, ss.Instrumentation, ss.Logger, "eventstore.sql", operation, ss.TableName, attrs...)
+}
+
 // Store stores a list of events for a given aggregate id
-func (ss SQLStore) Store(ctx context.Context, events []gosignal.Event) error {
-	if ss.TableName == "" {
-		return ErrTableNameNotSet
+// events are written with multi-row INSERT statements, chunked to stay under MaxBatchParams, events
+// for a deleted aggregate are rejected with sourcing.ErrAggregateDeleted
+func (ss SQLStore) Store(ctx context.Context, events []gosignal.Event) (err error) {
+	ctx, done := ss.startOperation(ctx, "store", eventsLogAttrs(events)...)
+	defer func() { done(err) }()
+
+	return ss.write(ctx, nil, events)
+}
+
+// Append stores the events of all streams in one transaction, after checking every stream is at
+// its expected version and holds no tombstone. The check reads each stream within the transaction,
+// the unique constraint catches writers racing it.
+func (ss SQLStore) Append(ctx context.Context, streams []sourcing.StreamAppend) (err error) {
+	var events []gosignal.Event
+	for _, stream := range streams {
+		events = append(events, stream.Events...)
 	}
 
-	placeholders := []string{ss.pph(1), ss.pph(2), ss.pph(3), ss.pph(4), ss.pph(5)}
+	ctx, done := ss.startOperation(ctx, "append", eventsLogAttrs(events)...)
+	defer func() { done(err) }()
 
-	query := fmt.Sprintf(`
-		INSERT INTO %s (type, data, version, timestamp, aggregate_id) 
-		VALUES (%s)`,
-		ss.TableName, strings.Join(placeholders, ", "))
+	return ss.write(ctx, streams, events)
+}
+
+// write inserts the events in one transaction, after checking the versions of the streams and that
+// none of the events continue a deleted stream
+func (ss SQLStore) write(ctx context.Context, streams []sourcing.StreamAppend, events []gosignal.Event) error {
+	if ss.TableName == "" {
+		return ErrTableNameNotSet
+	}
 
 	tx, err := ss.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
+	for _, stream := range streams {
+		if err := ss.checkVersion(ctx, tx, stream); err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	if err := ss.checkDeleted(ctx, tx, events); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	if err := ss.insertEvents(ctx, tx, events); err != nil {
+		if ss.IsUniqueViolation != nil && ss.IsUniqueViolation(err) {
+			err = errors.Join(sourcing.ErrVersionConflict, err)
+		}
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+// checkVersion returns sourcing.ErrVersionConflict unless the stream is at its expected version
+func (ss SQLStore) checkVersion(ctx context.Context, tx *sql.Tx, stream sourcing.StreamAppend) error {
+	var last sql.NullInt64
+	cb := conditionBuilder{pph: ss.pph}
+	cb.add("aggregate_id =", stream.AggregateID)
+	if stream.AggregateType != "" {
+		cb.add("aggregate_type =", stream.AggregateType)
+	}
+
+	query := fmt.Sprintf("SELECT MAX(version) FROM %s", ss.TableName) + cb.build()
+
+	if err := tx.QueryRowContext(ctx, query, cb.opts...).Scan(&last); err != nil {
+		return err
+	}
+
+	var version uint64
+	if last.Valid {
+		version = uint64(last.Int64) + 1
+	}
+
+	if version != stream.ExpectedVersion {
+		return errors.Join(sourcing.ErrVersionConflict,
+			fmt.Errorf("aggregate %s is at version %d, expected %d", stream.AggregateID, version, stream.ExpectedVersion))
+	}
+
+	return nil
+}
+
+// checkDeleted returns sourcing.ErrAggregateDeleted when an event continues a stream holding a
+// tombstone, reading the tombstones of the events' aggregates within the transaction
+func (ss SQLStore) checkDeleted(ctx context.Context, tx *sql.Tx, events []gosignal.Event) error {
+	var ids []string
 	for _, event := range events {
-		eventTimestamp := event.Timestamp.Unix()
+		if !slices.Contains(ids, event.AggregateID) {
+			ids = append(ids, event.AggregateID)
+		}
+	}
 
-		_, err := tx.ExecContext(ctx, query, event.Type, event.Data, event.Version, eventTimestamp, event.AggregateID)
+	type streamKey struct{ aggregateType, aggregateID string }
+	tombstones := make(map[streamKey]bool)
+	deleted := make(map[string]bool)
+
+	// one parameter is taken by the tombstone type
+	idsPerQuery := max(ss.maxBatchParams()-1, 1)
+	for start := 0; start < len(ids); start += idsPerQuery {
+		cb := conditionBuilder{pph: ss.pph}
+		cb.add("type =", sourcing.TombstoneEventType)
+		cb.addIn("aggregate_id", ids[start:min(start+idsPerQuery, len(ids))])
+
+		query := fmt.Sprintf("SELECT aggregate_id, aggregate_type FROM %s", ss.TableName) + cb.build()
+		rows, err := tx.QueryContext(ctx, query, cb.opts...)
 		if err != nil {
-			err := fmt.Errorf("when trying to update aggregate %s with version %d: %w", event.AggregateID, event.Version, err)
-			return errors.Join(err, tx.Rollback())
+			return err
+		}
+
+		for rows.Next() {
+			var key streamKey
+			if err := rows.Scan(&key.aggregateID, &key.aggregateType); err != nil {
+				return errors.Join(err, rows.Close())
+			}
+			tombstones[key] = true
+			deleted[key.aggregateID] = true
+		}
+		if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+			return err
 		}
 	}
 
-	return tx.Commit()
+	return rejectDeleted(events, func(aggID, aggregateType string) bool {
+		if aggregateType == "" {
+			return deleted[aggID]
+		}
+		return tombstones[streamKey{aggregateType: aggregateType, aggregateID: aggID}]
+	})
 }
 
-// Load loads all events for a given aggregate id
-// TODO: support max version, event types, and to/from timestamps
+// eventsLogAttrs describes stored events by their aggregate and the last version
+func eventsLogAttrs(events []gosignal.Event) []slog.Attr {
+	if len(events) == 0 {
+		return nil
+	}
+
+	last := events[len(events)-1]
+	return []slog.Attr{
+		slog.String(gosignal.LogKeyAggregateID, last.AggregateID),
+		slog.Uint64(gosignal.LogKeyVersion, last.Version),
+		slog.Int("events", len(events)),
+	}
+}
+
+// execer is the subset of *sql.DB and *sql.Tx used to run statements
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insertEvents inserts the events in chunks of rows that fit within the parameter limit
+func (ss SQLStore) insertEvents(ctx context.Context, db execer, events []gosignal.Event) error {
+	rowsPerStatement := ss.maxBatchParams() / insertColumnCount
+	if rowsPerStatement < 1 {
+		rowsPerStatement = 1
+	}
+
+	for start := 0; start < len(events); start += rowsPerStatement {
+		chunk := events[start:min(start+rowsPerStatement, len(events))]
+
+		query, args := ss.insertQuery(chunk)
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			first, last := chunk[0], chunk[len(chunk)-1]
+			return fmt.Errorf("when trying to update aggregate %s with versions %d to %d: %w",
+				first.AggregateID, first.Version, last.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// insertQuery builds a single INSERT statement for all of the events
+func (ss SQLStore) insertQuery(events []gosignal.Event) (string, []interface{}) {
+	rows := make([]string, 0, len(events))
+	args := make([]interface{}, 0, len(events)*insertColumnCount)
+
+	for i, event := range events {
+		placeholders := make([]string, insertColumnCount)
+		for col := range placeholders {
+			placeholders[col] = ss.pph(i*insertColumnCount + col + 1)
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+
+		args = append(args,
+			event.Type, event.Data, event.Version, ss.TimestampEncoding.Encode(event.Timestamp),
+			event.AggregateID, event.AggregateType, event.SchemaVersion)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (type, data, version, timestamp, aggregate_id, aggregate_type, schema_version) 
+		VALUES %s`,
+		ss.TableName, strings.Join(rows, ", "))
+
+	return query, args
+}
+
+func (ss SQLStore) maxBatchParams() int {
+	if ss.MaxBatchParams > 0 {
+		return ss.MaxBatchParams
+	}
+	return MaxParamsSQLite
+}
+
+// Load loads all events for a given aggregate id, ordered by version
+// time filters compare against the stored timestamps
 func (ss SQLStore) Load(ctx context.Context, aggID string, options sourcing.LoadEventsOptions) (evt []gosignal.Event, err error) {
+	ctx, done := ss.startOperation(ctx, "load", slog.String(gosignal.LogKeyAggregateID, aggID))
+	defer func() { done(err) }()
+
 	if ss.TableName == "" {
 		return nil, ErrTableNameNotSet
 	}
-	query := fmt.Sprintf(`SELECT type, data, version, timestamp FROM %s`, ss.TableName)
+	query := fmt.Sprintf(`SELECT type, data, version, timestamp, aggregate_type, schema_version FROM %s`, ss.TableName)
 
 	pph := ss.PositionalPlaceholderFn
 	if pph == nil {
@@ -131,26 +363,38 @@ func (ss SQLStore) Load(ctx context.Context, aggID string, options sourcing.Load
 	cb.add("aggregate_id =", aggID)
 	cb.addIfNotNil("version >=", options.MinVersion)
 	cb.addIfNotNil("version <=", options.MaxVersion)
+	if options.AggregateType != "" {
+		cb.add("aggregate_type =", options.AggregateType)
+	}
+	cb.addIn("type", options.EventTypes)
+	if options.FromTime != nil {
+		cb.add("timestamp >=", ss.TimestampEncoding.Encode(*options.FromTime))
+	}
+	if options.ToTime != nil {
+		cb.add("timestamp <=", ss.TimestampEncoding.Encode(*options.ToTime))
+	}
 
-	query += cb.build()
+	query += cb.build() + " ORDER BY version"
 
 	rows, err := ss.DB.QueryContext(ctx, query, cb.opts...)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
-		err = errors.Join(err, rows.Err())
+		err = errors.Join(err, rows.Close(), rows.Err())
 	}()
 
 	var events []gosignal.Event
 	for rows.Next() {
 		var event gosignal.Event
-		var timestamp int
-		if err := rows.Scan(&event.Type, &event.Data, &event.Version, &timestamp); err != nil {
+		var timestamp interface{}
+		if err := rows.Scan(&event.Type, &event.Data, &event.Version, &timestamp, &event.AggregateType, &event.SchemaVersion); err != nil {
 			return nil, err
 		}
 
-		event.Timestamp = time.Unix(int64(timestamp), 0)
+		if event.Timestamp, err = ss.TimestampEncoding.Decode(timestamp); err != nil {
+			return nil, err
+		}
 		event.AggregateID = aggID
 
 		events = append(events, event)
@@ -159,19 +403,99 @@ func (ss SQLStore) Load(ctx context.Context, aggID string, options sourcing.Load
 	return events, nil
 }
 
+// AggregateIDs returns the ids of all aggregates in the table, sorted
+func (ss SQLStore) AggregateIDs(ctx context.Context) (ids []string, err error) {
+	ctx, done := ss.startOperation(ctx, "aggregate_ids")
+	defer func() { done(err) }()
+
+	return ss.aggregateIDs(ctx, conditionBuilder{pph: ss.pph})
+}
+
+// AggregateIDsOfType returns the ids of all aggregates with events of the aggregate type, sorted
+func (ss SQLStore) AggregateIDsOfType(ctx context.Context, aggregateType string) (ids []string, err error) {
+	ctx, done := ss.startOperation(ctx, "aggregate_ids_of_type", slog.String(gosignal.LogKeyAggregateType, aggregateType))
+	defer func() { done(err) }()
+
+	cb := conditionBuilder{pph: ss.pph}
+	cb.add("aggregate_type =", aggregateType)
+	return ss.aggregateIDs(ctx, cb)
+}
+
+func (ss SQLStore) aggregateIDs(ctx context.Context, cb conditionBuilder) (ids []string, err error) {
+	if ss.TableName == "" {
+		return nil, ErrTableNameNotSet
+	}
+
+	query := fmt.Sprintf(`SELECT DISTINCT aggregate_id FROM %s`, ss.TableName) + cb.build() + " ORDER BY aggregate_id"
+
+	rows, err := ss.DB.QueryContext(ctx, query, cb.opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, rows.Close(), rows.Err())
+	}()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// Delete deletes all events of the aggregate, limited to the aggregate type if it is not empty
+func (ss SQLStore) Delete(ctx context.Context, aggID string, aggregateType string) (err error) {
+	ctx, done := ss.startOperation(ctx, "delete", slog.String(gosignal.LogKeyAggregateID, aggID))
+	defer func() { done(err) }()
+
+	if ss.TableName == "" {
+		return ErrTableNameNotSet
+	}
+
+	cb := conditionBuilder{pph: ss.pph}
+	cb.add("aggregate_id =", aggID)
+	if aggregateType != "" {
+		cb.add("aggregate_type =", aggregateType)
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s", ss.TableName) + cb.build()
+
+	_, err = ss.DB.ExecContext(ctx, query, cb.opts...)
+	return err
+}
+
 // Replace replaces an event with a new version, this mostly exists for legal compliance
 // purposes, your event store should be append-only
-func (ss SQLStore) Replace(ctx context.Context, id string, version uint64, event gosignal.Event) error {
+func (ss SQLStore) Replace(ctx context.Context, id string, version uint64, event gosignal.Event) (err error) {
+	ctx, done := ss.startOperation(ctx, "replace", slog.String(gosignal.LogKeyAggregateID, id),
+		slog.Uint64(gosignal.LogKeyVersion, version), slog.String(gosignal.LogKeyEventType, event.Type))
+	defer func() { done(err) }()
+
 	if ss.TableName == "" {
 		return ErrTableNameNotSet
 	}
 
-	eventTimestamp := event.Timestamp.Unix()
+	eventTimestamp := ss.TimestampEncoding.Encode(event.Timestamp)
 
-	query := fmt.Sprintf("UPDATE %s SET type = %s, data = %s, version = %s, timestamp = %s WHERE id = %s",
+	query := fmt.Sprintf("UPDATE %s SET type = %s, data = %s, version = %s, timestamp = %s, schema_version = %s",
 		ss.TableName,
 		ss.pph(1), ss.pph(2), ss.pph(3), ss.pph(4), ss.pph(5),
 	)
-	_, err := ss.DB.ExecContext(ctx, query, event.Type, event.Data, event.Version, eventTimestamp, id)
+
+	cb := conditionBuilder{pph: func(i int) string { return ss.pph(i + 5) }}
+	cb.add("aggregate_id =", id)
+	cb.add("version =", version)
+	if event.AggregateType != "" {
+		cb.add("aggregate_type =", event.AggregateType)
+	}
+
+	query += cb.build()
+	args := append([]interface{}{event.Type, event.Data, event.Version, eventTimestamp, event.SchemaVersion}, cb.opts...)
+
+	_, err = ss.DB.ExecContext(ctx, query, args...)
 	return err
 }
@@ -0,0 +1,432 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/drivers/internal/sqltest"
+	"github.com/Howard3/gosignal/drivers/sqltimestamp"
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+func manyEvents(n int) []gosignal.Event {
+	events := make([]gosignal.Event, n)
+	for i := range events {
+		events[i] = gosignal.Event{
+			Type:        "incremented",
+			Data:        []byte("{}"),
+			Version:     uint64(i),
+			Timestamp:   time.Unix(int64(i), 0),
+			AggregateID: "agg",
+		}
+	}
+	return events
+}
+
+// recordInserts makes the DB keep the arguments of every executed statement
+func recordInserts(db *sqltest.DB) *[][]driver.NamedValue {
+	var inserts [][]driver.NamedValue
+	db.Exec = func(_ string, args []driver.NamedValue) (int64, error) {
+		inserts = append(inserts, args)
+		return int64(len(args) / insertColumnCount), nil
+	}
+	return &inserts
+}
+
+func TestSQLStoreStoreChunksInserts(t *testing.T) {
+	db := sqltest.Open(t)
+	inserts := recordInserts(db)
+	ss := SQLStore{DB: db.DB, TableName: "events", MaxBatchParams: 700}
+
+	if err := ss.Store(context.Background(), manyEvents(250)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first statement checks for tombstones
+	queries, args := db.Queries()[1:], *inserts
+	if len(queries) != 3 || len(args) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(queries))
+	}
+
+	wantArgs := []int{700, 700, 350}
+	for i, query := range queries {
+		if len(args[i]) != wantArgs[i] {
+			t.Fatalf("statement %d: expected %d args, got %d", i, wantArgs[i], len(args[i]))
+		}
+		last := fmt.Sprintf("$%d)", wantArgs[i])
+		if !strings.Contains(query, "($1, $2, $3, $4, $5, $6, $7)") || !strings.HasSuffix(strings.TrimSpace(query), last) {
+			t.Fatalf("statement %d has unexpected placeholders: %s", i, query)
+		}
+	}
+
+	if version := args[1][2].Value; version != int64(100) {
+		t.Fatalf("expected second statement to start at version 100, got %v", version)
+	}
+}
+
+func TestSQLStoreStoreSingleRowStatements(t *testing.T) {
+	db := sqltest.Open(t)
+	inserts := recordInserts(db)
+	ss := SQLStore{DB: db.DB, TableName: "events", MaxBatchParams: 1}
+
+	if err := ss.Store(context.Background(), manyEvents(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*inserts) != 4 {
+		t.Fatalf("expected one statement per event, got %d", len(*inserts))
+	}
+}
+
+func benchmarkSQLStoreStore(b *testing.B, maxBatchParams int) {
+	// simulates the round-trip latency of a real database
+	db := sqltest.Open(b)
+	db.Exec = func(string, []driver.NamedValue) (int64, error) {
+		time.Sleep(20 * time.Microsecond)
+		return 1, nil
+	}
+	ss := SQLStore{DB: db.DB, TableName: "events", MaxBatchParams: maxBatchParams}
+	events := manyEvents(500)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ss.Store(ctx, events); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(events))/b.Elapsed().Seconds(), "events/s")
+}
+
+// BenchmarkSQLStoreStoreRowByRow mirrors the previous behaviour of one INSERT per event
+func BenchmarkSQLStoreStoreRowByRow(b *testing.B) { benchmarkSQLStoreStore(b, 1) }
+
+func BenchmarkSQLStoreStoreBatchedSQLite(b *testing.B) {
+	benchmarkSQLStoreStore(b, MaxParamsSQLite)
+}
+
+func BenchmarkSQLStoreStoreBatchedPostgres(b *testing.B) {
+	benchmarkSQLStoreStore(b, MaxParamsPostgres)
+}
+
+func TestSQLStoreLoadAppliesAllFilters(t *testing.T) {
+	db := sqltest.Open(t)
+	ss := SQLStore{DB: db.DB, TableName: "events", TimestampEncoding: sqltimestamp.UnixNano}
+
+	minVer, maxVer := uint64(2), uint64(9)
+	from, to := time.Unix(10, 0), time.Unix(20, 5)
+
+	_, err := ss.Load(context.Background(), "agg", sourcing.LoadEventsOptions{
+		MinVersion:    &minVer,
+		MaxVersion:    &maxVer,
+		EventTypes:    []string{"a", "b"},
+		FromTime:      &from,
+		ToTime:        &to,
+		AggregateType: "order",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SELECT type, data, version, timestamp, aggregate_type, schema_version FROM events WHERE aggregate_id = $1 AND version >= $2 " +
+		"AND version <= $3 AND aggregate_type = $4 AND type IN ($5, $6) AND timestamp >= $7 AND timestamp <= $8 ORDER BY version"
+	if got := db.LastQuery(); got != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", got, want)
+	}
+
+	args := db.LastArgs()
+	if args[6].Value != from.UnixNano() || args[7].Value != to.UnixNano() {
+		t.Fatalf("expected encoded time bounds, got %v and %v", args[6].Value, args[7].Value)
+	}
+}
+
+func TestSQLStoreReplaceTargetsAggregateVersion(t *testing.T) {
+	db := sqltest.Open(t)
+	ss := SQLStore{DB: db.DB, TableName: "events"}
+
+	err := ss.Replace(context.Background(), "agg", 3, gosignal.Event{Type: "redacted", Version: 3, AggregateType: "order"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "UPDATE events SET type = $1, data = $2, version = $3, timestamp = $4, schema_version = $5 " +
+		"WHERE aggregate_id = $6 AND version = $7 AND aggregate_type = $8"
+	if got := db.LastQuery(); got != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestSQLStoreAggregateIDsOfType(t *testing.T) {
+	db := sqltest.Open(t)
+	ss := SQLStore{DB: db.DB, TableName: "events"}
+
+	if _, err := ss.AggregateIDsOfType(context.Background(), "order"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SELECT DISTINCT aggregate_id FROM events WHERE aggregate_type = $1 ORDER BY aggregate_id"
+	if got := db.LastQuery(); got != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", got, want)
+	}
+	if args := db.LastArgs(); len(args) != 1 || args[0].Value != "order" {
+		t.Fatalf("expected the aggregate type as argument, got %v", args)
+	}
+}
+
+func TestSQLPendingStoreQueries(t *testing.T) {
+	ctx := context.Background()
+	db := sqltest.Open(t)
+	db.Query = func(query string, _ []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT COUNT(*)") {
+			return []string{"count"}, [][]driver.Value{{int64(2)}}, nil
+		}
+		return nil, nil, nil
+	}
+	instr := &gosignal.MemoryInstrumentation{}
+	ps := SQLPendingStore{DB: db.DB, TableName: "pending_events", Instrumentation: instr}
+
+	if err := ps.AddPending(ctx, manyEvents(2)); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.LastQuery(); !strings.Contains(got, "INSERT INTO pending_events") {
+		t.Fatalf("unexpected add query: %s", got)
+	}
+
+	has, err := ps.HasPending(ctx, gosignal.Event{AggregateID: "agg", AggregateType: "order"})
+	if err != nil || !has {
+		t.Fatalf("expected pending events, got %v, %v", has, err)
+	}
+	if want := "SELECT COUNT(*) FROM pending_events WHERE aggregate_id = $1 AND aggregate_type = $2"; db.LastQuery() != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", db.LastQuery(), want)
+	}
+
+	if _, err := ps.LoadPending(ctx, 50); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.LastQuery(); !strings.HasSuffix(got, "FROM pending_events ORDER BY id LIMIT $1") || db.LastArgs()[0].Value != int64(50) {
+		t.Fatalf("unexpected load query: %s", got)
+	}
+
+	if err := ps.RemovePending(ctx, gosignal.Event{AggregateID: "agg", Version: 2, AggregateType: "order"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "DELETE FROM pending_events WHERE aggregate_id = $1 AND version = $2 AND aggregate_type = $3"
+	if got := db.LastQuery(); got != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", got, want)
+	}
+
+	var names []string
+	for _, span := range instr.Spans() {
+		names = append(names, span.Name)
+	}
+	if want := "[gosignal.eventstore.sql_pending.add gosignal.eventstore.sql_pending.has " +
+		"gosignal.eventstore.sql_pending.load gosignal.eventstore.sql_pending.remove]"; fmt.Sprint(names) != want {
+		t.Fatalf("unexpected spans %v", names)
+	}
+}
+
+func TestSQLStoreInstrumentation(t *testing.T) {
+	db := sqltest.Open(t)
+	instr := &gosignal.MemoryInstrumentation{}
+	ss := SQLStore{DB: db.DB, TableName: "events", Instrumentation: instr}
+
+	ctx, span := instr.StartSpan(context.Background(), "gosignal.repository.store")
+	if err := ss.Store(ctx, manyEvents(2)); err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+
+	if err := (SQLStore{DB: db.DB, Instrumentation: instr}).Delete(context.Background(), "agg", ""); err == nil {
+		t.Fatal("expected an error without a table name")
+	}
+
+	spans := instr.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %+v", spans)
+	}
+	if s := spans[1]; s.Name != "gosignal.eventstore.sql.store" || s.Parent != "gosignal.repository.store" || !s.Ended || s.Err != nil {
+		t.Fatalf("unexpected store span %+v", s)
+	}
+	if s := spans[2]; s.Name != "gosignal.eventstore.sql.delete" || s.Err != ErrTableNameNotSet {
+		t.Fatalf("unexpected delete span %+v", s)
+	}
+	if got := instr.Counter("gosignal.eventstore.sql.delete.errors"); got != 1 {
+		t.Fatalf("expected a delete error to be counted, got %d", got)
+	}
+}
+
+func TestSQLStoreLogging(t *testing.T) {
+	db := sqltest.Open(t)
+	var buf strings.Builder
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ss := SQLStore{DB: db.DB, TableName: "events", Logger: logger}
+
+	if err := ss.Store(context.Background(), manyEvents(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := (SQLStore{DB: db.DB, Logger: logger}).Delete(context.Background(), "agg", ""); err == nil {
+		t.Fatal("expected an error without a table name")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", lines)
+	}
+	for _, want := range []string{"level=DEBUG", `msg=gosignal.eventstore.sql.store`, "aggregate_id=agg", "version=1", "events=2", "table=events", "duration="} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("store record %q misses %s", lines[0], want)
+		}
+	}
+	for _, want := range []string{"level=WARN", `msg="gosignal.eventstore.sql.delete failed"`, `error="table name not set"`} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("delete record %q misses %s", lines[1], want)
+		}
+	}
+}
+
+func TestSQLStoreReplaceWithoutAggregateType(t *testing.T) {
+	db := sqltest.Open(t)
+	ss := SQLStore{DB: db.DB, TableName: "events"}
+
+	if err := ss.Replace(context.Background(), "agg", 3, gosignal.Event{Type: "redacted", Version: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "UPDATE events SET type = $1, data = $2, version = $3, timestamp = $4, schema_version = $5 " +
+		"WHERE aggregate_id = $6 AND version = $7"
+	if got := db.LastQuery(); got != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestSQLStoreTimestampRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	ts := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("CET", 3600))
+
+	for _, encoding := range []sqltimestamp.Encoding{sqltimestamp.UnixSeconds, sqltimestamp.UnixNano, sqltimestamp.Native, sqltimestamp.RFC3339} {
+		t.Run(encoding.String(), func(t *testing.T) {
+			db := sqltest.Open(t)
+			(&sqltest.Table{}).Attach(db)
+			ss := SQLStore{DB: db.DB, TableName: "events", TimestampEncoding: encoding}
+
+			stored := gosignal.Event{Type: "created", Data: []byte("{}"), Version: 0, Timestamp: ts, AggregateID: "agg", AggregateType: "order", SchemaVersion: 2}
+			if err := ss.Store(ctx, []gosignal.Event{stored}); err != nil {
+				t.Fatal(err)
+			}
+
+			events, err := ss.Load(ctx, "agg", sourcing.LoadEventsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("expected one event, got %d", len(events))
+			}
+
+			want := ts
+			if encoding == sqltimestamp.UnixSeconds {
+				want = ts.Truncate(time.Second)
+			}
+			if got := events[0].Timestamp; !got.Equal(want) {
+				t.Fatalf("timestamp: got %v, want %v", got, want)
+			}
+
+			events[0].Timestamp = stored.Timestamp
+			if fmt.Sprint(events[0]) != fmt.Sprint(stored) {
+				t.Fatalf("event: got %+v, want %+v", events[0], stored)
+			}
+		})
+	}
+}
+
+func TestSQLStoreAppendChecksVersions(t *testing.T) {
+	ctx := context.Background()
+	streams := []sourcing.StreamAppend{
+		{AggregateID: "a", AggregateType: "order", ExpectedVersion: 2, Events: manyEvents(1)},
+		{AggregateID: "b", ExpectedVersion: 0, Events: manyEvents(1)},
+	}
+
+	// a is at version 2, b has no events
+	last := map[string]driver.Value{"a": int64(1), "b": nil}
+
+	versions := func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT aggregate_id") {
+			return []string{"aggregate_id", "aggregate_type"}, nil, nil
+		}
+		return []string{"max"}, [][]driver.Value{{last[args[0].Value.(string)]}}, nil
+	}
+
+	db := sqltest.Open(t)
+	db.Query = versions
+	ss := SQLStore{DB: db.DB, TableName: "events"}
+
+	if err := ss.Append(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := db.Queries()
+	want := []string{
+		"SELECT MAX(version) FROM events WHERE aggregate_id = $1 AND aggregate_type = $2",
+		"SELECT MAX(version) FROM events WHERE aggregate_id = $1",
+	}
+	if len(queries) != 4 || queries[0] != want[0] || queries[1] != want[1] {
+		t.Fatalf("expected two version checks, a tombstone check and an insert, got %q", queries)
+	}
+	if !strings.Contains(queries[3], "INSERT INTO events") {
+		t.Fatalf("expected an insert, got %q", queries[3])
+	}
+
+	last["b"] = int64(0)
+	db = sqltest.Open(t)
+	db.Query = versions
+	ss.DB = db.DB
+
+	if err := ss.Append(ctx, streams); !errors.Is(err, sourcing.ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+	for _, query := range db.Queries() {
+		if strings.Contains(query, "INSERT") {
+			t.Fatal("expected nothing inserted on conflict")
+		}
+	}
+}
+
+func TestSQLStoreRejectsDeleted(t *testing.T) {
+	ctx := context.Background()
+	db := sqltest.Open(t)
+	db.Query = func(query string, _ []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT MAX(version)") {
+			return []string{"max"}, [][]driver.Value{{int64(2)}}, nil
+		}
+		return []string{"aggregate_id", "aggregate_type"}, [][]driver.Value{{"agg", ""}}, nil
+	}
+	ss := SQLStore{DB: db.DB, TableName: "events"}
+
+	streams := []sourcing.StreamAppend{{AggregateID: "agg", ExpectedVersion: 3, Events: manyEvents(1)}}
+	if err := ss.Append(ctx, streams); !errors.Is(err, sourcing.ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted, got %v", err)
+	}
+
+	want := "SELECT aggregate_id, aggregate_type FROM events WHERE type = $1 AND aggregate_id IN ($2)"
+	if got := db.LastQuery(); got != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", got, want)
+	}
+	if args := db.LastArgs(); args[0].Value != sourcing.TombstoneEventType || args[1].Value != "agg" {
+		t.Fatalf("unexpected arguments %v", args)
+	}
+
+	if err := ss.Store(ctx, manyEvents(1)); !errors.Is(err, sourcing.ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted on store, got %v", err)
+	}
+	for _, query := range db.Queries() {
+		if strings.Contains(query, "INSERT") {
+			t.Fatal("expected nothing inserted for a deleted aggregate")
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package sqltest provides a scriptable database/sql driver for testing the SQL drivers without a
+// database server.
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// DB is a database recording every statement. Statements are answered by Exec and Query, which
+// default to affecting one row and returning no rows.
+type DB struct {
+	*sql.DB
+
+	// Exec runs a statement and returns the number of affected rows
+	Exec func(query string, args []driver.NamedValue) (int64, error)
+	// Query runs a query and returns the column names and rows
+	Query func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error)
+
+	mu      sync.Mutex
+	queries []string
+	args    [][]driver.NamedValue
+}
+
+var driverSeq atomic.Int64
+
+// Open registers a driver for a new DB, which is closed when the test ends
+func Open(t testing.TB) *DB {
+	t.Helper()
+
+	db := &DB{}
+	name := fmt.Sprintf("sqltest-%d", driverSeq.Add(1))
+	sql.Register(name, sqlDriver{db})
+
+	sqlDB, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	db.DB = sqlDB
+
+	return db
+}
+
+// Queries returns the statements run so far
+func (db *DB) Queries() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string{}, db.queries...)
+}
+
+// LastQuery returns the last statement run
+func (db *DB) LastQuery() string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.queries[len(db.queries)-1]
+}
+
+// LastArgs returns the arguments of the last statement run
+func (db *DB) LastArgs() []driver.NamedValue {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.args[len(db.args)-1]
+}
+
+// Arg returns the value of the named argument, or nil
+func Arg(args []driver.NamedValue, name string) driver.Value {
+	for _, arg := range args {
+		if arg.Name == name {
+			return arg.Value
+		}
+	}
+	return nil
+}
+
+func (db *DB) record(query string, args []driver.NamedValue) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.queries = append(db.queries, query)
+	db.args = append(db.args, args)
+}
+
+type sqlDriver struct{ db *DB }
+
+func (d sqlDriver) Open(string) (driver.Conn, error) { return conn(d), nil }
+
+type conn struct{ db *DB }
+
+func (c conn) Prepare(string) (driver.Stmt, error) { return nil, fmt.Errorf("prepare not supported") }
+func (c conn) Close() error                        { return nil }
+func (c conn) Begin() (driver.Tx, error)           { return c, nil }
+func (c conn) Commit() error                       { return nil }
+func (c conn) Rollback() error                     { return nil }
+
+func (c conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query, args)
+	if c.db.Exec == nil {
+		return driver.RowsAffected(1), nil
+	}
+
+	n, err := c.db.Exec(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (c conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.record(query, args)
+	if c.db.Query == nil {
+		return &rows{}, nil
+	}
+
+	columns, values, err := c.db.Query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: columns, values: values}, nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// Table keeps the rows inserted through a DB in memory, so values written by a store can be read
+// back by it. It only understands INSERT statements, whose rows are appended, and the column list
+// of SELECT statements, which return every row regardless of conditions.
+type Table struct {
+	mu   sync.Mutex
+	rows []map[string]driver.Value
+}
+
+var (
+	insertPattern = regexp.MustCompile(`(?s)INSERT INTO \S+ \(([^)]*)\)\s*VALUES\s*(\(.*?\)(?:\s*,\s*\(.*?\))*)`)
+	rowPattern    = regexp.MustCompile(`\(([^)]*)\)`)
+	selectPattern = regexp.MustCompile(`(?s)SELECT (.*?) FROM`)
+)
+
+// Attach makes the table answer the DB's statements
+func (t *Table) Attach(db *DB) {
+	db.Exec = t.exec
+	db.Query = t.query
+}
+
+// Len returns the number of rows
+func (t *Table) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.rows)
+}
+
+func (t *Table) exec(query string, args []driver.NamedValue) (int64, error) {
+	m := insertPattern.FindStringSubmatch(query)
+	if m == nil {
+		return 0, fmt.Errorf("sqltest: unsupported statement %q", query)
+	}
+	columns := splitList(m[1])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var n int64
+	for _, values := range rowPattern.FindAllStringSubmatch(m[2], -1) {
+		placeholders := splitList(values[1])
+		if len(placeholders) != len(columns) {
+			return n, fmt.Errorf("sqltest: %d values for %d columns", len(placeholders), len(columns))
+		}
+
+		row := make(map[string]driver.Value, len(columns))
+		for i, placeholder := range placeholders {
+			value, err := bind(placeholder, args)
+			if err != nil {
+				return n, err
+			}
+			row[columns[i]] = value
+		}
+		t.rows = append(t.rows, row)
+		n++
+	}
+
+	return n, nil
+}
+
+func (t *Table) query(query string, _ []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	m := selectPattern.FindStringSubmatch(query)
+	if m == nil {
+		return nil, nil, fmt.Errorf("sqltest: unsupported query %q", query)
+	}
+	columns := splitList(m[1])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	values := make([][]driver.Value, len(t.rows))
+	for i, row := range t.rows {
+		values[i] = make([]driver.Value, len(columns))
+		for j, column := range columns {
+			values[i][j] = row[column]
+		}
+	}
+
+	return columns, values, nil
+}
+
+// bind resolves a $n or :name placeholder
+func bind(placeholder string, args []driver.NamedValue) (driver.Value, error) {
+	switch {
+	case strings.HasPrefix(placeholder, "$"):
+		i, err := strconv.Atoi(placeholder[1:])
+		if err != nil || i < 1 || i > len(args) {
+			return nil, fmt.Errorf("sqltest: bad placeholder %s", placeholder)
+		}
+		return args[i-1].Value, nil
+	case strings.HasPrefix(placeholder, ":"):
+		for _, arg := range args {
+			if arg.Name == placeholder[1:] {
+				return arg.Value, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("sqltest: unbound placeholder %s", placeholder)
+}
+
+func splitList(s string) []string {
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
@@ -1,22 +1,43 @@
 package queue
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"strconv"
 	"time"
 
 	"github.com/Howard3/gosignal"
 )
 
+// MemoryQueue delivers messages to in-process subscribers.
+// Instrumentation, when set, receives a "gosignal.queue.memory.send" span and duration for every
+// send and counts the messages handed to subscribers in "gosignal.queue.memory.delivered", both
+// carrying the message type. Logger, when set, logs every send at debug level.
 type MemoryQueue struct {
-	Queue map[string]map[uint]chan gosignal.QueueMessage
+	Queue           map[string]map[uint]chan gosignal.QueueMessage
+	Instrumentation gosignal.Instrumentation
+	Logger          *slog.Logger
 }
 
 func (mq *MemoryQueue) Send(messageType string, message []byte) error {
+	attr := gosignal.Attr{Key: "message_type", Value: messageType}
+	start := time.Now()
+	ctx, done := gosignal.StartOperation(context.Background(), mq.Instrumentation, "gosignal.queue.memory.send", attr)
+	defer func() {
+		done(nil)
+		gosignal.LogOperation(ctx, mq.Logger, "memory queue send", start, nil,
+			slog.String(gosignal.LogKeyEventType, messageType), slog.Int("subscribers", len(mq.Queue[messageType])))
+	}()
+
 	if _, ok := mq.Queue[messageType]; !ok {
 		return nil // no subscribers, nothing to do.
 	}
 
+	if mq.Instrumentation != nil {
+		mq.Instrumentation.Count("gosignal.queue.memory.delivered", int64(len(mq.Queue[messageType])), attr)
+	}
+
 	for _, ch := range mq.Queue[messageType] {
 		// TODO: make non-blocking on multiple.
 		ch <- &MemoryQueueMessage{
@@ -28,6 +49,13 @@ func (mq *MemoryQueue) Send(messageType string, message []byte) error {
 	return nil
 }
 
+// Ready always succeeds, the memory queue can't be disconnected
+func (mq *MemoryQueue) Ready() error {
+	return nil
+}
+
+var _ gosignal.ReadinessChecker = (*MemoryQueue)(nil)
+
 func (mq *MemoryQueue) Subscribe(messageType string) (string, chan gosignal.QueueMessage, error) {
 	if mq.Queue == nil {
 		mq.Queue = make(map[string]map[uint]chan gosignal.QueueMessage, 0)
@@ -77,11 +105,15 @@ func (MemoryQueueMessage) Attempts() int {
 func (mqm MemoryQueueMessage) Message() []byte {
 	return mqm.message
 }
+
+// Ack is a no-op, the memory queue delivers each message once
 func (MemoryQueueMessage) Ack() error {
-	panic("not implemented") // TODO: Implement
+	return nil
 }
+
+// Nack is a no-op, the memory queue delivers each message once
 func (MemoryQueueMessage) Nack() error {
-	panic("not implemented") // TODO: Implement
+	return nil
 }
 func (MemoryQueueMessage) Retry(gosignal.RetryParams) error {
 	panic("not implemented") // TODO: Implement
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// MemoryStore is a schedule store that keeps messages in memory, intended for tests and
+// prototyping. The zero value is ready to use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages map[string]*memoryMessage
+}
+
+type memoryMessage struct {
+	msg          gosignal.ScheduledMessage
+	claimedUntil time.Time
+}
+
+// Schedule stores a message for later delivery, a message with the same id is replaced
+func (ms *MemoryStore) Schedule(ctx context.Context, msg gosignal.ScheduledMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.messages == nil {
+		ms.messages = make(map[string]*memoryMessage)
+	}
+	ms.messages[msg.ID] = &memoryMessage{msg: msg}
+
+	return nil
+}
+
+// Claim returns up to limit due messages that aren't claimed, ordered by due time
+func (ms *MemoryStore) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]gosignal.ScheduledMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var due []*memoryMessage
+	for _, m := range ms.messages {
+		if !m.msg.DueAt.After(now) && !m.claimedUntil.After(now) {
+			due = append(due, m)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].msg.DueAt.Equal(due[j].msg.DueAt) {
+			return due[i].msg.ID < due[j].msg.ID
+		}
+		return due[i].msg.DueAt.Before(due[j].msg.DueAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]gosignal.ScheduledMessage, len(due))
+	for i, m := range due {
+		m.claimedUntil = now.Add(lease)
+		claimed[i] = m.msg
+	}
+
+	return claimed, nil
+}
+
+// Remove deletes a message
+func (ms *MemoryStore) Remove(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.messages, id)
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+func TestMemoryStoreClaim(t *testing.T) {
+	ctx := context.Background()
+	store := &MemoryStore{}
+	base := time.Unix(1_700_000_000, 0)
+
+	for i, id := range []string{"c", "a", "b"} {
+		msg := gosignal.ScheduledMessage{ID: id, Type: id, DueAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := store.Schedule(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed, err := store.Claim(ctx, base.Add(time.Minute), 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 || claimed[0].ID != "c" || claimed[1].ID != "a" {
+		t.Fatalf("expected the due messages by due time, got %+v", claimed)
+	}
+
+	if claimed, _ := store.Claim(ctx, base.Add(90*time.Second), 10, time.Minute); len(claimed) != 0 {
+		t.Fatalf("expected claimed messages to be hidden during the lease, got %+v", claimed)
+	}
+	if err := store.Remove(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, _ = store.Claim(ctx, base.Add(3*time.Minute), 1, time.Minute)
+	if len(claimed) != 1 || claimed[0].ID != "a" {
+		t.Fatalf("expected the expired lease to be claimable again within the limit, got %+v", claimed)
+	}
+}
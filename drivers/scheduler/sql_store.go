@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/drivers/sqltimestamp"
+)
+
+// ErrTableNameNotSet is returned when the table name is not set
+var ErrTableNameNotSet = errors.New("table name not set")
+
+// SQLStore is a schedule store that uses a SQL database as its backend.
+// it should use a schema that matches the following:
+// ```sql
+//
+//	CREATE TABLE scheduled_messages (
+//		id VARCHAR(64) PRIMARY KEY,
+//		type VARCHAR(255) NOT NULL,
+//		message BYTEA NOT NULL,
+//		due_at INT NOT NULL,
+//		claimed_until INT
+//	);
+//	CREATE INDEX scheduled_messages_due_at ON scheduled_messages (due_at);
+//
+// ```
+//
+// The due_at and claimed_until column types must match TimestampEncoding, see sqltimestamp.Encoding.
+// With the default sqltimestamp.UnixSeconds messages are due with a precision of one second.
+//
+// Claims select due messages and then take each one with a conditional UPDATE in one transaction,
+// so concurrent dispatchers never claim the same message, even without row locking.
+//
+// Instrumentation and Logger, when set, report every operation of the "scheduler.sql" store, see
+// gosignal.StartStoreOperation.
+type SQLStore struct {
+	DB                   *sql.DB
+	TableName            string
+	NamedParamsTemplater func(string) string
+	TimestampEncoding    sqltimestamp.Encoding
+	Instrumentation      gosignal.Instrumentation
+	Logger               *slog.Logger
+}
+
+// pph returns a named parameter placeholder for the given name
+func (ss SQLStore) pph(name string) string {
+	if ss.NamedParamsTemplater == nil {
+		return fmt.Sprintf(":%s", name)
+	}
+	return ss.NamedParamsTemplater(name)
+}
+
+// startOperation reports an operation on the table to the instrumentation and the logger
+func (ss SQLStore) startOperation(ctx context.Context, operation string) (context.Context, func(error)) {
+	return gosignal.StartStoreOperation(ctx, ss.Instrumentation, ss.Logger, "scheduler.sql", operation, ss.TableName)
+}
+
+// Schedule stores a message for later delivery
+func (ss SQLStore) Schedule(ctx context.Context, msg gosignal.ScheduledMessage) (err error) {
+	ctx, done := ss.startOperation(ctx, "schedule")
+	defer func() { done(err) }()
+
+	if ss.TableName == "" {
+		return ErrTableNameNotSet
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, type, message, due_at) VALUES (%s, %s, %s, %s)",
+		ss.TableName, ss.pph("id"), ss.pph("type"), ss.pph("message"), ss.pph("due_at"))
+
+	_, err = ss.DB.ExecContext(ctx, query,
+		sql.Named("id", msg.ID),
+		sql.Named("type", msg.Type),
+		sql.Named("message", msg.Message),
+		sql.Named("due_at", ss.TimestampEncoding.Encode(msg.DueAt)),
+	)
+	return err
+}
+
+// Claim returns up to limit due messages that aren't claimed, ordered by due time
+func (ss SQLStore) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) (claimed []gosignal.ScheduledMessage, err error) {
+	ctx, done := ss.startOperation(ctx, "claim")
+	defer func() { done(err) }()
+
+	if ss.TableName == "" {
+		return nil, ErrTableNameNotSet
+	}
+
+	tx, err := ss.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, tx.Rollback())
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	available := fmt.Sprintf("(claimed_until IS NULL OR claimed_until <= %s)", ss.pph("now"))
+	encodedNow := ss.TimestampEncoding.Encode(now)
+
+	query := fmt.Sprintf("SELECT id, type, message, due_at FROM %s WHERE due_at <= %s AND %s ORDER BY due_at LIMIT %s",
+		ss.TableName, ss.pph("now"), available, ss.pph("limit"))
+
+	due, err := ss.queryMessages(ctx, tx, query, sql.Named("now", encodedNow), sql.Named("limit", limit))
+	if err != nil {
+		return nil, err
+	}
+
+	update := fmt.Sprintf("UPDATE %s SET claimed_until = %s WHERE id = %s AND %s",
+		ss.TableName, ss.pph("claimed_until"), ss.pph("id"), available)
+
+	for _, msg := range due {
+		res, err := tx.ExecContext(ctx, update,
+			sql.Named("claimed_until", ss.TimestampEncoding.Encode(now.Add(lease))),
+			sql.Named("id", msg.ID),
+			sql.Named("now", encodedNow),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// another dispatcher claimed it between the select and the update
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			claimed = append(claimed, msg)
+		}
+	}
+
+	return claimed, nil
+}
+
+func (ss SQLStore) queryMessages(ctx context.Context, tx *sql.Tx, query string, args ...any) (messages []gosignal.ScheduledMessage, err error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, rows.Close(), rows.Err())
+	}()
+
+	for rows.Next() {
+		var msg gosignal.ScheduledMessage
+		var dueAt interface{}
+		if err := rows.Scan(&msg.ID, &msg.Type, &msg.Message, &dueAt); err != nil {
+			return nil, err
+		}
+		if msg.DueAt, err = ss.TimestampEncoding.Decode(dueAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+// Remove deletes a message
+func (ss SQLStore) Remove(ctx context.Context, id string) (err error) {
+	ctx, done := ss.startOperation(ctx, "remove")
+	defer func() { done(err) }()
+
+	if ss.TableName == "" {
+		return ErrTableNameNotSet
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = %s", ss.TableName, ss.pph("id"))
+	_, err = ss.DB.ExecContext(ctx, query, sql.Named("id", id))
+	return err
+}
@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/drivers/internal/sqltest"
+)
+
+func TestSQLStoreSchedule(t *testing.T) {
+	db := sqltest.Open(t)
+	ss := SQLStore{DB: db.DB, TableName: "scheduled_messages"}
+	due := time.Unix(1_700_000_060, 0)
+
+	msg := gosignal.ScheduledMessage{ID: "a", Type: "reminder", Message: []byte("hi"), DueAt: due}
+	if err := ss.Schedule(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "INSERT INTO scheduled_messages (id, type, message, due_at) VALUES (:id, :type, :message, :due_at)"
+	if got := db.LastQuery(); got != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", got, want)
+	}
+	if v := sqltest.Arg(db.LastArgs(), "due_at"); v != due.Unix() {
+		t.Fatalf("expected the encoded due time, got %v", v)
+	}
+}
+
+func TestSQLStoreClaim(t *testing.T) {
+	db := sqltest.Open(t)
+	db.Query = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"id", "type", "message", "due_at"}, [][]driver.Value{
+			{"a", "reminder", []byte("1"), int64(1_700_000_000)},
+			{"b", "reminder", []byte("2"), int64(1_700_000_030)},
+		}, nil
+	}
+	// b is claimed by another dispatcher between the select and the update
+	db.Exec = func(_ string, args []driver.NamedValue) (int64, error) {
+		if sqltest.Arg(args, "id") == "b" {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	ss := SQLStore{DB: db.DB, TableName: "scheduled_messages"}
+	now := time.Unix(1_700_000_060, 0)
+
+	claimed, err := ss.Claim(context.Background(), now, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != "a" || !claimed[0].DueAt.Equal(time.Unix(1_700_000_000, 0)) {
+		t.Fatalf("expected only a to be claimed, got %+v", claimed)
+	}
+
+	queries := db.Queries()
+	want := "SELECT id, type, message, due_at FROM scheduled_messages WHERE due_at <= :now " +
+		"AND (claimed_until IS NULL OR claimed_until <= :now) ORDER BY due_at LIMIT :limit"
+	if queries[0] != want {
+		t.Fatalf("unexpected query:\n got: %s\nwant: %s", queries[0], want)
+	}
+	want = "UPDATE scheduled_messages SET claimed_until = :claimed_until WHERE id = :id " +
+		"AND (claimed_until IS NULL OR claimed_until <= :now)"
+	if len(queries) != 3 || queries[1] != want {
+		t.Fatalf("expected a conditional update per due message, got %v", queries)
+	}
+	if v := sqltest.Arg(db.LastArgs(), "claimed_until"); v != now.Add(time.Minute).Unix() {
+		t.Fatalf("expected the lease to end a minute from now, got %v", v)
+	}
+}
+
+func TestSQLStoreClaimRollsBackOnError(t *testing.T) {
+	db := sqltest.Open(t)
+	db.Query = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"id", "type", "message", "due_at"}, [][]driver.Value{{"a", "reminder", []byte("1"), int64(1)}}, nil
+	}
+	unavailable := errors.New("unavailable")
+	db.Exec = func(string, []driver.NamedValue) (int64, error) { return 0, unavailable }
+	ss := SQLStore{DB: db.DB, TableName: "scheduled_messages"}
+
+	if claimed, err := ss.Claim(context.Background(), time.Unix(60, 0), 10, time.Minute); !errors.Is(err, unavailable) || claimed != nil {
+		t.Fatalf("expected the update error and no claims, got %+v, %v", claimed, err)
+	}
+}
+
+func TestSQLStoreRemove(t *testing.T) {
+	db := sqltest.Open(t)
+	ss := SQLStore{DB: db.DB, TableName: "scheduled_messages"}
+
+	if err := ss.Remove(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.LastQuery(); got != "DELETE FROM scheduled_messages WHERE id = :id" || sqltest.Arg(db.LastArgs(), "id") != "a" {
+		t.Fatalf("unexpected query %s with %v", got, db.LastArgs())
+	}
+
+	if err := (SQLStore{DB: db.DB}).Remove(context.Background(), "a"); !errors.Is(err, ErrTableNameNotSet) {
+		t.Fatalf("expected ErrTableNameNotSet, got %v", err)
+	}
+	if n := len(db.Queries()); n != 1 {
+		t.Fatalf("expected no statement without a table name, got %v", db.Queries())
+	}
+}
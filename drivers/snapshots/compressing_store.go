@@ -0,0 +1,240 @@
+package snapshots
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+// ErrSnapshotCorrupt is the error reported when a snapshot fails to decode or its checksum doesn't
+// match its data
+var ErrSnapshotCorrupt = errors.New("snapshot corrupt")
+
+// envelopeMagic prefixes snapshot data written by CompressingStore
+const envelopeMagic = "GSS\x01"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Codec compresses and decompresses snapshot data
+type Codec interface {
+	// Name identifies the codec in stored snapshots, it must be stable and at most 255 bytes
+	Name() string
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// GzipCodec compresses snapshots with gzip
+type GzipCodec struct {
+	Level int // compression level, zero uses gzip.DefaultCompression
+}
+
+func (GzipCodec) Name() string {
+	return "gzip"
+}
+
+func (c GzipCodec) Encode(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (GzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// CompressingStore is a SnapshotStore decorator that compresses snapshot data with Codec, GzipCodec
+// by default, and stores a CRC-32C checksum of the uncompressed data alongside it. A snapshot that
+// fails to decompress or verify is treated as missing, so the repository replays the aggregate from
+// its events instead of importing garbage, and is reported to OnCorrupt.
+//
+// Stored data is laid out as the magic bytes "GSS\x01", one byte holding the codec name length, the
+// codec name, the big-endian uint32 checksum and the compressed data. Snapshots written under another
+// codec are decoded as long as that codec is listed in Codecs.
+type CompressingStore struct {
+	Backend   sourcing.SnapshotStore
+	Codec     Codec
+	Codecs    []Codec                    // additional codecs accepted when loading
+	AcceptRaw bool                       // accept snapshots written without the decorator
+	OnCorrupt func(id string, err error) // called when a snapshot is discarded
+}
+
+// Load loads and verifies the latest snapshot
+func (cs CompressingStore) Load(ctx context.Context, id string) (*sourcing.Snapshot, error) {
+	ss, err := cs.Backend.Load(ctx, id)
+	if err != nil || ss == nil {
+		return ss, err
+	}
+
+	return cs.unwrap(ss), nil
+}
+
+// LoadAtOrBefore loads and verifies the most recent snapshot at or below the version. If the
+// underlying store doesn't keep history, the latest snapshot is returned when it qualifies.
+func (cs CompressingStore) LoadAtOrBefore(ctx context.Context, id string, version uint64) (*sourcing.Snapshot, error) {
+	var ss *sourcing.Snapshot
+	var err error
+
+	if history, ok := cs.Backend.(sourcing.SnapshotHistoryStore); ok {
+		ss, err = history.LoadAtOrBefore(ctx, id, version)
+	} else if ss, err = cs.Backend.Load(ctx, id); ss != nil && ss.Version > version {
+		ss = nil
+	}
+	if err != nil || ss == nil {
+		return nil, err
+	}
+
+	return cs.unwrap(ss), nil
+}
+
+// LoadTakenAtOrBefore loads and verifies the most recent snapshot taken at or before the instant. If
+// the underlying store can't search by time, the latest snapshot is returned when it qualifies.
+func (cs CompressingStore) LoadTakenAtOrBefore(ctx context.Context, id string, t time.Time) (*sourcing.Snapshot, error) {
+	var ss *sourcing.Snapshot
+	var err error
+
+	if timed, ok := cs.Backend.(sourcing.SnapshotAsOfStore); ok {
+		ss, err = timed.LoadTakenAtOrBefore(ctx, id, t)
+	} else if ss, err = cs.Backend.Load(ctx, id); ss != nil && ss.Timestamp.After(t) {
+		ss = nil
+	}
+	if err != nil || ss == nil {
+		return nil, err
+	}
+
+	return cs.unwrap(ss), nil
+}
+
+// Store compresses the snapshot data and stores it with its checksum
+func (cs CompressingStore) Store(ctx context.Context, aggregateID string, snapshot sourcing.Snapshot) error {
+	codec := cs.writeCodec()
+	name := codec.Name()
+	if len(name) == 0 || len(name) > 255 {
+		return fmt.Errorf("invalid codec name %q", name)
+	}
+
+	compressed, err := codec.Encode(snapshot.Data)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, 0, len(envelopeMagic)+1+len(name)+4+len(compressed))
+	data = append(data, envelopeMagic...)
+	data = append(data, byte(len(name)))
+	data = append(data, name...)
+	data = binary.BigEndian.AppendUint32(data, crc32.Checksum(snapshot.Data, castagnoli))
+	data = append(data, compressed...)
+
+	snapshot.Data = data
+
+	return cs.Backend.Store(ctx, aggregateID, snapshot)
+}
+
+// Delete deletes the snapshots of the aggregate
+func (cs CompressingStore) Delete(ctx context.Context, aggregateID string) error {
+	return cs.Backend.Delete(ctx, aggregateID)
+}
+
+// Prune prunes snapshot history if the underlying store keeps any
+func (cs CompressingStore) Prune(ctx context.Context, id string, keep int) error {
+	if history, ok := cs.Backend.(sourcing.SnapshotHistoryStore); ok {
+		return history.Prune(ctx, id, keep)
+	}
+	return nil
+}
+
+// unwrap decodes the snapshot data in place, returning nil if it can't be trusted
+func (cs CompressingStore) unwrap(ss *sourcing.Snapshot) *sourcing.Snapshot {
+	data, err := cs.decode(ss.Data)
+	if err != nil {
+		if cs.OnCorrupt != nil {
+			cs.OnCorrupt(ss.ID, errors.Join(ErrSnapshotCorrupt, err))
+		}
+		return nil
+	}
+
+	ss.Data = data
+	return ss
+}
+
+func (cs CompressingStore) decode(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(envelopeMagic)) {
+		if cs.AcceptRaw {
+			return data, nil
+		}
+		return nil, fmt.Errorf("missing envelope")
+	}
+
+	rest := data[len(envelopeMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0])+4 {
+		return nil, fmt.Errorf("truncated envelope")
+	}
+
+	name := string(rest[1 : 1+rest[0]])
+	rest = rest[1+len(name):]
+	checksum := binary.BigEndian.Uint32(rest[:4])
+
+	codec := cs.codec(name)
+	if codec == nil {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+
+	decoded, err := codec.Decode(rest[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(decoded, castagnoli) != checksum {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+
+	return decoded, nil
+}
+
+// writeCodec returns the codec used to store snapshots, GzipCodec unless Codec is set
+func (cs CompressingStore) writeCodec() Codec {
+	if cs.Codec != nil {
+		return cs.Codec
+	}
+	return GzipCodec{}
+}
+
+func (cs CompressingStore) codec(name string) Codec {
+	if codec := cs.writeCodec(); codec.Name() == name {
+		return codec
+	}
+	for _, codec := range cs.Codecs {
+		if codec.Name() == name {
+			return codec
+		}
+	}
+	return nil
+}
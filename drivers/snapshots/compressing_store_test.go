@@ -0,0 +1,147 @@
+package snapshots
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+// memoryStore is a single snapshot per aggregate store used to back the decorator in tests
+type memoryStore map[string]sourcing.Snapshot
+
+func (m memoryStore) Load(_ context.Context, id string) (*sourcing.Snapshot, error) {
+	ss, ok := m[id]
+	if !ok {
+		return nil, nil
+	}
+	ss.Data = append([]byte(nil), ss.Data...)
+	return &ss, nil
+}
+
+func (m memoryStore) Store(_ context.Context, id string, ss sourcing.Snapshot) error {
+	m[id] = ss
+	return nil
+}
+
+func (m memoryStore) Delete(_ context.Context, id string) error {
+	delete(m, id)
+	return nil
+}
+
+// reverseCodec is a trivial codec to exercise codec selection
+type reverseCodec struct{}
+
+func (reverseCodec) Name() string { return "reverse" }
+func (reverseCodec) Encode(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out, nil
+}
+func (c reverseCodec) Decode(data []byte) ([]byte, error) { return c.Encode(data) }
+
+func TestCompressingStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend := memoryStore{}
+	cs := CompressingStore{Backend: backend}
+
+	state := []byte(strings.Repeat(`{"line":"item"},`, 1000))
+	if err := cs.Store(ctx, "a", sourcing.Snapshot{ID: "a", Version: 3, Data: state}); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored := backend["a"].Data; len(stored) >= len(state) || !bytes.HasPrefix(stored, []byte(envelopeMagic)) {
+		t.Fatalf("expected compressed envelope, got %d bytes", len(stored))
+	}
+
+	ss, err := cs.Load(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss == nil || !bytes.Equal(ss.Data, state) || ss.Version != 3 {
+		t.Fatalf("unexpected snapshot %+v", ss)
+	}
+}
+
+func TestCompressingStoreTreatsCorruptionAsMiss(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		corrupt func([]byte) []byte
+	}{
+		{"flipped checksum", func(b []byte) []byte { b[len(envelopeMagic)+5] ^= 0xff; return b }},
+		{"truncated payload", func(b []byte) []byte { return b[:len(b)-4] }},
+		{"truncated envelope", func(b []byte) []byte { return b[:len(envelopeMagic)+2] }},
+		{"raw data", func([]byte) []byte { return []byte("not an envelope") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := memoryStore{}
+			var reported error
+			cs := CompressingStore{Backend: backend, OnCorrupt: func(_ string, err error) { reported = err }}
+
+			if err := cs.Store(ctx, "a", sourcing.Snapshot{ID: "a", Data: []byte("state")}); err != nil {
+				t.Fatal(err)
+			}
+			ss := backend["a"]
+			ss.Data = tt.corrupt(ss.Data)
+			backend["a"] = ss
+
+			got, err := cs.Load(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != nil {
+				t.Fatalf("expected corrupt snapshot to be a miss, got %+v", got)
+			}
+			if !errors.Is(reported, ErrSnapshotCorrupt) {
+				t.Fatalf("expected ErrSnapshotCorrupt to be reported, got %v", reported)
+			}
+		})
+	}
+}
+
+func TestCompressingStoreCodecMigration(t *testing.T) {
+	ctx := context.Background()
+	backend := memoryStore{}
+
+	old := CompressingStore{Backend: backend, Codec: reverseCodec{}}
+	if err := old.Store(ctx, "a", sourcing.Snapshot{ID: "a", Data: []byte("state")}); err != nil {
+		t.Fatal(err)
+	}
+	backend["raw"] = sourcing.Snapshot{ID: "raw", Data: []byte("plain")}
+
+	cs := CompressingStore{Backend: backend, Codecs: []Codec{reverseCodec{}}, AcceptRaw: true}
+
+	ss, err := cs.Load(ctx, "a")
+	if err != nil || ss == nil || string(ss.Data) != "state" {
+		t.Fatalf("expected snapshot written with the old codec to load, got %+v, %v", ss, err)
+	}
+
+	ss, err = cs.Load(ctx, "raw")
+	if err != nil || ss == nil || string(ss.Data) != "plain" {
+		t.Fatalf("expected raw snapshot to load, got %+v, %v", ss, err)
+	}
+}
+
+func TestCompressingStoreLoadAtOrBeforeWithoutHistory(t *testing.T) {
+	ctx := context.Background()
+	cs := CompressingStore{Backend: memoryStore{}}
+	if err := cs.Store(ctx, "a", sourcing.Snapshot{ID: "a", Version: 5, Data: []byte("state")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ss, _ := cs.LoadAtOrBefore(ctx, "a", 4); ss != nil {
+		t.Fatalf("expected no snapshot at or before 4, got %+v", ss)
+	}
+	if ss, _ := cs.LoadAtOrBefore(ctx, "a", 5); ss == nil || string(ss.Data) != "state" {
+		t.Fatalf("expected snapshot at 5, got %+v", ss)
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/drivers/sqltimestamp"
 	"github.com/Howard3/gosignal/sourcing"
 )
 
@@ -22,10 +25,34 @@ var ErrLoadingSnapshot = fmt.Errorf("error loading snapshot")
 // that means there will be a column for the id, version, and data, and timestamp
 // Further, every aggregate must have its own table as there is no "aggregate type" column
 // it should use a schema that matches the following:
+// ```sql
+//
+//	CREATE TABLE snapshots (
+//		id VARCHAR(255) PRIMARY KEY,
+//		version INT NOT NULL,
+//		data BYTEA NOT NULL,
+//		timestamp INT NOT NULL,
+//		revision INT NOT NULL DEFAULT 0
+//	);
+//
+// ```
+//
+// The timestamp column type must match TimestampEncoding, see sqltimestamp.Encoding.
+//
+// History is the number of snapshots kept per aggregate. When zero only the latest snapshot is kept
+// by upserting on id. When above zero the primary key must be (id, version) instead, every stored
+// snapshot adds a row and older rows beyond History are pruned.
+//
+// Instrumentation and Logger, when set, report every operation of the "snapshots.sql" store, see
+// gosignal.StartStoreOperation.
 type SQLStore struct {
 	DB                   *sql.DB
 	TableName            string
 	NamedParamsTemplater func(string) string
+	TimestampEncoding    sqltimestamp.Encoding
+	History              int
+	Instrumentation      gosignal.Instrumentation
+	Logger               *slog.Logger
 }
 
 // pph returns a named parameter placeholder for the given name
@@ -36,18 +63,66 @@ func (ss SQLStore) pph(name string) string {
 	return ss.NamedParamsTemplater(name)
 }
 
-// Load loads a snapshot from the store
-func (ss SQLStore) Load(ctx context.Context, id string) (*sourcing.Snapshot, error) {
+// startOperation reports an operation on an aggregate's snapshots to the instrumentation and the
+// logger
+func (ss SQLStore) startOperation(ctx context.Context, operation, aggregateID string) (context.Context, func(error)) {
+	return gosignal.StartStoreOperation(ctx, ss.Instrumentation, ss.Logger, "snapshots.sql", operation, ss.TableName,
+		slog.String(gosignal.LogKeyAggregateID, aggregateID))
+}
+
+// Load loads the latest snapshot from the store
+func (ss SQLStore) Load(ctx context.Context, id string) (_ *sourcing.Snapshot, err error) {
+	ctx, done := ss.startOperation(ctx, "load", id)
+	defer func() { done(err) }()
+
+	if ss.TableName == "" {
+		return nil, ErrTableNameNotSet
+	}
+
+	query := fmt.Sprintf("SELECT data, version, timestamp, revision FROM %s WHERE id = %s ORDER BY version DESC LIMIT 1",
+		ss.TableName, ss.pph("id"))
+
+	return ss.loadOne(ctx, id, query, sql.Named("id", id))
+}
+
+// LoadAtOrBefore loads the most recent snapshot at or below the given version
+func (ss SQLStore) LoadAtOrBefore(ctx context.Context, id string, version uint64) (_ *sourcing.Snapshot, err error) {
+	ctx, done := ss.startOperation(ctx, "load_at_or_before", id)
+	defer func() { done(err) }()
+
 	if ss.TableName == "" {
 		return nil, ErrTableNameNotSet
 	}
 
-	query := fmt.Sprintf("SELECT data, version, timestamp FROM %s WHERE id = %s", ss.TableName, ss.pph("id"))
+	query := fmt.Sprintf(
+		"SELECT data, version, timestamp, revision FROM %s WHERE id = %s AND version <= %s ORDER BY version DESC LIMIT 1",
+		ss.TableName, ss.pph("id"), ss.pph("version"))
+
+	return ss.loadOne(ctx, id, query, sql.Named("id", id), sql.Named("version", version))
+}
+
+// LoadTakenAtOrBefore loads the most recent snapshot taken at or before the instant
+func (ss SQLStore) LoadTakenAtOrBefore(ctx context.Context, id string, t time.Time) (_ *sourcing.Snapshot, err error) {
+	ctx, done := ss.startOperation(ctx, "load_taken_at_or_before", id)
+	defer func() { done(err) }()
+
+	if ss.TableName == "" {
+		return nil, ErrTableNameNotSet
+	}
+
+	query := fmt.Sprintf(
+		"SELECT data, version, timestamp, revision FROM %s WHERE id = %s AND timestamp <= %s ORDER BY version DESC LIMIT 1",
+		ss.TableName, ss.pph("id"), ss.pph("timestamp"))
+
+	return ss.loadOne(ctx, id, query, sql.Named("id", id), sql.Named("timestamp", ss.TimestampEncoding.Encode(t)))
+}
+
+func (ss SQLStore) loadOne(ctx context.Context, id, query string, args ...any) (*sourcing.Snapshot, error) {
 	snapshot := sourcing.Snapshot{ID: id}
-	var timestamp int
+	var timestamp interface{}
 
-	row := ss.DB.QueryRowContext(ctx, query, sql.Named("id", id))
-	if err := row.Scan(&snapshot.Data, &snapshot.Version, &timestamp); err != nil {
+	row := ss.DB.QueryRowContext(ctx, query, args...)
+	if err := row.Scan(&snapshot.Data, &snapshot.Version, &timestamp, &snapshot.Revision); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -55,44 +130,97 @@ func (ss SQLStore) Load(ctx context.Context, id string) (*sourcing.Snapshot, err
 		return nil, errors.Join(ErrLoadingSnapshot, err)
 	}
 
-	snapshot.Timestamp = time.Unix(int64(timestamp), 0)
+	ts, err := ss.TimestampEncoding.Decode(timestamp)
+	if err != nil {
+		return nil, errors.Join(ErrLoadingSnapshot, err)
+	}
+	snapshot.Timestamp = ts
 
 	return &snapshot, nil
 }
 
 // Store stores a snapshot in the store
-func (ss SQLStore) Store(ctx context.Context, aggregateID string, snapshot sourcing.Snapshot) error {
+func (ss SQLStore) Store(ctx context.Context, aggregateID string, snapshot sourcing.Snapshot) (err error) {
+	ctx, done := ss.startOperation(ctx, "store", aggregateID)
+	defer func() { done(err) }()
+
 	if ss.TableName == "" {
 		return ErrTableNameNotSet
 	}
 
-	ssTimestamp := snapshot.Timestamp.Unix()
+	ssTimestamp := ss.TimestampEncoding.Encode(snapshot.Timestamp)
+
+	conflict := "id"
+	if ss.History > 0 {
+		conflict = "id, version"
+	}
 
-	query := fmt.Sprintf(`INSERT INTO %s (id, version, data, timestamp) 
+	query := fmt.Sprintf(`INSERT INTO %s (id, version, data, timestamp, revision) 
 		VALUES (%s)
-		ON CONFLICT (id) DO UPDATE SET version = %s, data = %s, timestamp = %s 
+		ON CONFLICT (%s) DO UPDATE SET version = %s, data = %s, timestamp = %s, revision = %s 
 	`, ss.TableName,
-		strings.Join([]string{ss.pph("id"), ss.pph("version"), ss.pph("data"), ss.pph("timestamp")}, ", "),
-		ss.pph("version"), ss.pph("data"), ss.pph("timestamp"),
+		strings.Join([]string{ss.pph("id"), ss.pph("version"), ss.pph("data"), ss.pph("timestamp"), ss.pph("revision")}, ", "),
+		conflict, ss.pph("version"), ss.pph("data"), ss.pph("timestamp"), ss.pph("revision"),
 	)
 
-	_, err := ss.DB.ExecContext(ctx, query,
+	_, err = ss.DB.ExecContext(ctx, query,
 		sql.Named("id", aggregateID),
 		sql.Named("version", snapshot.Version),
 		sql.Named("data", snapshot.Data),
 		sql.Named("timestamp", ssTimestamp),
+		sql.Named("revision", snapshot.Revision),
 	)
+	if err != nil || ss.History <= 0 {
+		return err
+	}
+
+	return ss.Prune(ctx, aggregateID, ss.History)
+}
 
+// Prune deletes all but the keep most recent snapshots of the aggregate. It looks up the oldest
+// version to keep and deletes the versions below it, as MySQL rejects a LIMIT subquery on the
+// table being deleted from.
+func (ss SQLStore) Prune(ctx context.Context, aggregateID string, keep int) (err error) {
+	ctx, done := ss.startOperation(ctx, "prune", aggregateID)
+	defer func() { done(err) }()
+
+	if ss.TableName == "" {
+		return ErrTableNameNotSet
+	}
+
+	if keep <= 0 {
+		query := fmt.Sprintf("DELETE FROM %s WHERE id = %s", ss.TableName, ss.pph("id"))
+		_, err = ss.DB.ExecContext(ctx, query, sql.Named("id", aggregateID))
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT version FROM %s WHERE id = %s ORDER BY version DESC LIMIT 1 OFFSET %s",
+		ss.TableName, ss.pph("id"), ss.pph("offset"))
+
+	var oldest uint64
+	err = ss.DB.QueryRowContext(ctx, query, sql.Named("id", aggregateID), sql.Named("offset", keep-1)).Scan(&oldest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf("DELETE FROM %s WHERE id = %s AND version < %s", ss.TableName, ss.pph("id"), ss.pph("version"))
+	_, err = ss.DB.ExecContext(ctx, query, sql.Named("id", aggregateID), sql.Named("version", oldest))
 	return err
 }
 
-// Delete deletes a snapshot from the store
-func (ss SQLStore) Delete(ctx context.Context, aggregateID string) error {
+// Delete deletes all snapshots of the aggregate from the store
+func (ss SQLStore) Delete(ctx context.Context, aggregateID string) (err error) {
+	ctx, done := ss.startOperation(ctx, "delete", aggregateID)
+	defer func() { done(err) }()
+
 	if ss.TableName == "" {
 		return ErrTableNameNotSet
 	}
 
 	query := fmt.Sprintf("DELETE FROM %s WHERE id = %s", ss.TableName, ss.pph("id"))
-	_, err := ss.DB.ExecContext(ctx, query, sql.Named("id", aggregateID))
+	_, err = ss.DB.ExecContext(ctx, query, sql.Named("id", aggregateID))
 	return err
 }
@@ -0,0 +1,122 @@
+package snapshots
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal/drivers/internal/sqltest"
+	"github.com/Howard3/gosignal/drivers/sqltimestamp"
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+func TestSQLStoreTimestampRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	ts := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("CET", 3600))
+
+	for _, encoding := range []sqltimestamp.Encoding{sqltimestamp.UnixSeconds, sqltimestamp.UnixNano, sqltimestamp.Native, sqltimestamp.RFC3339} {
+		t.Run(encoding.String(), func(t *testing.T) {
+			db := sqltest.Open(t)
+			(&sqltest.Table{}).Attach(db)
+			ss := SQLStore{DB: db.DB, TableName: "snapshots", TimestampEncoding: encoding}
+
+			stored := sourcing.Snapshot{ID: "agg", Version: 7, Data: []byte(`{"count":7}`), Timestamp: ts, Revision: 3}
+			if err := ss.Store(ctx, "agg", stored); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := ss.Load(ctx, "agg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded == nil {
+				t.Fatal("expected a snapshot")
+			}
+
+			want := ts
+			if encoding == sqltimestamp.UnixSeconds {
+				want = ts.Truncate(time.Second)
+			}
+			if !loaded.Timestamp.Equal(want) {
+				t.Fatalf("timestamp: got %v, want %v", loaded.Timestamp, want)
+			}
+			if loaded.ID != stored.ID || loaded.Version != stored.Version || string(loaded.Data) != string(stored.Data) || loaded.Revision != stored.Revision {
+				t.Fatalf("snapshot: got %+v, want %+v", *loaded, stored)
+			}
+		})
+	}
+}
+
+func TestSQLStoreLoadAtOrBefore(t *testing.T) {
+	ctx := context.Background()
+	db := sqltest.Open(t)
+	db.Query = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"data", "version", "timestamp", "revision"}, [][]driver.Value{{[]byte("3"), int64(3), int64(0), int64(1)}}, nil
+	}
+	ss := SQLStore{DB: db.DB, TableName: "snapshots", History: 5}
+
+	loaded, err := ss.LoadAtOrBefore(ctx, "agg", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded == nil || loaded.ID != "agg" || loaded.Version != 3 || loaded.Revision != 1 {
+		t.Fatalf("unexpected snapshot %+v", loaded)
+	}
+
+	if q := db.LastQuery(); !strings.Contains(q, "version <= :version ORDER BY version DESC LIMIT 1") {
+		t.Fatalf("unexpected query %q", q)
+	}
+	if v := sqltest.Arg(db.LastArgs(), "version"); v != int64(4) {
+		t.Fatalf("expected version 4, got %v", v)
+	}
+}
+
+func TestSQLStoreHistory(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("prunes below the oldest kept version", func(t *testing.T) {
+		db := sqltest.Open(t)
+		db.Query = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+			return []string{"version"}, [][]driver.Value{{int64(6)}}, nil
+		}
+		ss := SQLStore{DB: db.DB, TableName: "snapshots", History: 3}
+
+		if err := ss.Store(ctx, "agg", sourcing.Snapshot{ID: "agg", Version: 8, Data: []byte("8")}); err != nil {
+			t.Fatal(err)
+		}
+
+		queries := db.Queries()
+		if len(queries) != 3 {
+			t.Fatalf("expected insert, lookup and delete, got %q", queries)
+		}
+		if !strings.Contains(queries[0], "ON CONFLICT (id, version)") {
+			t.Fatalf("expected history to upsert on id and version, got %q", queries[0])
+		}
+		if !strings.Contains(queries[1], "ORDER BY version DESC LIMIT 1 OFFSET :offset") {
+			t.Fatalf("unexpected lookup %q", queries[1])
+		}
+		if strings.Contains(queries[2], "SELECT") || !strings.Contains(queries[2], "version < :version") {
+			t.Fatalf("expected a delete without subquery, got %q", queries[2])
+		}
+		if v := sqltest.Arg(db.LastArgs(), "version"); v != int64(6) {
+			t.Fatalf("expected to delete below version 6, got %v", v)
+		}
+	})
+
+	t.Run("keeps everything below the limit", func(t *testing.T) {
+		db := sqltest.Open(t)
+		ss := SQLStore{DB: db.DB, TableName: "snapshots"}
+
+		if err := ss.Prune(ctx, "agg", 3); err != nil {
+			t.Fatal(err)
+		}
+		if queries := db.Queries(); len(queries) != 1 {
+			t.Fatalf("expected only the lookup, got %q", queries)
+		}
+		if v := sqltest.Arg(db.LastArgs(), "offset"); v != int64(2) {
+			t.Fatalf("expected offset 2, got %v", v)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package snapshots
+
+import (
+	"time"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+// aggregateVersion returns the version of the aggregate after the events have been applied on top
+// of the snapshot
+func aggregateVersion(snapshot *sourcing.Snapshot, events []gosignal.Event) uint64 {
+	if len(events) > 0 {
+		return events[len(events)-1].Version + 1
+	}
+	if snapshot != nil {
+		return snapshot.Version
+	}
+	return 0
+}
+
+// EventsSinceSnapshotStrategy snapshots once the aggregate version is at least Threshold versions
+// past the loaded snapshot, or past zero when there is no snapshot. Unlike VersionIntervalStrategy
+// it is unaffected by load options that shrink the events slice.
+type EventsSinceSnapshotStrategy struct {
+	Threshold uint64
+	Store     sourcing.SnapshotStore
+}
+
+func (s *EventsSinceSnapshotStrategy) ShouldSnapshot(snapshot *sourcing.Snapshot, events []gosignal.Event) bool {
+	if len(events) == 0 {
+		return false
+	}
+
+	var base uint64
+	if snapshot != nil {
+		base = snapshot.Version
+	}
+
+	return aggregateVersion(snapshot, events)-base >= s.Threshold
+}
+
+func (s *EventsSinceSnapshotStrategy) GetStore() sourcing.SnapshotStore {
+	return s.Store
+}
+
+// AgeStrategy snapshots when the loaded snapshot is older than MaxAge and new events have been
+// applied since, or when there is no snapshot yet
+type AgeStrategy struct {
+	MaxAge time.Duration
+	Store  sourcing.SnapshotStore
+	Now    func() time.Time // defaults to time.Now
+}
+
+func (s *AgeStrategy) ShouldSnapshot(snapshot *sourcing.Snapshot, events []gosignal.Event) bool {
+	if len(events) == 0 {
+		return false
+	}
+	if snapshot == nil {
+		return true
+	}
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+
+	return now().Sub(snapshot.Timestamp) > s.MaxAge
+}
+
+func (s *AgeStrategy) GetStore() sourcing.SnapshotStore {
+	return s.Store
+}
+
+// LoadLatencyStrategy snapshots when loading the aggregate took longer than Threshold. It relies on
+// the load statistics provided by the repository, ShouldSnapshot alone always returns false.
+type LoadLatencyStrategy struct {
+	Threshold time.Duration
+	Store     sourcing.SnapshotStore
+}
+
+func (s *LoadLatencyStrategy) ShouldSnapshot(*sourcing.Snapshot, []gosignal.Event) bool {
+	return false
+}
+
+func (s *LoadLatencyStrategy) ShouldSnapshotWithStats(_ *sourcing.Snapshot, events []gosignal.Event, stats sourcing.LoadStats) bool {
+	return len(events) > 0 && stats.Duration > s.Threshold
+}
+
+func (s *LoadLatencyStrategy) GetStore() sourcing.SnapshotStore {
+	return s.Store
+}
+
+// CompositeStrategy combines several strategies, snapshotting when any (AnyOf) or all (AllOf) of
+// them agree. The stores of the combined strategies are ignored in favour of Store.
+type CompositeStrategy struct {
+	Strategies []sourcing.SnapshotStrategy
+	Store      sourcing.SnapshotStore
+	all        bool
+}
+
+// AnyOf returns a strategy that snapshots when at least one of the strategies would
+func AnyOf(store sourcing.SnapshotStore, strategies ...sourcing.SnapshotStrategy) *CompositeStrategy {
+	return &CompositeStrategy{Strategies: strategies, Store: store}
+}
+
+// AllOf returns a strategy that snapshots only when every one of the strategies would
+func AllOf(store sourcing.SnapshotStore, strategies ...sourcing.SnapshotStrategy) *CompositeStrategy {
+	return &CompositeStrategy{Strategies: strategies, Store: store, all: true}
+}
+
+func (s *CompositeStrategy) ShouldSnapshot(snapshot *sourcing.Snapshot, events []gosignal.Event) bool {
+	return s.decide(func(strategy sourcing.SnapshotStrategy) bool {
+		return strategy.ShouldSnapshot(snapshot, events)
+	})
+}
+
+func (s *CompositeStrategy) ShouldSnapshotWithStats(snapshot *sourcing.Snapshot, events []gosignal.Event, stats sourcing.LoadStats) bool {
+	return s.decide(func(strategy sourcing.SnapshotStrategy) bool {
+		if aware, ok := strategy.(sourcing.StatsAwareSnapshotStrategy); ok {
+			return aware.ShouldSnapshotWithStats(snapshot, events, stats)
+		}
+		return strategy.ShouldSnapshot(snapshot, events)
+	})
+}
+
+func (s *CompositeStrategy) decide(should func(sourcing.SnapshotStrategy) bool) bool {
+	if len(s.Strategies) == 0 {
+		return false
+	}
+
+	for _, strategy := range s.Strategies {
+		if should(strategy) != s.all {
+			return !s.all
+		}
+	}
+
+	return s.all
+}
+
+func (s *CompositeStrategy) GetStore() sourcing.SnapshotStore {
+	return s.Store
+}
@@ -0,0 +1,120 @@
+package snapshots
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+	"github.com/Howard3/gosignal/sourcing"
+)
+
+func eventsFrom(from, to uint64) []gosignal.Event {
+	var events []gosignal.Event
+	for v := from; v <= to; v++ {
+		events = append(events, gosignal.Event{Version: v})
+	}
+	return events
+}
+
+func TestEventsSinceSnapshotStrategy(t *testing.T) {
+	s := &EventsSinceSnapshotStrategy{Threshold: 5}
+
+	tests := []struct {
+		name     string
+		snapshot *sourcing.Snapshot
+		events   []gosignal.Event
+		want     bool
+	}{
+		{"no snapshot below threshold", nil, eventsFrom(0, 3), false},
+		{"no snapshot at threshold", nil, eventsFrom(0, 4), true},
+		{"snapshot below threshold", &sourcing.Snapshot{Version: 10}, eventsFrom(10, 13), false},
+		{"snapshot at threshold", &sourcing.Snapshot{Version: 10}, eventsFrom(10, 14), true},
+		{"filtered events still count versions", &sourcing.Snapshot{Version: 10}, eventsFrom(20, 20), true},
+		{"no new events", &sourcing.Snapshot{Version: 10}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.ShouldSnapshot(tt.snapshot, tt.events); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAgeStrategy(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := &AgeStrategy{MaxAge: time.Hour, Now: func() time.Time { return now }}
+
+	fresh := &sourcing.Snapshot{Timestamp: now.Add(-time.Minute)}
+	stale := &sourcing.Snapshot{Timestamp: now.Add(-2 * time.Hour)}
+
+	if s.ShouldSnapshot(fresh, eventsFrom(0, 1)) {
+		t.Fatal("fresh snapshot should not be replaced")
+	}
+	if !s.ShouldSnapshot(stale, eventsFrom(0, 1)) {
+		t.Fatal("stale snapshot should be replaced")
+	}
+	if s.ShouldSnapshot(stale, nil) {
+		t.Fatal("stale snapshot without new events should not be replaced")
+	}
+	if !s.ShouldSnapshot(nil, eventsFrom(0, 0)) {
+		t.Fatal("missing snapshot should be created")
+	}
+}
+
+func TestLoadLatencyStrategy(t *testing.T) {
+	s := &LoadLatencyStrategy{Threshold: 50 * time.Millisecond}
+
+	if s.ShouldSnapshot(nil, eventsFrom(0, 100)) {
+		t.Fatal("expected false without load statistics")
+	}
+	if s.ShouldSnapshotWithStats(nil, eventsFrom(0, 100), sourcing.LoadStats{Duration: 10 * time.Millisecond}) {
+		t.Fatal("fast load should not snapshot")
+	}
+	if !s.ShouldSnapshotWithStats(nil, eventsFrom(0, 100), sourcing.LoadStats{Duration: time.Second}) {
+		t.Fatal("slow load should snapshot")
+	}
+}
+
+type fixedStrategy bool
+
+func (f fixedStrategy) ShouldSnapshot(*sourcing.Snapshot, []gosignal.Event) bool { return bool(f) }
+func (f fixedStrategy) GetStore() sourcing.SnapshotStore                         { return nil }
+
+func TestCompositeStrategies(t *testing.T) {
+	yes, no := fixedStrategy(true), fixedStrategy(false)
+
+	tests := []struct {
+		name     string
+		strategy *CompositeStrategy
+		want     bool
+	}{
+		{"any of none", AnyOf(nil), false},
+		{"any of mixed", AnyOf(nil, no, yes), true},
+		{"any of all false", AnyOf(nil, no, no), false},
+		{"all of none", AllOf(nil), false},
+		{"all of mixed", AllOf(nil, yes, no), false},
+		{"all of all true", AllOf(nil, yes, yes), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.strategy.ShouldSnapshot(nil, nil); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompositeStrategyForwardsStats(t *testing.T) {
+	latency := &LoadLatencyStrategy{Threshold: time.Millisecond}
+	s := AllOf(nil, latency, fixedStrategy(true))
+
+	stats := sourcing.LoadStats{Duration: time.Second}
+	if !s.ShouldSnapshotWithStats(nil, eventsFrom(0, 0), stats) {
+		t.Fatal("expected stats to be forwarded to the latency strategy")
+	}
+
+	var _ sourcing.StatsAwareSnapshotStrategy = s
+}
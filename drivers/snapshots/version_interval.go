@@ -5,6 +5,8 @@ import (
 	"github.com/Howard3/gosignal/sourcing"
 )
 
+// VersionIntervalStrategy snapshots when more than EveryNth events were loaded. It only looks at the
+// number of loaded events, see EventsSinceSnapshotStrategy for a version based alternative.
 type VersionIntervalStrategy struct {
 	EveryNth int
 	Store    sourcing.SnapshotStore
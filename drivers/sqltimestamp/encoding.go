@@ -0,0 +1,103 @@
+// Package sqltimestamp converts timestamps to and from the column encodings supported by the SQL
+// drivers.
+package sqltimestamp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrUnsupportedValue is the error returned when a scanned column value can't be decoded
+// into a timestamp
+var ErrUnsupportedValue = errors.New("unsupported timestamp value")
+
+// Encoding is the way a timestamp is stored in a SQL column
+type Encoding int
+
+const (
+	// UnixSeconds stores the timestamp as seconds since the epoch in an INT column. This is the
+	// default and discards sub-second precision and the timezone.
+	UnixSeconds Encoding = iota
+	// UnixNano stores the timestamp as nanoseconds since the epoch in a BIGINT column
+	UnixNano
+	// Native passes the time.Time to the driver as-is, for TIMESTAMP / TIMESTAMPTZ columns
+	Native
+	// RFC3339 stores the timestamp as RFC3339 text in UTC with nine fractional digits, so the text
+	// sorts and compares in time order. The offset is not preserved.
+	RFC3339
+)
+
+// rfc3339Fixed is time.RFC3339Nano without trimming trailing zeros from the fraction
+const rfc3339Fixed = "2006-01-02T15:04:05.000000000Z07:00"
+
+// String returns the name of the encoding
+func (e Encoding) String() string {
+	switch e {
+	case UnixSeconds:
+		return "unix_seconds"
+	case UnixNano:
+		return "unix_nano"
+	case Native:
+		return "native"
+	case RFC3339:
+		return "rfc3339"
+	}
+	return fmt.Sprintf("Encoding(%d)", int(e))
+}
+
+// Encode converts the timestamp into the value to be passed to the driver
+func (e Encoding) Encode(t time.Time) interface{} {
+	switch e {
+	case UnixNano:
+		return t.UnixNano()
+	case Native:
+		return t
+	case RFC3339:
+		return t.UTC().Format(rfc3339Fixed)
+	default:
+		return t.Unix()
+	}
+}
+
+// Decode converts a value scanned from the driver back into a timestamp. Drivers differ in the
+// types they return for a column, so integers, text and time.Time are accepted for every encoding.
+func (e Encoding) Decode(src interface{}) (time.Time, error) {
+	switch v := src.(type) {
+	case time.Time:
+		return v, nil
+	case int64:
+		return e.fromInt(v), nil
+	case int:
+		return e.fromInt(int64(v)), nil
+	case []byte:
+		return e.fromString(string(v))
+	case string:
+		return e.fromString(v)
+	case nil:
+		return time.Time{}, errors.Join(ErrUnsupportedValue, fmt.Errorf("timestamp is NULL"))
+	}
+
+	return time.Time{}, errors.Join(ErrUnsupportedValue, fmt.Errorf("type %T", src))
+}
+
+func (e Encoding) fromInt(i int64) time.Time {
+	if e == UnixNano {
+		return time.Unix(0, i)
+	}
+	return time.Unix(i, 0)
+}
+
+func (e Encoding) fromString(s string) (time.Time, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return e.fromInt(i), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, errors.Join(ErrUnsupportedValue, err)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,108 @@
+package sqltimestamp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	ts := time.Date(2024, 3, 14, 15, 9, 26, 535897932, loc)
+
+	tests := []struct {
+		encoding Encoding
+		want     time.Time
+		sameZone bool
+	}{
+		{UnixSeconds, ts.Truncate(time.Second), false},
+		{UnixNano, ts, false},
+		{Native, ts, true},
+		{RFC3339, ts, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoding.String(), func(t *testing.T) {
+			got, err := tt.encoding.Decode(tt.encoding.Encode(ts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+			if tt.sameZone && got.Format(time.RFC3339Nano) != tt.want.Format(time.RFC3339Nano) {
+				t.Fatalf("expected offset to be preserved, got %s", got)
+			}
+		})
+	}
+}
+
+func TestRFC3339Ordering(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	base := time.Date(2024, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	// each timestamp is later than the previous one, crossing whole-second and sub-second
+	// boundaries and written with different offsets
+	ordered := []time.Time{
+		base.Add(-time.Nanosecond),
+		base,
+		base.Add(time.Nanosecond).In(loc),
+		base.Add(100 * time.Millisecond),
+		base.Add(999999999 * time.Nanosecond).In(loc),
+		base.Add(time.Second),
+		base.Add(time.Second + 5*time.Millisecond).In(loc),
+	}
+
+	for i := 1; i < len(ordered); i++ {
+		prev, next := RFC3339.Encode(ordered[i-1]).(string), RFC3339.Encode(ordered[i]).(string)
+		if prev >= next {
+			t.Fatalf("expected %q to sort before %q", prev, next)
+		}
+		if len(prev) != len(next) {
+			t.Fatalf("expected fixed-width text, got %q and %q", prev, next)
+		}
+	}
+
+	if got := RFC3339.Encode(base.In(loc)); got != "2024-03-14T15:09:26.000000000Z" {
+		t.Fatalf("expected UTC with nine fractional digits, got %q", got)
+	}
+}
+
+func TestDecodeDriverRepresentations(t *testing.T) {
+	ts := time.Unix(0, 1710425366535897932)
+
+	tests := []struct {
+		name     string
+		encoding Encoding
+		src      interface{}
+		want     time.Time
+	}{
+		{"unix seconds as bytes", UnixSeconds, []byte("1710425366"), time.Unix(1710425366, 0)},
+		{"unix nano as string", UnixNano, "1710425366535897932", ts},
+		{"native as text", Native, ts.Format(time.RFC3339Nano), ts},
+		{"rfc3339 as bytes", RFC3339, []byte(ts.Format(time.RFC3339Nano)), ts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.encoding.Decode(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	if _, err := RFC3339.Decode(3.14); err == nil {
+		t.Fatal("expected error for unsupported type")
+	}
+	if _, err := RFC3339.Decode(nil); err == nil {
+		t.Fatal("expected error for NULL")
+	}
+	if _, err := RFC3339.Decode("not a time"); err == nil {
+		t.Fatal("expected error for malformed text")
+	}
+}
@@ -4,9 +4,11 @@ import "time"
 
 // Event is a struct that represents an event in the system
 type Event struct {
-	Type        string    // Type of event
-	Data        []byte    // Data of the event
-	Version     uint64    // Version of the event
-	Timestamp   time.Time // Timestamp of the event
-	AggregateID string    // AggregateID of the event
+	Type          string    // Type of event
+	Data          []byte    // Data of the event
+	Version       uint64    // Version of the event
+	Timestamp     time.Time // Timestamp of the event
+	AggregateID   string    // AggregateID of the event
+	AggregateType string    // AggregateType of the event, the stream category the aggregate belongs to
+	SchemaVersion uint64    // SchemaVersion of the event's Data, used to upcast old payload shapes
 }
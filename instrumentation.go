@@ -0,0 +1,167 @@
+package gosignal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Attr is a key-value pair describing a metric or span, such as the aggregate type
+type Attr struct {
+	Key   string
+	Value string
+}
+
+// Instrumentation receives metrics and traces from the repository, stores and queues. It is kept
+// free of dependencies so adapters for Prometheus, OpenTelemetry and the like can live outside the
+// module. Implementations must be safe for concurrent use.
+type Instrumentation interface {
+	// Count adds delta to the named counter
+	Count(name string, delta int64, attrs ...Attr)
+	// Observe records a value in the named histogram, durations are recorded in seconds
+	Observe(name string, value float64, attrs ...Attr)
+	// StartSpan starts a span, the returned context carries it so nested spans can find their parent
+	StartSpan(ctx context.Context, name string, attrs ...Attr) (context.Context, Span)
+}
+
+// Span is a traced operation started by Instrumentation.StartSpan
+type Span interface {
+	// RecordError marks the span as failed
+	RecordError(err error)
+	// End finishes the span
+	End()
+}
+
+// NopInstrumentation discards everything, it is used when no instrumentation is configured
+type NopInstrumentation struct{}
+
+func (NopInstrumentation) Count(string, int64, ...Attr) {}
+
+func (NopInstrumentation) Observe(string, float64, ...Attr) {}
+
+func (NopInstrumentation) StartSpan(ctx context.Context, _ string, _ ...Attr) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) RecordError(error) {}
+func (nopSpan) End()              {}
+
+// StartOperation starts a span for an operation and returns a function finishing it. The finish
+// function records the duration in the "<name>.duration" histogram, counts failures in the
+// "<name>.errors" counter and ends the span. A nil instrumentation is allowed and does nothing.
+func StartOperation(ctx context.Context, instr Instrumentation, name string, attrs ...Attr) (context.Context, func(err error)) {
+	if instr == nil {
+		return ctx, func(error) {}
+	}
+
+	start := time.Now()
+	ctx, span := instr.StartSpan(ctx, name, attrs...)
+
+	return ctx, func(err error) {
+		instr.Observe(name+".duration", time.Since(start).Seconds(), attrs...)
+		if err != nil {
+			instr.Count(name+".errors", 1, attrs...)
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
+
+// MemoryInstrumentation records metrics and spans in memory, intended for tests. Counters and
+// histograms are kept per name, attributes are only kept on spans. The zero value is ready to use.
+type MemoryInstrumentation struct {
+	mu           sync.Mutex
+	counters     map[string]int64
+	observations map[string][]float64
+	spans        []*RecordedSpan
+}
+
+// RecordedSpan is a span recorded by MemoryInstrumentation
+type RecordedSpan struct {
+	Name   string
+	Parent string // name of the enclosing span, if any
+	Attrs  []Attr
+	Err    error
+	Ended  bool
+}
+
+// memorySpan is the Span handed out by MemoryInstrumentation
+type memorySpan struct {
+	mi     *MemoryInstrumentation
+	record *RecordedSpan
+}
+
+type recordedSpanKey struct{}
+
+func (m *MemoryInstrumentation) Count(name string, delta int64, _ ...Attr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters == nil {
+		m.counters = make(map[string]int64)
+	}
+	m.counters[name] += delta
+}
+
+func (m *MemoryInstrumentation) Observe(name string, value float64, _ ...Attr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.observations == nil {
+		m.observations = make(map[string][]float64)
+	}
+	m.observations[name] = append(m.observations[name], value)
+}
+
+func (m *MemoryInstrumentation) StartSpan(ctx context.Context, name string, attrs ...Attr) (context.Context, Span) {
+	record := &RecordedSpan{Name: name, Attrs: attrs}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*RecordedSpan); ok {
+		record.Parent = parent.Name
+	}
+
+	m.mu.Lock()
+	m.spans = append(m.spans, record)
+	m.mu.Unlock()
+
+	return context.WithValue(ctx, recordedSpanKey{}, record), &memorySpan{mi: m, record: record}
+}
+
+// Counter returns the value of the named counter
+func (m *MemoryInstrumentation) Counter(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+// Observations returns the values recorded in the named histogram
+func (m *MemoryInstrumentation) Observations(name string) []float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]float64{}, m.observations[name]...)
+}
+
+// Spans returns copies of the recorded spans in the order they were started
+func (m *MemoryInstrumentation) Spans() []RecordedSpan {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	spans := make([]RecordedSpan, len(m.spans))
+	for i, span := range m.spans {
+		spans[i] = *span
+	}
+	return spans
+}
+
+func (s *memorySpan) RecordError(err error) {
+	s.mi.mu.Lock()
+	defer s.mi.mu.Unlock()
+	s.record.Err = err
+}
+
+func (s *memorySpan) End() {
+	s.mi.mu.Lock()
+	defer s.mi.mu.Unlock()
+	s.record.Ended = true
+}
+
+var _ Instrumentation = NopInstrumentation{}
+var _ Instrumentation = (*MemoryInstrumentation)(nil)
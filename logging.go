@@ -0,0 +1,61 @@
+package gosignal
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Attribute keys shared by the structured logs of the repository, stores and queues
+const (
+	LogKeyAggregateID   = "aggregate_id"
+	LogKeyAggregateType = "aggregate_type"
+	LogKeyEventType     = "event_type"
+	LogKeyVersion       = "version"
+	LogKeyDuration      = "duration"
+	LogKeySagaID        = "saga_id"
+)
+
+var discardLogger = slog.New(discardHandler{})
+
+// DiscardLogger returns a logger that drops every record, it is used when no logger is configured
+func DiscardLogger() *slog.Logger {
+	return discardLogger
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// LogOperation logs the outcome of an operation that started at start with its duration, failures
+// at warn level and successes at debug level. A nil logger is allowed and logs nothing.
+func LogOperation(ctx context.Context, logger *slog.Logger, msg string, start time.Time, err error, attrs ...slog.Attr) {
+	if logger == nil {
+		return
+	}
+
+	attrs = append(attrs, slog.Duration(LogKeyDuration, time.Since(start)))
+	if err != nil {
+		logger.LogAttrs(ctx, slog.LevelWarn, msg+" failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, msg, attrs...)
+}
+
+// StartStoreOperation reports an operation on a store's table to the instrumentation and the
+// logger, either may be nil. This is the convention of the stores in drivers: the span and the
+// metrics of StartOperation are named "gosignal.<store>.<operation>" and carry the table name, and
+// LogOperation logs the operation under the same name along with attrs and the table name.
+func StartStoreOperation(ctx context.Context, instr Instrumentation, logger *slog.Logger, store, operation, table string, attrs ...slog.Attr) (context.Context, func(error)) {
+	name := "gosignal." + store + "." + operation
+	start := time.Now()
+	ctx, done := StartOperation(ctx, instr, name, Attr{Key: "table", Value: table})
+
+	return ctx, func(err error) {
+		done(err)
+		LogOperation(ctx, logger, name, start, err, append(attrs, slog.String("table", table))...)
+	}
+}
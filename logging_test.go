@@ -0,0 +1,29 @@
+package gosignal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestStartStoreOperation(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	instr := &MemoryInstrumentation{}
+
+	_, done := StartStoreOperation(context.Background(), instr, logger, "eventstore.sql", "load", "events", slog.String(LogKeyAggregateID, "a"))
+	done(errors.New("unavailable"))
+
+	spans := instr.Spans()
+	if len(spans) != 1 || spans[0].Name != "gosignal.eventstore.sql.load" || spans[0].Attrs[0] != (Attr{Key: "table", Value: "events"}) {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+	for _, want := range []string{"level=WARN", `msg="gosignal.eventstore.sql.load failed"`, "aggregate_id=a", "table=events", "error=unavailable"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %s in %q", want, buf.String())
+		}
+	}
+}
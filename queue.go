@@ -20,3 +20,10 @@ type QueueMessage interface {
 type RetryParams struct {
 	BackoffUntil time.Time
 }
+
+// ReadinessChecker is implemented by queues that can report whether they are able to accept
+// messages, for example whether the connection to a broker is up
+type ReadinessChecker interface {
+	// Ready returns an error if messages can't be sent right now
+	Ready() error
+}
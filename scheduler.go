@@ -0,0 +1,194 @@
+package gosignal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrSchedulerNotConfigured is the error returned when a Scheduler is used without a Queue or Store
+var ErrSchedulerNotConfigured = errors.New("scheduler queue or store not set")
+
+// ScheduledQueue is implemented by queues that can hold messages back until a point in time
+type ScheduledQueue interface {
+	Queue
+	// SendAt publishes the message once at has passed, messages due now are sent immediately
+	SendAt(messageType string, message []byte, at time.Time) error
+}
+
+// ScheduledMessage is a message waiting in a ScheduleStore
+type ScheduledMessage struct {
+	ID      string
+	Type    string
+	Message []byte
+	DueAt   time.Time
+}
+
+// ScheduleStore durably holds scheduled messages until they are published
+type ScheduleStore interface {
+	// Schedule stores a message for later delivery
+	Schedule(ctx context.Context, msg ScheduledMessage) error
+	// Claim returns up to limit messages due at now, ordered by due time, and hides them from other
+	// claims until the lease expires. Messages are only removed by Remove, so a dispatcher that
+	// crashes before removing them has its messages claimed again after the lease.
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]ScheduledMessage, error)
+	// Remove deletes a message once it has been published
+	Remove(ctx context.Context, id string) error
+}
+
+// Scheduler adds SendAt to a queue by keeping future messages in a ScheduleStore, Run publishes
+// them once they are due. Delivery is at least once: a message is removed from the store after
+// it was sent, so a failure in between sends it again.
+//
+// Any number of Schedulers may share a store, the lease keeps them from claiming the same message
+// at the same time. Lease must exceed the time needed to publish a batch.
+type Scheduler struct {
+	Queue        Queue
+	Store        ScheduleStore
+	PollInterval time.Duration    // how often Run checks for due messages, defaults to a second
+	BatchSize    int              // maximum messages claimed at once, defaults to 100
+	Lease        time.Duration    // defaults to 30 seconds
+	OnError      func(err error)  // receives errors from Run, which keeps running
+	Now          func() time.Time // defaults to time.Now
+	Logger       *slog.Logger     // logs dispatched messages at debug level and Run's errors at error level
+}
+
+// Send publishes the message immediately
+func (s *Scheduler) Send(messageType string, message []byte) error {
+	if s.Queue == nil {
+		return ErrSchedulerNotConfigured
+	}
+	return s.Queue.Send(messageType, message)
+}
+
+// SendAt stores the message until at, messages due now are sent immediately
+func (s *Scheduler) SendAt(messageType string, message []byte, at time.Time) error {
+	if s.Queue == nil || s.Store == nil {
+		return ErrSchedulerNotConfigured
+	}
+
+	if !at.After(s.now()) {
+		return s.Queue.Send(messageType, message)
+	}
+
+	id, err := newScheduledMessageID()
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Schedule(context.Background(), ScheduledMessage{ID: id, Type: messageType, Message: message, DueAt: at})
+}
+
+// Subscribe subscribes to the underlying queue
+func (s *Scheduler) Subscribe(messageType string) (string, chan QueueMessage, error) {
+	if s.Queue == nil {
+		return "", nil, ErrSchedulerNotConfigured
+	}
+	return s.Queue.Subscribe(messageType)
+}
+
+// Unsubscribe unsubscribes from the underlying queue
+func (s *Scheduler) Unsubscribe(messageType, id string) error {
+	if s.Queue == nil {
+		return ErrSchedulerNotConfigured
+	}
+	return s.Queue.Unsubscribe(messageType, id)
+}
+
+// DispatchDue publishes every message that is due and returns how many were sent
+func (s *Scheduler) DispatchDue(ctx context.Context) (int, error) {
+	if s.Queue == nil || s.Store == nil {
+		return 0, ErrSchedulerNotConfigured
+	}
+
+	batchSize := s.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	lease := s.Lease
+	if lease <= 0 {
+		lease = 30 * time.Second
+	}
+
+	sent := 0
+	for {
+		messages, err := s.Store.Claim(ctx, s.now(), batchSize, lease)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, msg := range messages {
+			s.logger().DebugContext(ctx, "dispatching scheduled message", slog.String("message_id", msg.ID),
+				slog.String(LogKeyEventType, msg.Type), slog.Time("due_at", msg.DueAt))
+
+			if err := s.Queue.Send(msg.Type, msg.Message); err != nil {
+				return sent, fmt.Errorf("sending scheduled message %s: %w", msg.ID, err)
+			}
+			if err := s.Store.Remove(ctx, msg.ID); err != nil {
+				return sent, fmt.Errorf("removing scheduled message %s: %w", msg.ID, err)
+			}
+			sent++
+		}
+
+		if len(messages) < batchSize {
+			return sent, nil
+		}
+	}
+}
+
+// Run dispatches due messages every PollInterval until ctx is done
+func (s *Scheduler) Run(ctx context.Context) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.DispatchDue(ctx); err != nil {
+			if errors.Is(err, ErrSchedulerNotConfigured) {
+				return err
+			}
+			if ctx.Err() == nil {
+				s.logger().ErrorContext(ctx, "dispatching scheduled messages failed", slog.Any("error", err))
+				if s.OnError != nil {
+					s.OnError(err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Scheduler) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return DiscardLogger()
+}
+
+func newScheduledMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
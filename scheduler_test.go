@@ -0,0 +1,115 @@
+package gosignal
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+// recordingQueue is a Queue recording sent message types
+type recordingQueue struct {
+	sent    []string
+	sendErr error
+}
+
+func (q *recordingQueue) Send(messageType string, _ []byte) error {
+	if q.sendErr != nil {
+		return q.sendErr
+	}
+	q.sent = append(q.sent, messageType)
+	return nil
+}
+
+func (q *recordingQueue) Subscribe(string) (string, chan QueueMessage, error) {
+	return "", nil, nil
+}
+
+func (q *recordingQueue) Unsubscribe(string, string) error {
+	return nil
+}
+
+// testScheduleStore is a minimal ScheduleStore, drivers/scheduler holds the real ones
+type testScheduleStore struct {
+	messages     map[string]ScheduledMessage
+	claimedUntil map[string]time.Time
+}
+
+func (s *testScheduleStore) Schedule(_ context.Context, msg ScheduledMessage) error {
+	if s.messages == nil {
+		s.messages = make(map[string]ScheduledMessage)
+		s.claimedUntil = make(map[string]time.Time)
+	}
+	s.messages[msg.ID] = msg
+	return nil
+}
+
+func (s *testScheduleStore) Claim(_ context.Context, now time.Time, limit int, lease time.Duration) ([]ScheduledMessage, error) {
+	var due []ScheduledMessage
+	for id, msg := range s.messages {
+		if !msg.DueAt.After(now) && !s.claimedUntil[id].After(now) {
+			due = append(due, msg)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for _, msg := range due {
+		s.claimedUntil[msg.ID] = now.Add(lease)
+	}
+	return due, nil
+}
+
+func (s *testScheduleStore) Remove(_ context.Context, id string) error {
+	delete(s.messages, id)
+	return nil
+}
+
+func TestSchedulerSendAt(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	queue := &recordingQueue{}
+	scheduler := &Scheduler{Queue: queue, Store: &testScheduleStore{}, BatchSize: 1, Now: func() time.Time { return now }}
+
+	if err := scheduler.SendAt("now", nil, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, messageType := range []string{"later", "much later"} {
+		if err := scheduler.SendAt(messageType, nil, now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(queue.sent) != 1 || queue.sent[0] != "now" {
+		t.Fatalf("expected only the due message to be sent, got %v", queue.sent)
+	}
+
+	if n, err := scheduler.DispatchDue(ctx); err != nil || n != 0 {
+		t.Fatalf("expected nothing due, sent %d: %v", n, err)
+	}
+
+	now = now.Add(time.Hour)
+	queue.sendErr = errors.New("unavailable")
+	if _, err := scheduler.DispatchDue(ctx); !errors.Is(err, queue.sendErr) {
+		t.Fatalf("expected the send error, got %v", err)
+	}
+
+	now = now.Add(time.Hour) // past the lease of the failed claim
+	queue.sendErr = nil
+	if n, err := scheduler.DispatchDue(ctx); err != nil || n != 2 {
+		t.Fatalf("expected both messages to be sent across batches, sent %d: %v", n, err)
+	}
+	if n, _ := scheduler.DispatchDue(ctx); n != 0 {
+		t.Fatalf("expected sent messages to be removed, sent %d again", n)
+	}
+}
+
+func TestSchedulerRequiresQueueAndStore(t *testing.T) {
+	if err := (&Scheduler{Queue: &recordingQueue{}}).SendAt("later", nil, time.Now().Add(time.Hour)); !errors.Is(err, ErrSchedulerNotConfigured) {
+		t.Fatalf("expected ErrSchedulerNotConfigured, got %v", err)
+	}
+	if err := (&Scheduler{}).Run(context.Background()); !errors.Is(err, ErrSchedulerNotConfigured) {
+		t.Fatalf("expected Run to stop without a queue, got %v", err)
+	}
+}
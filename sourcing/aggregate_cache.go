@@ -0,0 +1,205 @@
+package sourcing
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// AggregateCache is a least recently used cache of loaded aggregates for a Repository, see
+// WithAggregateCache. It holds clones, so only aggregates implementing AggregateCloner are cached,
+// and is bounded by the number of entries and optionally by the total size of their exported state.
+// A cache must not be shared between repositories.
+type AggregateCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+	now        func() time.Time
+	size       int
+	order      *list.List // front is most recently used
+	entries    map[string]*list.Element
+}
+
+type cacheEntry struct {
+	id      string
+	agg     Aggregate
+	size    int
+	expires time.Time
+}
+
+// AggregateCacheOption configures an AggregateCache
+type AggregateCacheOption func(*AggregateCache)
+
+// WithCacheTTL evicts entries the given time after they were cached
+func WithCacheTTL(ttl time.Duration) AggregateCacheOption {
+	return func(c *AggregateCache) {
+		c.ttl = ttl
+	}
+}
+
+// WithCacheMaxBytes bounds the total size of the cached aggregates' exported state, which is
+// measured with ExportState every time an aggregate is cached
+func WithCacheMaxBytes(n int) AggregateCacheOption {
+	return func(c *AggregateCache) {
+		c.maxBytes = n
+	}
+}
+
+// NewAggregateCache creates a cache holding at most maxEntries aggregates
+func NewAggregateCache(maxEntries int, options ...AggregateCacheOption) *AggregateCache {
+	c := &AggregateCache{
+		maxEntries: maxEntries,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// WithAggregateCache serves Load from the cache when possible: a cached aggregate is copied into
+// the caller's aggregate and only the events stored after it was cached are loaded and applied.
+// Only loads without options use the cache. Entries are dropped on ReplaceVersion, deletion and
+// version conflicts. Snapshots are only taken when the aggregate is loaded from the stores, on a
+// cache miss.
+func WithAggregateCache(cache *AggregateCache) func(*Repository) {
+	return func(r *Repository) {
+		r.cache = cache
+	}
+}
+
+// Len returns the number of cached aggregates
+func (c *AggregateCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Invalidate drops the aggregate from the cache
+func (c *AggregateCache) Invalidate(aggregateID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[aggregateID]; ok {
+		c.remove(el)
+	}
+}
+
+// get returns a clone of the cached aggregate, or nil
+func (c *AggregateCache) get(aggregateID string) Aggregate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[aggregateID]
+	if !ok {
+		return nil
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.remove(el)
+		return nil
+	}
+
+	c.order.MoveToFront(el)
+	return entry.agg.(AggregateCloner).Clone()
+}
+
+// put caches a clone of the aggregate, aggregates that aren't AggregateCloners or don't fit are
+// not cached
+func (c *AggregateCache) put(agg Aggregate) {
+	cloner, ok := agg.(AggregateCloner)
+	if !ok {
+		return
+	}
+
+	size := 0
+	if c.maxBytes > 0 {
+		state, err := agg.ExportState()
+		if err != nil || len(state) > c.maxBytes {
+			c.Invalidate(agg.GetID())
+			return
+		}
+		size = len(state)
+	}
+
+	entry := &cacheEntry{id: agg.GetID(), agg: cloner.Clone(), size: size, expires: c.now().Add(c.ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[entry.id]; ok {
+		c.remove(el)
+	}
+	c.entries[entry.id] = c.order.PushFront(entry)
+	c.size += size
+
+	for c.order.Len() > c.maxEntries || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *AggregateCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.id)
+	c.size -= entry.size
+}
+
+// loadCached loads the aggregate from the cache and catches it up with the event store, it
+// reports whether the cache was used
+func (r *Repository) loadCached(ctx context.Context, agg Aggregate) (bool, error) {
+	aggID := agg.GetID()
+
+	cached := r.cache.get(aggID)
+	if cached == nil || !assignAggregate(agg, cached) {
+		r.instr().Count(MetricCacheMisses, 1, r.metricAttrs()...)
+		return false, nil
+	}
+	r.instr().Count(MetricCacheHits, 1, r.metricAttrs()...)
+
+	events, err := r.LoadEvents(ctx, aggID, NewRepoLoaderConfigurator().MinVersion(agg.GetVersion()).Build())
+	if err != nil {
+		return true, errors.Join(ErrLoadingEvents, err)
+	}
+
+	if isTombstoned(events) {
+		r.cache.Invalidate(aggID)
+		return true, ErrAggregateDeleted
+	}
+
+	if err := r.ApplyEvents(agg, events); err != nil {
+		r.cache.Invalidate(aggID)
+		return true, errors.Join(ErrApplyingEvent, err)
+	}
+	r.instr().Observe(MetricEventsApplied, float64(len(events)), r.metricAttrs()...)
+
+	if len(events) > 0 {
+		r.cache.put(agg)
+	}
+
+	return true, nil
+}
+
+// invalidateCached drops the aggregate from the cache, if there is one
+func (r *Repository) invalidateCached(aggregateID string) {
+	if r.cache != nil {
+		r.cache.Invalidate(aggregateID)
+	}
+}
+
+// assignAggregate copies src into dst, both must be pointers to the same type
+func assignAggregate(dst, src Aggregate) bool {
+	dv, sv := reflect.ValueOf(dst), reflect.ValueOf(src)
+	if dv.Kind() != reflect.Pointer || dv.IsNil() || dv.Type() != sv.Type() {
+		return false
+	}
+
+	dv.Elem().Set(sv.Elem())
+	return true
+}
@@ -0,0 +1,184 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// loadRecordingStore records the aggregate ids and options of every Load
+type loadRecordingStore struct {
+	*testEventStore
+	loaded []string
+	loads  []LoadEventsOptions
+}
+
+func (s *loadRecordingStore) Load(ctx context.Context, aggID string, options LoadEventsOptions) ([]gosignal.Event, error) {
+	s.loaded = append(s.loaded, aggID)
+	s.loads = append(s.loads, options)
+	return s.testEventStore.Load(ctx, aggID, options)
+}
+
+func loadCloningCounter(t *testing.T, repo *Repository, id string) *cloningCounter {
+	t.Helper()
+	c := &cloningCounter{}
+	c.SetID(id)
+	if err := repo.Load(context.Background(), c, nil); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRepositoryLoadCatchesUpFromCache(t *testing.T) {
+	ctx := context.Background()
+	store := &loadRecordingStore{testEventStore: newTestEventStore()}
+	cache := NewAggregateCache(10)
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateCache(cache))
+
+	if err := repo.Store(ctx, incremented("c1", 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	first := loadCloningCounter(t, repo, "c1")
+	first.Count = 100 // callers own what Load returns, the cached copy is unaffected
+
+	if err := repo.Store(ctx, incremented("c1", 3, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	second := loadCloningCounter(t, repo, "c1")
+	if second.Count != 4 || second.GetVersion() != 4 {
+		t.Fatalf("unexpected state: version %d, count %d", second.GetVersion(), second.Count)
+	}
+
+	last := store.loads[len(store.loads)-1]
+	if last.MinVersion == nil || *last.MinVersion != 3 {
+		t.Fatalf("expected only events after the cached version to be loaded, got %+v", last)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("expected one cached aggregate, got %d", cache.Len())
+	}
+}
+
+func TestRepositoryCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	store := &uniqueVersionStore{testEventStore: newTestEventStore()}
+	cache := NewAggregateCache(10)
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateCache(cache))
+
+	if err := repo.Store(ctx, incremented("c1", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	loadCloningCounter(t, repo, "c1")
+	if err := repo.Store(ctx, incremented("c1", 1, 1)); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+	if cache.Len() != 0 {
+		t.Fatal("expected a version conflict to invalidate the cached aggregate")
+	}
+
+	loadCloningCounter(t, repo, "c1")
+	replacement := gosignal.Event{Type: "noop", Version: 1, AggregateID: "c1"}
+	if err := repo.ReplaceVersion(ctx, "c1", &counter{}, 1, replacement); err != nil {
+		t.Fatal(err)
+	}
+	if c := loadCloningCounter(t, repo, "c1"); c.Count != 1 {
+		t.Fatalf("expected the replaced event to be loaded, count %d", c.Count)
+	}
+
+	if err := repo.HardDelete(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Load(ctx, &cloningCounter{counter{DefaultAggregate: DefaultAggregate{ID: "c1"}}}, nil); !errors.Is(err, ErrNoEvents) {
+		t.Fatalf("expected a hard deleted aggregate not to be served from the cache, got %v", err)
+	}
+}
+
+// replaceHookStore runs beforeReplace ahead of every Replace
+type replaceHookStore struct {
+	*testEventStore
+	beforeReplace func()
+}
+
+func (s *replaceHookStore) Replace(ctx context.Context, id string, version uint64, event gosignal.Event) error {
+	s.beforeReplace()
+	return s.testEventStore.Replace(ctx, id, version, event)
+}
+
+func TestRepositoryReplaceVersionInvalidatesConcurrentLoads(t *testing.T) {
+	ctx := context.Background()
+	store := &replaceHookStore{testEventStore: newTestEventStore()}
+	cache := NewAggregateCache(10)
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateCache(cache))
+
+	if err := repo.Store(ctx, incremented("c1", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a load landing between the invalidation and the replace caches the old event
+	store.beforeReplace = func() { loadCloningCounter(t, repo, "c1") }
+
+	replacement := gosignal.Event{Type: "noop", Version: 1, AggregateID: "c1"}
+	if err := repo.ReplaceVersion(ctx, "c1", &counter{}, 1, replacement); err != nil {
+		t.Fatal(err)
+	}
+	if c := loadCloningCounter(t, repo, "c1"); c.Count != 1 {
+		t.Fatalf("expected the replaced event to be loaded, count %d", c.Count)
+	}
+}
+
+func TestAggregateCacheEviction(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cached := func(id string, count int) *cloningCounter {
+		c := &cloningCounter{}
+		c.SetID(id)
+		c.Count = count
+		return c
+	}
+
+	t.Run("least recently used", func(t *testing.T) {
+		cache := NewAggregateCache(2)
+		cache.put(cached("a", 1))
+		cache.put(cached("b", 1))
+		cache.get("a")
+		cache.put(cached("c", 1))
+
+		if cache.get("b") != nil || cache.get("a") == nil || cache.get("c") == nil {
+			t.Fatal("expected the least recently used aggregate to be evicted")
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		cache := NewAggregateCache(2, WithCacheTTL(time.Minute))
+		cache.now = func() time.Time { return now }
+		cache.put(cached("a", 1))
+
+		cache.now = func() time.Time { return now.Add(time.Minute) }
+		if cache.get("a") != nil || cache.Len() != 0 {
+			t.Fatal("expected the expired aggregate to be evicted")
+		}
+	})
+
+	t.Run("size", func(t *testing.T) {
+		cache := NewAggregateCache(10, WithCacheMaxBytes(4))
+		cache.put(cached("a", 100)) // state "100"
+		cache.put(cached("b", 10))  // state "10"
+		cache.put(cached("c", 123456))
+
+		if cache.get("a") != nil || cache.get("b") == nil || cache.get("c") != nil {
+			t.Fatal("expected the size bound to evict the oldest entry and skip oversized ones")
+		}
+	})
+
+	t.Run("requires cloner", func(t *testing.T) {
+		cache := NewAggregateCache(2)
+		cache.put(&counter{})
+		if cache.Len() != 0 {
+			t.Fatal("expected aggregates without Clone not to be cached")
+		}
+	})
+}
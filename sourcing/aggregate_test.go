@@ -0,0 +1,66 @@
+package sourcing
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Howard3/gosignal"
+)
+
+// counter is a minimal aggregate used by the package tests, every "incremented" event adds one
+type counter struct {
+	DefaultAggregate
+	Count int
+}
+
+func (c *counter) Apply(event gosignal.Event) error {
+	return SafeApply(event, c, func(event gosignal.Event) error {
+		switch event.Type {
+		case "incremented":
+			c.Count++
+		case "fail":
+			return errors.New("fail event")
+		}
+		return nil
+	})
+}
+
+func (c *counter) ImportState(data []byte) error {
+	return json.Unmarshal(data, &c.Count)
+}
+
+func (c *counter) ExportState() ([]byte, error) {
+	return json.Marshal(c.Count)
+}
+
+// cloningCounter is a counter implementing AggregateCloner
+type cloningCounter struct {
+	counter
+}
+
+func (c *cloningCounter) Clone() Aggregate {
+	clone := *c
+	return &clone
+}
+
+func incremented(aggID string, from, to uint64) []gosignal.Event {
+	var events []gosignal.Event
+	for v := from; v <= to; v++ {
+		events = append(events, gosignal.Event{Type: "incremented", Version: v, AggregateID: aggID})
+	}
+	return events
+}
+
+func TestSafeApplyRejectsOutOfOrderEvents(t *testing.T) {
+	c := &counter{}
+	if err := c.Apply(gosignal.Event{Type: "incremented", Version: 1}); !errors.Is(err, ErrEventVersionNE) {
+		t.Fatalf("expected ErrEventVersionNE, got %v", err)
+	}
+	if err := c.Apply(gosignal.Event{Type: "incremented", Version: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetVersion() != 1 || c.Count != 1 {
+		t.Fatalf("unexpected state: version %d, count %d", c.GetVersion(), c.Count)
+	}
+}
@@ -0,0 +1,168 @@
+package sourcing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/Howard3/gosignal"
+)
+
+// ErrNoCommandHandler is the error returned when dispatching a command without a registered handler
+var ErrNoCommandHandler = errors.New("no command handler")
+
+// ErrCommandHandlerExists is the error returned when registering a second handler for a command type
+var ErrCommandHandlerExists = errors.New("command handler already registered")
+
+// ErrInvalidCommand is the error returned by ValidationMiddleware, it is joined with the error
+// returned by the command's Validate method
+var ErrInvalidCommand = errors.New("invalid command")
+
+// Command is a request to change the state of one aggregate
+type Command interface {
+	// AggregateID returns the id of the aggregate the command targets
+	AggregateID() string
+}
+
+// CommandValidator is implemented by commands that can check themselves before being handled
+type CommandValidator interface {
+	Validate() error
+}
+
+// CommandDispatchFunc handles a command, it is the unit wrapped by middleware
+type CommandDispatchFunc func(ctx context.Context, cmd Command) error
+
+// CommandMiddleware wraps command dispatch, for validation, authorization, logging and the like.
+// Middleware runs in the order it is added, once per dispatch; retries happen inside it.
+type CommandMiddleware func(next CommandDispatchFunc) CommandDispatchFunc
+
+// CommandBus routes commands to typed handlers. For every command it loads the target aggregate,
+// passes it to the handler, and saves the returned events through Repository.Update, so version
+// conflicts are retried following the repository's RetryPolicy.
+type CommandBus struct {
+	repo       *Repository
+	mu         sync.RWMutex
+	handlers   map[reflect.Type]CommandDispatchFunc
+	middleware []CommandMiddleware
+}
+
+// CommandBusOption configures a CommandBus
+type CommandBusOption func(*CommandBus)
+
+// WithCommandMiddleware adds middleware to the bus
+func WithCommandMiddleware(middleware ...CommandMiddleware) CommandBusOption {
+	return func(b *CommandBus) {
+		b.middleware = append(b.middleware, middleware...)
+	}
+}
+
+// NewCommandBus creates a bus loading and saving aggregates through the repository
+func NewCommandBus(repo *Repository, options ...CommandBusOption) *CommandBus {
+	b := &CommandBus{
+		repo:     repo,
+		handlers: make(map[reflect.Type]CommandDispatchFunc),
+	}
+	for _, option := range options {
+		option(b)
+	}
+	return b
+}
+
+// CommandHandler decides which events a command produces for the loaded aggregate, it follows the
+// same rules as UpdateFunc and may be called several times for one command.
+type CommandHandler[C Command, A Aggregate] func(ctx context.Context, cmd C, agg A) ([]gosignal.Event, error)
+
+// Handle registers the handler for commands of type C, newAgg returns an empty aggregate to load
+// the command's target into
+func Handle[C Command, A Aggregate](b *CommandBus, newAgg func() A, handler CommandHandler[C, A]) error {
+	t := reflect.TypeOf((*C)(nil)).Elem()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[t]; ok {
+		return errors.Join(ErrCommandHandlerExists, fmt.Errorf("command type %s", t))
+	}
+
+	b.handlers[t] = func(ctx context.Context, cmd Command) error {
+		typed := cmd.(C)
+		return b.repo.Update(ctx, cmd.AggregateID(), func() Aggregate { return newAgg() }, func(ctx context.Context, agg Aggregate) ([]gosignal.Event, error) {
+			return handler(ctx, typed, agg.(A))
+		})
+	}
+
+	return nil
+}
+
+// Dispatch runs the command through the middleware and its handler
+func (b *CommandBus) Dispatch(ctx context.Context, cmd Command) error {
+	b.mu.RLock()
+	handler, ok := b.handlers[reflect.TypeOf(cmd)]
+	b.mu.RUnlock()
+
+	if !ok {
+		return errors.Join(ErrNoCommandHandler, fmt.Errorf("command type %T", cmd))
+	}
+
+	dispatch := handler
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		dispatch = b.middleware[i](dispatch)
+	}
+
+	return dispatch(ctx, cmd)
+}
+
+// commandName returns the name a command type is recorded under, qualified by its package path
+func commandName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		return "*" + commandName(t.Elem())
+	}
+	if t.PkgPath() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// decodeCommand rebuilds a command recorded by name from its JSON encoding, the command type
+// must have a registered handler
+func (b *CommandBus) decodeCommand(name string, data []byte) (Command, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for t := range b.handlers {
+		if commandName(t) != name {
+			continue
+		}
+
+		if t.Kind() == reflect.Pointer {
+			v := reflect.New(t.Elem())
+			if err := json.Unmarshal(data, v.Interface()); err != nil {
+				return nil, err
+			}
+			return v.Interface().(Command), nil
+		}
+
+		v := reflect.New(t)
+		if err := json.Unmarshal(data, v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Elem().Interface().(Command), nil
+	}
+
+	return nil, errors.Join(ErrNoCommandHandler, fmt.Errorf("command type %s", name))
+}
+
+// ValidationMiddleware rejects commands implementing CommandValidator whose Validate fails
+func ValidationMiddleware(next CommandDispatchFunc) CommandDispatchFunc {
+	return func(ctx context.Context, cmd Command) error {
+		if v, ok := cmd.(CommandValidator); ok {
+			if err := v.Validate(); err != nil {
+				return errors.Join(ErrInvalidCommand, err)
+			}
+		}
+		return next(ctx, cmd)
+	}
+}
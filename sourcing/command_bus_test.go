@@ -0,0 +1,156 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// uniqueVersionStore rejects events whose version is already stored, beforeStore runs first on
+// Store and Append so tests can slip in a concurrent write
+type uniqueVersionStore struct {
+	*testEventStore
+	beforeStore func()
+}
+
+func (s *uniqueVersionStore) Append(ctx context.Context, streams []StreamAppend) error {
+	if s.beforeStore != nil {
+		s.beforeStore()
+	}
+	return s.testEventStore.Append(ctx, streams)
+}
+
+func (s *uniqueVersionStore) Store(ctx context.Context, events []gosignal.Event) error {
+	if s.beforeStore != nil {
+		s.beforeStore()
+	}
+	s.mu.Lock()
+	for _, event := range events {
+		for _, existing := range s.events[event.AggregateID] {
+			if existing.Version == event.Version {
+				s.mu.Unlock()
+				return ErrVersionConflict
+			}
+		}
+	}
+	s.mu.Unlock()
+	return s.testEventStore.Store(ctx, events)
+}
+
+type increment struct {
+	ID    string
+	Times int
+}
+
+func (c increment) AggregateID() string { return c.ID }
+
+func (c increment) Validate() error {
+	if c.Times < 1 {
+		return errors.New("times must be positive")
+	}
+	return nil
+}
+
+func newCounter() *counter { return &counter{} }
+
+func handleIncrement(_ context.Context, cmd increment, _ *counter) ([]gosignal.Event, error) {
+	events := make([]gosignal.Event, cmd.Times)
+	for i := range events {
+		events[i].Type = "incremented"
+	}
+	return events, nil
+}
+
+func newTestCommandBus(t *testing.T, store EventStore, options ...CommandBusOption) (*CommandBus, *Repository) {
+	t.Helper()
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}),
+		WithRetryPolicy(RetryPolicy{BaseDelay: time.Microsecond}))
+	bus := NewCommandBus(repo, options...)
+	if err := Handle(bus, newCounter, handleIncrement); err != nil {
+		t.Fatal(err)
+	}
+	return bus, repo
+}
+
+func TestCommandBusDispatch(t *testing.T) {
+	ctx := context.Background()
+	bus, repo := newTestCommandBus(t, newTestEventStore())
+
+	for i := 0; i < 2; i++ {
+		if err := bus.Dispatch(ctx, increment{ID: "c1", Times: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &counter{}
+	c.SetID("c1")
+	if err := repo.Load(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.Count != 4 || c.GetVersion() != 4 {
+		t.Fatalf("unexpected state: version %d, count %d", c.GetVersion(), c.Count)
+	}
+}
+
+func TestCommandBusRetriesVersionConflicts(t *testing.T) {
+	ctx := context.Background()
+	store := &uniqueVersionStore{testEventStore: newTestEventStore()}
+	bus, _ := newTestCommandBus(t, store)
+
+	conflicts := 1
+	store.beforeStore = func() {
+		if conflicts > 0 {
+			conflicts--
+			_ = store.testEventStore.Store(ctx, incremented("c1", 0, 0))
+		}
+	}
+
+	if err := bus.Dispatch(ctx, increment{ID: "c1", Times: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := store.Load(ctx, "c1", LoadEventsOptions{}); len(events) != 2 {
+		t.Fatalf("expected the retry to store after the concurrent write, got %d events", len(events))
+	}
+
+	store.beforeStore = func() {
+		events, _ := store.testEventStore.Load(ctx, "c1", LoadEventsOptions{})
+		_ = store.testEventStore.Store(ctx, incremented("c1", uint64(len(events)), uint64(len(events))))
+	}
+	if err := bus.Dispatch(ctx, increment{ID: "c1", Times: 1}); !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected ErrRetriesExhausted once attempts are used up, got %v", err)
+	}
+}
+
+func TestCommandBusMiddleware(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	trace := func(next CommandDispatchFunc) CommandDispatchFunc {
+		return func(ctx context.Context, cmd Command) error {
+			calls = append(calls, fmt.Sprintf("%T", cmd))
+			return next(ctx, cmd)
+		}
+	}
+	bus, _ := newTestCommandBus(t, newTestEventStore(), WithCommandMiddleware(trace, ValidationMiddleware))
+
+	if err := bus.Dispatch(ctx, increment{ID: "c1"}); !errors.Is(err, ErrInvalidCommand) {
+		t.Fatalf("expected ErrInvalidCommand, got %v", err)
+	}
+	if len(calls) != 1 || calls[0] != "sourcing.increment" {
+		t.Fatalf("unexpected middleware calls: %v", calls)
+	}
+}
+
+func TestCommandBusHandlerRegistration(t *testing.T) {
+	bus, _ := newTestCommandBus(t, newTestEventStore())
+
+	if err := Handle(bus, newCounter, handleIncrement); !errors.Is(err, ErrCommandHandlerExists) {
+		t.Fatalf("expected ErrCommandHandlerExists, got %v", err)
+	}
+	if err := bus.Dispatch(context.Background(), &increment{ID: "c1", Times: 1}); !errors.Is(err, ErrNoCommandHandler) {
+		t.Fatalf("expected ErrNoCommandHandler for an unregistered type, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package sourcing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/Howard3/gosignal"
+)
+
+// ErrUnknownEventType is the error returned when an event has no registered handler
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// ErrDecodingEvent is the error returned when an event's data can't be decoded into the handler's
+// payload type, it is joined with the underlying error
+var ErrDecodingEvent = errors.New("error decoding event")
+
+// Serializer encodes and decodes event payloads to and from gosignal.Event.Data
+type Serializer interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONSerializer serializes payloads with encoding/json
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONSerializer) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// EventTyper is implemented by payloads that name their event type. Payloads that don't implement
+// it are named after their Go type.
+type EventTyper interface {
+	EventType() string
+}
+
+// EventRegistry routes events to typed handlers, replacing the switch on event.Type in an
+// aggregate's Apply method. Handlers are registered with On, and the aggregate delegates to the
+// registry:
+//
+//	func NewOrder() *Order {
+//		o := &Order{}
+//		o.events = sourcing.NewEventRegistry(o)
+//		sourcing.On(o.events, o.placed) // func (o *Order) placed(e OrderPlaced) error
+//		return o
+//	}
+//
+//	func (o *Order) Apply(e gosignal.Event) error {
+//		return o.events.Apply(e)
+//	}
+type EventRegistry struct {
+	agg        Aggregate
+	serializer Serializer
+	types      *TypeRegistry
+	handlers   map[string]func(gosignal.Event) error
+}
+
+// EventRegistryOption configures an EventRegistry
+type EventRegistryOption func(*EventRegistry)
+
+// WithSerializer sets the serializer used to decode event data, JSONSerializer is the default
+func WithSerializer(s Serializer) EventRegistryOption {
+	return func(r *EventRegistry) {
+		r.serializer = s
+	}
+}
+
+// WithTypeRegistry names handlers after the event types registered in the TypeRegistry and decodes
+// with its serializer
+func WithTypeRegistry(tr *TypeRegistry) EventRegistryOption {
+	return func(r *EventRegistry) {
+		r.types = tr
+		r.serializer = tr.Serializer()
+	}
+}
+
+// NewEventRegistry creates a registry applying events to agg
+func NewEventRegistry(agg Aggregate, options ...EventRegistryOption) *EventRegistry {
+	r := &EventRegistry{
+		agg:        agg,
+		serializer: JSONSerializer{},
+		handlers:   make(map[string]func(gosignal.Event) error),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// On registers the handler for payloads of type T under the event type named by T, see EventTyper.
+// With WithTypeRegistry the name registered for T is used, falling back to the name of T.
+func On[T any](r *EventRegistry, fn func(T) error) {
+	name := EventTypeOf[T]()
+	if r.types != nil {
+		if registered, err := r.types.nameOfType(reflect.TypeOf((*T)(nil)).Elem()); err == nil {
+			name = registered
+		}
+	}
+
+	OnType(r, name, fn)
+}
+
+// OnType registers the handler for payloads of type T under an explicit event type
+func OnType[T any](r *EventRegistry, eventType string, fn func(T) error) {
+	r.handlers[eventType] = func(event gosignal.Event) error {
+		var payload T
+		if len(event.Data) > 0 {
+			if err := r.serializer.Unmarshal(event.Data, &payload); err != nil {
+				return errors.Join(ErrDecodingEvent, err)
+			}
+		}
+		return fn(payload)
+	}
+}
+
+// EventTypeOf returns the event type name of payloads of type T
+func EventTypeOf[T any]() string {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// a pointer to a fresh value has the methods of both receiver kinds and is never nil
+	if typer, ok := reflect.New(t).Interface().(EventTyper); ok {
+		return typer.EventType()
+	}
+	return t.Name()
+}
+
+// Handles reports whether a handler is registered for the event type
+func (r *EventRegistry) Handles(eventType string) bool {
+	_, ok := r.handlers[eventType]
+	return ok
+}
+
+// Apply decodes the event and passes it to its handler through SafeApply, so the aggregate version
+// is checked and advanced. Events without a handler fail with ErrUnknownEventType.
+func (r *EventRegistry) Apply(event gosignal.Event) error {
+	handler, ok := r.handlers[event.Type]
+	if !ok {
+		return errors.Join(ErrUnknownEventType, fmt.Errorf("event type: %q", event.Type))
+	}
+
+	return SafeApply(event, r.agg, handler)
+}
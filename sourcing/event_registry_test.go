@@ -0,0 +1,111 @@
+package sourcing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Howard3/gosignal"
+)
+
+type orderPlaced struct {
+	Total int `json:"total"`
+}
+
+type orderShipped struct {
+	Carrier string `json:"carrier"`
+}
+
+func (orderShipped) EventType() string { return "order.shipped" }
+
+type order struct {
+	DefaultAggregate
+	events  *EventRegistry
+	total   int
+	carrier string
+}
+
+func newOrder() *order {
+	o := &order{}
+	o.events = NewEventRegistry(o)
+	On(o.events, o.placed)
+	On(o.events, o.shipped)
+	return o
+}
+
+func (o *order) placed(e orderPlaced) error {
+	if e.Total <= 0 {
+		return errors.New("total must be positive")
+	}
+	o.total = e.Total
+	return nil
+}
+
+func (o *order) shipped(e orderShipped) error {
+	o.carrier = e.Carrier
+	return nil
+}
+
+func (o *order) Apply(e gosignal.Event) error { return o.events.Apply(e) }
+func (o *order) ImportState([]byte) error     { return nil }
+func (o *order) ExportState() ([]byte, error) { return nil, nil }
+
+func TestEventRegistryRoutesByType(t *testing.T) {
+	o := newOrder()
+
+	events := []gosignal.Event{
+		{Type: "orderPlaced", Version: 0, Data: []byte(`{"total":42}`)},
+		{Type: "order.shipped", Version: 1, Data: []byte(`{"carrier":"post"}`)},
+	}
+	for _, event := range events {
+		if err := o.Apply(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if o.total != 42 || o.carrier != "post" || o.GetVersion() != 2 {
+		t.Fatalf("unexpected state: %+v", o)
+	}
+}
+
+func TestEventRegistryErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		event gosignal.Event
+		want  error
+	}{
+		{"unknown type", gosignal.Event{Type: "order.cancelled"}, ErrUnknownEventType},
+		{"undecodable data", gosignal.Event{Type: "orderPlaced", Data: []byte("{")}, ErrDecodingEvent},
+		{"handler error", gosignal.Event{Type: "orderPlaced", Data: []byte(`{"total":0}`)}, ErrApplyFailed},
+		{"wrong version", gosignal.Event{Type: "orderPlaced", Version: 3}, ErrEventVersionNE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOrder()
+			if err := o.Apply(tt.event); !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if o.GetVersion() != 0 {
+				t.Fatal("version must not advance on error")
+			}
+		})
+	}
+}
+
+func TestEventTypeOf(t *testing.T) {
+	if got := EventTypeOf[orderPlaced](); got != "orderPlaced" {
+		t.Fatalf("expected Go type name, got %q", got)
+	}
+	if got := EventTypeOf[orderShipped](); got != "order.shipped" {
+		t.Fatalf("expected EventTyper name, got %q", got)
+	}
+	if got := EventTypeOf[*orderPlaced](); got != "orderPlaced" {
+		t.Fatalf("expected pointer to resolve to the element name, got %q", got)
+	}
+}
+
+func TestEventTypeOfPointerToTyper(t *testing.T) {
+	if got := EventTypeOf[*orderShipped](); got != "order.shipped" {
+		t.Fatalf("expected EventTyper name, got %q", got)
+	}
+}
@@ -10,7 +10,8 @@ import (
 // EventStore is the interface that wraps the basic event store operations
 // it reperents some form of storage for your event sourcing solution.
 type EventStore interface {
-	// Store stores a list of events for a given aggregate id
+	// Store stores a list of events for a given aggregate id, events continuing a stream that holds
+	// a tombstone are rejected with ErrAggregateDeleted
 	Store(ctx context.Context, events []gosignal.Event) error
 	// Load loads all events for a given aggregate id
 	Load(ctx context.Context, aggID string, options LoadEventsOptions) ([]gosignal.Event, error)
@@ -21,9 +22,32 @@ type EventStore interface {
 
 // LoadEventsOptions represents the options that can be passed to the Load method
 type LoadEventsOptions struct {
-	MinVersion *uint64    // the minimum version of the aggregate to load
-	MaxVersion *uint64    // the maximum version of the aggregate to load
-	EventTypes []string   // the types of events to load, if empty all events are loaded
-	FromTime   *time.Time // the time from which to load events
-	ToTime     *time.Time // the time to which to load events
+	MinVersion    *uint64    // the minimum version of the aggregate to load
+	MaxVersion    *uint64    // the maximum version of the aggregate to load
+	EventTypes    []string   // the types of events to load, if empty all events are loaded
+	FromTime      *time.Time // the time from which to load events
+	ToTime        *time.Time // the time to which to load events
+	AggregateType string     // the aggregate type (stream category) to load, if empty it is not filtered
+}
+
+// StreamAppend is the events appended to one aggregate's stream by a TransactionalEventStore
+type StreamAppend struct {
+	AggregateID string
+	// AggregateType is the stream category, when empty the stream is every event of the aggregate
+	// id, as with LoadEventsOptions.AggregateType
+	AggregateType string
+	// ExpectedVersion is the version the stream must be at, one past its last event or zero for a
+	// new stream
+	ExpectedVersion uint64
+	Events          []gosignal.Event
+}
+
+// TransactionalEventStore is implemented by event stores that can append to several streams in one
+// transaction, checking the version of every stream first
+type TransactionalEventStore interface {
+	EventStore
+	// Append stores the events of all streams atomically. When a stream is not at its expected
+	// version nothing is stored and the error wraps ErrVersionConflict, or ErrAggregateDeleted when
+	// it holds a tombstone.
+	Append(ctx context.Context, streams []StreamAppend) error
 }
@@ -0,0 +1,169 @@
+package sourcing
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/Howard3/gosignal"
+)
+
+// testEventStore is a minimal in-memory TransactionalEventStore used by the package tests
+type testEventStore struct {
+	mu     sync.Mutex
+	events map[string][]gosignal.Event
+}
+
+func newTestEventStore() *testEventStore {
+	return &testEventStore{events: make(map[string][]gosignal.Event)}
+}
+
+func (s *testEventStore) Store(_ context.Context, events []gosignal.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deleted(events) {
+		return ErrAggregateDeleted
+	}
+	for _, event := range events {
+		s.events[event.AggregateID] = append(s.events[event.AggregateID], event)
+	}
+	return nil
+}
+
+func (s *testEventStore) Append(_ context.Context, streams []StreamAppend) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stream := range streams {
+		var version uint64
+		for _, event := range s.events[stream.AggregateID] {
+			if stream.AggregateType == "" || event.AggregateType == stream.AggregateType {
+				version = max(version, event.Version+1)
+			}
+		}
+		if version != stream.ExpectedVersion {
+			return ErrVersionConflict
+		}
+		if s.deleted(stream.Events) {
+			return ErrAggregateDeleted
+		}
+	}
+	for _, stream := range streams {
+		s.events[stream.AggregateID] = append(s.events[stream.AggregateID], stream.Events...)
+	}
+	return nil
+}
+
+// deleted reports whether any of the events continue a stream holding a tombstone, stored or
+// earlier in the events
+func (s *testEventStore) deleted(events []gosignal.Event) bool {
+	tombstoned := func(e gosignal.Event, other gosignal.Event) bool {
+		return other.Type == TombstoneEventType && other.AggregateID == e.AggregateID && other.AggregateType == e.AggregateType
+	}
+	for i, event := range events {
+		if slices.ContainsFunc(s.events[event.AggregateID], func(other gosignal.Event) bool { return tombstoned(event, other) }) ||
+			slices.ContainsFunc(events[:i], func(other gosignal.Event) bool { return tombstoned(event, other) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *testEventStore) Load(_ context.Context, aggID string, options LoadEventsOptions) ([]gosignal.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []gosignal.Event
+	for _, event := range s.events[aggID] {
+		switch {
+		case options.MinVersion != nil && event.Version < *options.MinVersion,
+			options.MaxVersion != nil && event.Version > *options.MaxVersion,
+			options.AggregateType != "" && event.AggregateType != options.AggregateType,
+			len(options.EventTypes) > 0 && !slices.Contains(options.EventTypes, event.Type),
+			options.FromTime != nil && event.Timestamp.Before(*options.FromTime),
+			options.ToTime != nil && event.Timestamp.After(*options.ToTime):
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *testEventStore) Replace(_ context.Context, id string, version uint64, event gosignal.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.events[id] {
+		if existing.Version == version {
+			s.events[id][i] = event
+			return nil
+		}
+	}
+	return ErrVersionNotFound
+}
+
+func (s *testEventStore) AggregateIDs(context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.events))
+	for id := range s.events {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *testEventStore) AggregateIDsOfType(_ context.Context, aggregateType string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, events := range s.events {
+		if slices.ContainsFunc(events, func(event gosignal.Event) bool { return event.AggregateType == aggregateType }) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *testEventStore) Delete(_ context.Context, aggID string, aggregateType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []gosignal.Event
+	for _, event := range s.events[aggID] {
+		if aggregateType != "" && event.AggregateType != aggregateType {
+			kept = append(kept, event)
+		}
+	}
+	if len(kept) == 0 {
+		delete(s.events, aggID)
+	} else {
+		s.events[aggID] = kept
+	}
+	return nil
+}
+
+// testQueue is a gosignal.Queue recording sent messages
+type testQueue struct {
+	mu      sync.Mutex
+	sent    []string
+	sendErr func(messageType string) error
+}
+
+func (q *testQueue) Send(messageType string, message []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.sendErr != nil {
+		if err := q.sendErr(messageType); err != nil {
+			return err
+		}
+	}
+	q.sent = append(q.sent, messageType)
+	return nil
+}
+
+func (q *testQueue) Subscribe(string) (string, chan gosignal.QueueMessage, error) {
+	return "", nil, nil
+}
+
+func (q *testQueue) Unsubscribe(string, string) error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package sourcing
+
+import (
+	"context"
+
+	"github.com/Howard3/gosignal"
+)
+
+// RepositoryHooks are callbacks around the repository's operations, for validation, auditing,
+// access checks and metrics. Any of them may be nil.
+type RepositoryHooks struct {
+	// BeforeStore runs before events are persisted, once they carry the repository's aggregate
+	// type. An error rejects all of the events.
+	BeforeStore func(ctx context.Context, events []gosignal.Event) error
+	// AfterStore runs when Store is done, with the error Store would return. The error it returns
+	// is returned from Store instead, so it should return err unless it changes the outcome.
+	AfterStore func(ctx context.Context, events []gosignal.Event, err error) error
+	// BeforeApply runs before each event is applied to an aggregate, an error stops the replay
+	BeforeApply func(agg Aggregate, event gosignal.Event) error
+	// AfterLoad runs when Load or LoadAsOf is done, with the error it would return, such as
+	// ErrNoEvents for new aggregates. The error it returns is returned instead.
+	AfterLoad func(ctx context.Context, agg Aggregate, err error) error
+	// OnSnapshot runs before a snapshot is stored, an error skips storing it
+	OnSnapshot func(ctx context.Context, snapshot Snapshot) error
+}
+
+// WithHooks adds hooks to the repository, hooks from several WithHooks run in the order given
+func WithHooks(hooks RepositoryHooks) func(*Repository) {
+	return func(r *Repository) {
+		r.hooks = append(r.hooks, hooks)
+	}
+}
+
+func (r *Repository) beforeStore(ctx context.Context, events []gosignal.Event) error {
+	for _, h := range r.hooks {
+		if h.BeforeStore != nil {
+			if err := h.BeforeStore(ctx, events); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Repository) afterStore(ctx context.Context, events []gosignal.Event, err error) error {
+	for _, h := range r.hooks {
+		if h.AfterStore != nil {
+			err = h.AfterStore(ctx, events, err)
+		}
+	}
+	return err
+}
+
+func (r *Repository) beforeApply(agg Aggregate, event gosignal.Event) error {
+	for _, h := range r.hooks {
+		if h.BeforeApply != nil {
+			if err := h.BeforeApply(agg, event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Repository) afterLoad(ctx context.Context, agg Aggregate, err error) error {
+	for _, h := range r.hooks {
+		if h.AfterLoad != nil {
+			err = h.AfterLoad(ctx, agg, err)
+		}
+	}
+	return err
+}
+
+func (r *Repository) onSnapshot(ctx context.Context, snapshot Snapshot) error {
+	for _, h := range r.hooks {
+		if h.OnSnapshot != nil {
+			if err := h.OnSnapshot(ctx, snapshot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
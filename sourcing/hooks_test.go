@@ -0,0 +1,115 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Howard3/gosignal"
+)
+
+func TestRepositoryHooks(t *testing.T) {
+	ctx := context.Background()
+	snapshots := newTestSnapshotStore()
+	errForbidden := errors.New("forbidden")
+
+	var calls []string
+	record := func(name string) RepositoryHooks {
+		return RepositoryHooks{
+			BeforeStore: func(_ context.Context, events []gosignal.Event) error {
+				calls = append(calls, fmt.Sprintf("%s before store %s", name, events[0].AggregateType))
+				return nil
+			},
+			AfterStore: func(_ context.Context, _ []gosignal.Event, err error) error {
+				calls = append(calls, fmt.Sprintf("%s after store %v", name, err == nil))
+				return err
+			},
+			AfterLoad: func(_ context.Context, agg Aggregate, err error) error {
+				calls = append(calls, fmt.Sprintf("%s after load %d", name, agg.GetVersion()))
+				return err
+			},
+			OnSnapshot: func(_ context.Context, snapshot Snapshot) error {
+				calls = append(calls, fmt.Sprintf("%s snapshot %d", name, snapshot.Version))
+				return nil
+			},
+		}
+	}
+
+	repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(&testQueue{}),
+		WithSnapshotStrategy(alwaysSnapshot{snapshots}), WithAggregateType("counter"),
+		WithHooks(record("first")), WithHooks(record("second")))
+
+	if err := repo.Store(ctx, incremented("c1", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	c := &counter{}
+	c.SetID("c1")
+	if err := repo.Load(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"first before store counter", "second before store counter",
+		"first after store true", "second after store true",
+		"first snapshot 2", "second snapshot 2",
+		"first after load 2", "second after load 2",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Fatalf("unexpected hook calls:\n got: %v\nwant: %v", calls, want)
+	}
+
+	t.Run("rejecting", func(t *testing.T) {
+		store := newTestEventStore()
+		repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithHooks(RepositoryHooks{
+			BeforeStore: func(_ context.Context, events []gosignal.Event) error {
+				if events[0].AggregateID == "other-tenant" {
+					return errForbidden
+				}
+				return nil
+			},
+			BeforeApply: func(_ Aggregate, event gosignal.Event) error {
+				if event.Version > 0 {
+					return errForbidden
+				}
+				return nil
+			},
+		}))
+
+		if err := repo.Store(ctx, incremented("other-tenant", 0, 0)); !errors.Is(err, errForbidden) {
+			t.Fatalf("expected BeforeStore to reject, got %v", err)
+		}
+		if events, _ := store.Load(ctx, "other-tenant", LoadEventsOptions{}); len(events) != 0 {
+			t.Fatal("expected rejected events not to be stored")
+		}
+
+		if err := repo.Store(ctx, incremented("c1", 0, 1)); err != nil {
+			t.Fatal(err)
+		}
+		c := &counter{}
+		c.SetID("c1")
+		if err := repo.Load(ctx, c, nil); !errors.Is(err, errForbidden) || c.Count != 1 {
+			t.Fatalf("expected BeforeApply to stop the replay after one event, got count %d: %v", c.Count, err)
+		}
+	})
+
+	t.Run("skipping snapshots", func(t *testing.T) {
+		snapshots := newTestSnapshotStore()
+		repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(&testQueue{}),
+			WithSnapshotStrategy(alwaysSnapshot{snapshots}), WithHooks(RepositoryHooks{
+				OnSnapshot: func(context.Context, Snapshot) error { return errForbidden },
+			}))
+
+		if err := repo.Store(ctx, incremented("c1", 0, 0)); err != nil {
+			t.Fatal(err)
+		}
+		c := &counter{}
+		c.SetID("c1")
+		if err := repo.Load(ctx, c, nil); !errors.Is(err, errForbidden) || !errors.Is(err, ErrSnapshotFailed) {
+			t.Fatalf("expected the OnSnapshot error, got %v", err)
+		}
+		if snapshots.stored != 0 {
+			t.Fatal("expected the snapshot not to be stored")
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package sourcing
+
+import (
+	"errors"
+
+	"github.com/Howard3/gosignal"
+)
+
+// Metric and span names reported by the Repository. Operations are reported as spans named after
+// them, with a "<name>.duration" histogram and a "<name>.errors" counter, see
+// gosignal.StartOperation. All of them carry the aggregate_type attribute.
+const (
+	MetricLoad            = "gosignal.repository.load"
+	MetricLoadAsOf        = "gosignal.repository.load_as_of"
+	MetricStore           = "gosignal.repository.store"
+	MetricEventsApplied   = "gosignal.repository.load.events_applied" // histogram of events replayed per load
+	MetricEventsStored    = "gosignal.repository.events_stored"
+	MetricSnapshotHits    = "gosignal.repository.snapshot.hits"
+	MetricSnapshotMisses  = "gosignal.repository.snapshot.misses"
+	MetricSnapshotsStored = "gosignal.repository.snapshot.stored"
+	MetricCacheHits       = "gosignal.repository.cache.hits"
+	MetricCacheMisses     = "gosignal.repository.cache.misses"
+	MetricPublishFailures = "gosignal.repository.publish.failures" // events left unpublished by Store
+)
+
+// WithInstrumentation reports the repository's metrics and spans to instr
+func WithInstrumentation(instr gosignal.Instrumentation) func(*Repository) {
+	return func(r *Repository) {
+		r.instrumentation = instr
+	}
+}
+
+func (r *Repository) instr() gosignal.Instrumentation {
+	if r.instrumentation == nil {
+		return gosignal.NopInstrumentation{}
+	}
+	return r.instrumentation
+}
+
+func (r *Repository) metricAttrs() []gosignal.Attr {
+	return []gosignal.Attr{{Key: "aggregate_type", Value: r.aggregateType}}
+}
+
+// loadFailure is the error reported for a load, loading an aggregate without events is expected
+// when it is created and not counted as a failure
+func loadFailure(err error) error {
+	if errors.Is(err, ErrNoEvents) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Howard3/gosignal"
+)
+
+func TestRepositoryInstrumentation(t *testing.T) {
+	ctx := context.Background()
+	instr := &gosignal.MemoryInstrumentation{}
+	snapshots := newTestSnapshotStore()
+
+	repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(&testQueue{}),
+		WithSnapshotStrategy(alwaysSnapshot{snapshots}), WithAggregateType("counter"),
+		WithInstrumentation(instr))
+
+	if err := repo.Store(ctx, incremented("c1", 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		c := &counter{}
+		c.SetID("c1")
+		if err := repo.Load(ctx, c, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	missing := &counter{}
+	missing.SetID("missing")
+	if err := repo.Load(ctx, missing, nil); !errors.Is(err, ErrNoEvents) {
+		t.Fatalf("expected ErrNoEvents, got %v", err)
+	}
+
+	counters := map[string]int64{
+		MetricEventsStored:      3,
+		MetricSnapshotMisses:    2,
+		MetricSnapshotHits:      1,
+		MetricSnapshotsStored:   1,
+		MetricLoad + ".errors":  0,
+		MetricStore + ".errors": 0,
+		MetricPublishFailures:   0,
+	}
+	for name, want := range counters {
+		if got := instr.Counter(name); got != want {
+			t.Errorf("counter %s: got %d, want %d", name, got, want)
+		}
+	}
+
+	if got := fmt.Sprint(instr.Observations(MetricEventsApplied)); got != "[3 0]" {
+		t.Errorf("unexpected events applied: %s", got)
+	}
+	if got := len(instr.Observations(MetricLoad + ".duration")); got != 3 {
+		t.Errorf("expected 3 load durations, got %d", got)
+	}
+
+	for _, span := range instr.Spans() {
+		if !span.Ended || span.Parent != "" {
+			t.Errorf("unexpected span %+v", span)
+		}
+		if len(span.Attrs) != 1 || span.Attrs[0] != (gosignal.Attr{Key: "aggregate_type", Value: "counter"}) {
+			t.Errorf("unexpected attributes on %s: %v", span.Name, span.Attrs)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package sourcing
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// The interchange format used by Export and Import is newline delimited JSON (NDJSON), one event
+// per line, optionally gzip compressed as a whole. Each line is an object with the fields:
+//
+//	{"aggregate_id":"42","aggregate_type":"order","type":"placed","version":0,"timestamp":"2024-01-02T15:04:05.123456789Z","data":"eyJ0b3RhbCI6MTB9"}
+//
+// - aggregate_type is omitted when empty
+// - schema_version is omitted when 0
+// - timestamp is RFC3339 with nanoseconds and keeps the original offset
+// - data is the base64 (standard encoding) representation of gosignal.Event.Data
+//
+// Events of a stream, an aggregate type and id, appear in version order starting from version 0,
+// events of different streams may be interleaved.
+
+// ErrCannotListAggregates is the error returned when exporting without aggregate ids from a store
+// that does not implement AggregateLister
+var ErrCannotListAggregates = errors.New("event store cannot list aggregates")
+
+// ErrVersionGap is the error returned when an imported aggregate's versions are not contiguous
+var ErrVersionGap = errors.New("version gap in imported events")
+
+// ErrMalformedInterchange is the error returned when an import line can't be decoded
+// it is joined with the underlying error
+var ErrMalformedInterchange = errors.New("malformed interchange line")
+
+// AggregateLister is implemented by event stores that can enumerate the aggregates they hold
+type AggregateLister interface {
+	// AggregateIDs returns the ids of all aggregates with at least one event
+	AggregateIDs(ctx context.Context) ([]string, error)
+}
+
+// TypedAggregateLister is implemented by event stores that can enumerate the aggregates of one type
+type TypedAggregateLister interface {
+	// AggregateIDsOfType returns the ids of all aggregates with at least one event of the type
+	AggregateIDsOfType(ctx context.Context, aggregateType string) ([]string, error)
+}
+
+// interchangeEvent is the on-the-wire representation of a gosignal.Event
+type interchangeEvent struct {
+	AggregateID   string    `json:"aggregate_id"`
+	AggregateType string    `json:"aggregate_type,omitempty"`
+	SchemaVersion uint64    `json:"schema_version,omitempty"`
+	Type          string    `json:"type"`
+	Version       uint64    `json:"version"`
+	Timestamp     time.Time `json:"timestamp"`
+	Data          []byte    `json:"data"`
+}
+
+// InterchangeOption configures Export and Import
+type InterchangeOption func(*interchangeOptions)
+
+type interchangeOptions struct {
+	aggregateIDs  []string
+	aggregateType string
+	compress      bool
+	batchSize     int
+}
+
+// WithAggregateIDs limits an export to the given aggregates, in the given order
+func WithAggregateIDs(ids ...string) InterchangeOption {
+	return func(o *interchangeOptions) {
+		o.aggregateIDs = ids
+	}
+}
+
+// WithInterchangeAggregateType limits an export to events of the given aggregate type
+func WithInterchangeAggregateType(aggregateType string) InterchangeOption {
+	return func(o *interchangeOptions) {
+		o.aggregateType = aggregateType
+	}
+}
+
+// WithGzip compresses the export with gzip, Import detects compression on its own
+func WithGzip() InterchangeOption {
+	return func(o *interchangeOptions) {
+		o.compress = true
+	}
+}
+
+// WithImportBatchSize sets how many events Import hands to EventStore.Store at once
+func WithImportBatchSize(n int) InterchangeOption {
+	return func(o *interchangeOptions) {
+		o.batchSize = n
+	}
+}
+
+func buildInterchangeOptions(options []InterchangeOption) interchangeOptions {
+	o := interchangeOptions{batchSize: 500}
+	for _, option := range options {
+		option(&o)
+	}
+	if o.batchSize < 1 {
+		o.batchSize = 1
+	}
+	return o
+}
+
+// Export writes the events of the store to w in the interchange format, each aggregate's events
+// sorted by version. Without WithAggregateIDs the store must implement AggregateLister, or
+// TypedAggregateLister when exporting one aggregate type.
+func Export(ctx context.Context, store EventStore, w io.Writer, options ...InterchangeOption) (err error) {
+	o := buildInterchangeOptions(options)
+
+	ids := o.aggregateIDs
+	if ids == nil {
+		if ids, err = listAggregates(ctx, store, o.aggregateType); err != nil {
+			return err
+		}
+	}
+
+	if o.compress {
+		gz := gzip.NewWriter(w)
+		defer func() {
+			err = errors.Join(err, gz.Close())
+		}()
+		w = gz
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	for _, id := range ids {
+		events, err := store.Load(ctx, id, LoadEventsOptions{AggregateType: o.aggregateType})
+		if err != nil {
+			return errors.Join(ErrLoadingEvents, err)
+		}
+
+		// not every store returns events in version order
+		slices.SortStableFunc(events, func(a, b gosignal.Event) int {
+			return cmp.Compare(a.Version, b.Version)
+		})
+
+		for _, event := range events {
+			if err := enc.Encode(interchangeEvent{
+				AggregateID:   event.AggregateID,
+				AggregateType: event.AggregateType,
+				SchemaVersion: event.SchemaVersion,
+				Type:          event.Type,
+				Version:       event.Version,
+				Timestamp:     event.Timestamp,
+				Data:          event.Data,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// listAggregates returns the ids of the store's aggregates, limited to the aggregate type when the
+// store can list by type
+func listAggregates(ctx context.Context, store EventStore, aggregateType string) ([]string, error) {
+	if typed, ok := store.(TypedAggregateLister); ok && aggregateType != "" {
+		return typed.AggregateIDsOfType(ctx, aggregateType)
+	}
+
+	lister, ok := store.(AggregateLister)
+	if !ok {
+		return nil, ErrCannotListAggregates
+	}
+	return lister.AggregateIDs(ctx)
+}
+
+// Import reads events in the interchange format from r and stores them in the store, batching
+// calls to EventStore.Store. Versions of every stream, an aggregate type and id, must start at 0 and
+// be contiguous, otherwise ErrVersionGap is returned. The whole input is decoded and validated, and so held in
+// memory, before anything is stored, so an invalid input stores nothing. Storing is not atomic,
+// batches stored before a store error remain in the store.
+func Import(ctx context.Context, r io.Reader, store EventStore, options ...InterchangeOption) error {
+	o := buildInterchangeOptions(options)
+
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	events, err := decodeInterchange(br)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(events); start += o.batchSize {
+		if err := store.Store(ctx, events[start:min(start+o.batchSize, len(events))]); err != nil {
+			return errors.Join(ErrStoringEvents, err)
+		}
+	}
+
+	return nil
+}
+
+// decodeInterchange reads and validates every event of the input
+func decodeInterchange(r io.Reader) ([]gosignal.Event, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	next := make(map[streamKey]uint64)
+	var events []gosignal.Event
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var ie interchangeEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ie); err != nil {
+			return nil, errors.Join(ErrMalformedInterchange, fmt.Errorf("line %d", line), err)
+		}
+
+		key := streamKey{aggregateType: ie.AggregateType, aggregateID: ie.AggregateID}
+		if expected := next[key]; ie.Version != expected {
+			return nil, errors.Join(ErrVersionGap, fmt.Errorf("line %d: aggregate %s expected version %d, got %d",
+				line, ie.AggregateID, expected, ie.Version))
+		}
+		next[key] = ie.Version + 1
+
+		events = append(events, gosignal.Event{
+			Type:          ie.Type,
+			Data:          ie.Data,
+			Version:       ie.Version,
+			Timestamp:     ie.Timestamp,
+			AggregateID:   ie.AggregateID,
+			AggregateType: ie.AggregateType,
+			SchemaVersion: ie.SchemaVersion,
+		})
+	}
+
+	return events, scanner.Err()
+}
@@ -0,0 +1,203 @@
+package sourcing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+func seedInterchangeStore(t *testing.T) *testEventStore {
+	t.Helper()
+	store := newTestEventStore()
+	zone := time.FixedZone("UTC-5", -5*60*60)
+	for _, id := range []string{"b", "a"} {
+		for v := uint64(0); v < 3; v++ {
+			err := store.Store(context.Background(), []gosignal.Event{{
+				Type:          "incremented",
+				Data:          []byte{0, byte(v), 0xff},
+				Version:       v,
+				Timestamp:     time.Date(2024, 1, 2, 3, 4, 5, int(v)+123456789, zone),
+				AggregateID:   id,
+				AggregateType: "counter",
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return store
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		name := "plain"
+		var options []InterchangeOption
+		if compressed {
+			name = "gzip"
+			options = append(options, WithGzip())
+		}
+
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			src := seedInterchangeStore(t)
+
+			var buf bytes.Buffer
+			if err := Export(ctx, src, &buf, options...); err != nil {
+				t.Fatal(err)
+			}
+
+			dst := newTestEventStore()
+			if err := Import(ctx, &buf, dst, WithImportBatchSize(2)); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range []string{"a", "b"} {
+				want, _ := src.Load(ctx, id, LoadEventsOptions{})
+				got, _ := dst.Load(ctx, id, LoadEventsOptions{})
+				if len(got) != len(want) {
+					t.Fatalf("aggregate %s: expected %d events, got %d", id, len(want), len(got))
+				}
+				for i := range want {
+					if got[i].Version != want[i].Version || got[i].Type != want[i].Type ||
+						got[i].AggregateType != want[i].AggregateType || !bytes.Equal(got[i].Data, want[i].Data) {
+						t.Fatalf("aggregate %s: event %d differs: %+v != %+v", id, i, got[i], want[i])
+					}
+					if got[i].Timestamp.Format(time.RFC3339Nano) != want[i].Timestamp.Format(time.RFC3339Nano) {
+						t.Fatalf("timestamp not preserved: %s != %s", got[i].Timestamp, want[i].Timestamp)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExportOrdersByAggregateAndVersion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(context.Background(), seedInterchangeStore(t), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 lines, got %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], `{"aggregate_id":"a","aggregate_type":"counter","type":"incremented","version":0,`) {
+		t.Fatalf("unexpected first line: %s", lines[0])
+	}
+	if !strings.Contains(lines[5], `"aggregate_id":"b"`) || !strings.Contains(lines[5], `"version":2`) {
+		t.Fatalf("unexpected last line: %s", lines[5])
+	}
+}
+
+func TestImportRejectsVersionGaps(t *testing.T) {
+	input := strings.Join([]string{
+		`{"aggregate_id":"a","type":"x","version":0,"timestamp":"2024-01-01T00:00:00Z","data":null}`,
+		`{"aggregate_id":"b","type":"x","version":0,"timestamp":"2024-01-01T00:00:00Z","data":null}`,
+		`{"aggregate_id":"a","type":"x","version":2,"timestamp":"2024-01-01T00:00:00Z","data":null}`,
+	}, "\n")
+
+	store := newTestEventStore()
+	err := Import(context.Background(), strings.NewReader(input), store, WithImportBatchSize(1))
+	if !errors.Is(err, ErrVersionGap) {
+		t.Fatalf("expected ErrVersionGap, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expected error to reference line 3, got %v", err)
+	}
+	if ids, _ := store.AggregateIDs(context.Background()); len(ids) != 0 {
+		t.Fatalf("expected nothing to be imported from an invalid input, got %v", ids)
+	}
+}
+
+// reversedStore returns events newest first
+type reversedStore struct{ *testEventStore }
+
+func (s reversedStore) Load(ctx context.Context, aggID string, options LoadEventsOptions) ([]gosignal.Event, error) {
+	events, err := s.testEventStore.Load(ctx, aggID, options)
+	slices.Reverse(events)
+	return events, err
+}
+
+func TestExportSortsByVersion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(context.Background(), reversedStore{seedInterchangeStore(t)}, &buf, WithAggregateIDs("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := newTestEventStore()
+	if err := Import(context.Background(), &buf, imported); err != nil {
+		t.Fatalf("expected the sorted export to import, got %v", err)
+	}
+}
+
+func TestImportRejectsMalformedLines(t *testing.T) {
+	err := Import(context.Background(), strings.NewReader("{not json}\n"), newTestEventStore())
+	if !errors.Is(err, ErrMalformedInterchange) {
+		t.Fatalf("expected ErrMalformedInterchange, got %v", err)
+	}
+}
+
+type unlistableStore struct{ EventStore }
+
+func TestExportRequiresLister(t *testing.T) {
+	var buf bytes.Buffer
+	err := Export(context.Background(), unlistableStore{newTestEventStore()}, &buf)
+	if !errors.Is(err, ErrCannotListAggregates) {
+		t.Fatalf("expected ErrCannotListAggregates, got %v", err)
+	}
+}
+
+func TestInterchangeAggregateTypesShareIDs(t *testing.T) {
+	ctx := context.Background()
+	src := newTestEventStore()
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, event := range []gosignal.Event{
+		{Type: "placed", Version: 0, AggregateID: "1", AggregateType: "order"},
+		{Type: "registered", Version: 0, AggregateID: "1", AggregateType: "customer"},
+		{Type: "shipped", Version: 1, AggregateID: "1", AggregateType: "order"},
+		{Type: "registered", Version: 0, AggregateID: "2", AggregateType: "customer"},
+	} {
+		event.Timestamp = ts
+		if err := src.Store(ctx, []gosignal.Event{event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Export(ctx, src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := newTestEventStore()
+	if err := Import(ctx, &buf, dst); err != nil {
+		t.Fatalf("expected streams of different types to be versioned apart, got %v", err)
+	}
+	if events, _ := dst.Load(ctx, "1", LoadEventsOptions{}); len(events) != 3 {
+		t.Fatalf("expected 3 events for id 1, got %d", len(events))
+	}
+
+	// a store that can only list by type
+	typed := struct {
+		EventStore
+		TypedAggregateLister
+	}{src, src}
+
+	buf.Reset()
+	if err := Export(ctx, typed, &buf, WithInterchangeAggregateType("customer")); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"aggregate_id":"1","aggregate_type":"customer"`) ||
+		!strings.Contains(lines[1], `"aggregate_id":"2","aggregate_type":"customer"`) {
+		t.Fatalf("expected the two customers, got %q", lines)
+	}
+
+	if err := Export(ctx, typed, &buf); !errors.Is(err, ErrCannotListAggregates) {
+		t.Fatalf("expected ErrCannotListAggregates without a type, got %v", err)
+	}
+}
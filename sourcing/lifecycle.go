@@ -0,0 +1,170 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// TombstoneEventType is the type of the event stored by Repository.SoftDelete
+const TombstoneEventType = "gosignal.tombstone"
+
+// ArchiveMarkerEventType is the type of the event Repository.Archive leaves in the event store at
+// the last archived version, so the aggregate's stream continues from it. It is never returned by
+// LoadEvents.
+const ArchiveMarkerEventType = "gosignal.archived"
+
+// ErrAggregateDeleted is the error returned when loading or storing events for an aggregate that
+// was soft-deleted
+var ErrAggregateDeleted = errors.New("aggregate deleted")
+
+// ErrDeleteNotSupported is the error returned when hard-deleting or archiving with an event store
+// that does not implement EventDeleter
+var ErrDeleteNotSupported = errors.New("event store does not support deletion")
+
+// ErrNoSnapshotStore is the error returned when archiving without a snapshot store to keep the
+// aggregate's state
+var ErrNoSnapshotStore = errors.New("no snapshot store defined")
+
+// ErrArchiveFailed is the error returned when archiving an aggregate fails
+// it is joined with the underlying error
+var ErrArchiveFailed = errors.New("archive failed")
+
+// EventDeleter is implemented by event stores that can permanently delete an aggregate's events
+type EventDeleter interface {
+	// Delete deletes all events of the aggregate, limited to the aggregate type if it is not empty
+	Delete(ctx context.Context, aggID string, aggregateType string) error
+}
+
+// SoftDelete closes the aggregate's stream by storing a tombstone event at the aggregate's current
+// version. Afterwards Load returns ErrAggregateDeleted, while its history remains available through
+// LoadEvents. The event store rejects new events for the aggregate with ErrAggregateDeleted.
+func (r *Repository) SoftDelete(ctx context.Context, agg Aggregate) error {
+	return r.Store(ctx, []gosignal.Event{{
+		Type:        TombstoneEventType,
+		Version:     agg.GetVersion(),
+		Timestamp:   time.Now(),
+		AggregateID: agg.GetID(),
+	}})
+}
+
+// HardDelete permanently deletes the aggregate's events and snapshot, for retention policies.
+// The event store must implement EventDeleter.
+func (r *Repository) HardDelete(ctx context.Context, aggID string) error {
+	deleter, ok := r.eventStore.(EventDeleter)
+	if !ok {
+		return ErrDeleteNotSupported
+	}
+
+	r.invalidateCached(aggID)
+
+	if err := deleter.Delete(ctx, aggID, r.aggregateType); err != nil {
+		return err
+	}
+
+	if r.snapshotStrategy != nil && r.snapshotStrategy.GetStore() != nil {
+		return r.snapshotStrategy.GetStore().Delete(ctx, aggID)
+	}
+
+	return nil
+}
+
+// Archive moves the aggregate's events, as stored, to the cold event store and replaces them in
+// the repository's store with an ArchiveMarkerEventType event at the last archived version, keeping
+// a snapshot of its current state. The aggregate is replayed into agg first, which must be empty.
+// Afterwards it keeps loading from the snapshot and accepts new events continuing its version, but
+// loads that skip snapshots no longer find its history. The snapshot is used even once its revision
+// is stale, as there are no events to rebuild the aggregate from. Archiving it again moves the
+// events stored since. The aggregate must not be written to while it is being archived.
+//
+// The event store must implement EventDeleter and a snapshot store must be configured.
+func (r *Repository) Archive(ctx context.Context, agg Aggregate, cold EventStore) error {
+	deleter, ok := r.eventStore.(EventDeleter)
+	if !ok {
+		return ErrDeleteNotSupported
+	}
+	if r.snapshotStrategy == nil || r.snapshotStrategy.GetStore() == nil {
+		return ErrNoSnapshotStore
+	}
+
+	aggID := agg.GetID()
+
+	stored, err := r.eventStore.Load(ctx, aggID, LoadEventsOptions{AggregateType: r.aggregateType})
+	if err != nil {
+		return errors.Join(ErrArchiveFailed, ErrLoadingEvents, err)
+	}
+	if len(stored) == 0 {
+		return errors.Join(ErrArchiveFailed, ErrNoEvents)
+	}
+	if isTombstoned(stored) {
+		return errors.Join(ErrArchiveFailed, ErrAggregateDeleted)
+	}
+
+	// an aggregate archived before is rebuilt from its snapshot, the events up to the marker are
+	// already in the cold store
+	moved := stored
+	if isArchiveMarker(stored[0]) {
+		moved = stored[1:]
+		if err := r.Load(ctx, agg, nil); err != nil {
+			return errors.Join(ErrArchiveFailed, err)
+		}
+	} else if err := r.replay(agg, stored); err != nil {
+		return errors.Join(ErrArchiveFailed, err)
+	}
+
+	if err := r.generateSnapshot(ctx, aggID, agg); err != nil {
+		return errors.Join(ErrArchiveFailed, ErrSnapshotFailed, err)
+	}
+
+	if len(moved) > 0 {
+		if err := cold.Store(ctx, moved); err != nil {
+			return errors.Join(ErrArchiveFailed, ErrStoringEvents, err)
+		}
+	}
+
+	if err := deleter.Delete(ctx, aggID, r.aggregateType); err != nil {
+		return errors.Join(ErrArchiveFailed, err)
+	}
+
+	marker := gosignal.Event{
+		Type:          ArchiveMarkerEventType,
+		Data:          []byte{},
+		Version:       stored[len(stored)-1].Version,
+		Timestamp:     time.Now(),
+		AggregateID:   aggID,
+		AggregateType: r.aggregateType,
+	}
+	if err := r.eventStore.Store(ctx, []gosignal.Event{marker}); err != nil {
+		return errors.Join(ErrArchiveFailed, ErrStoringEvents, err)
+	}
+
+	return nil
+}
+
+// replay applies the stored events to the aggregate, upcasting them first
+func (r *Repository) replay(agg Aggregate, stored []gosignal.Event) error {
+	events := stored
+	if r.upcasters != nil {
+		var err error
+		if events, err = r.upcasters.UpcastAll(stored); err != nil {
+			return errors.Join(ErrLoadingEvents, err)
+		}
+	}
+
+	return r.ApplyEvents(agg, events)
+}
+
+// isArchiveMarker reports whether the event was left by Archive
+func isArchiveMarker(e gosignal.Event) bool {
+	return e.Type == ArchiveMarkerEventType
+}
+
+// isTombstoned reports whether the events contain a tombstone
+func isTombstoned(events []gosignal.Event) bool {
+	return slices.ContainsFunc(events, func(e gosignal.Event) bool {
+		return e.Type == TombstoneEventType
+	})
+}
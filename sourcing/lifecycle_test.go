@@ -0,0 +1,139 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRepositorySoftDelete(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, incremented("a", 0, 1))
+	queue := &testQueue{}
+	repo := NewRepository(WithEventStore(events), WithQueue(queue))
+
+	agg := &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}
+	if err := repo.Load(ctx, agg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SoftDelete(ctx, agg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Load(ctx, &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}, nil); !errors.Is(err, ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted on load, got %v", err)
+	}
+	if err := repo.Store(ctx, incremented("a", 3, 3)); !errors.Is(err, ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted on store, got %v", err)
+	}
+
+	history, err := repo.LoadEvents(ctx, "a", nil)
+	if err != nil || len(history) != 3 || history[2].Type != TombstoneEventType {
+		t.Fatalf("expected history to end with the tombstone, got %+v, %v", history, err)
+	}
+	if len(queue.sent) != 1 || queue.sent[0] != TombstoneEventType {
+		t.Fatalf("expected the tombstone to be published, got %v", queue.sent)
+	}
+	if err := repo.Save(ctx, 3, incremented("a", 3, 3)); !errors.Is(err, ErrAggregateDeleted) {
+		t.Fatalf("expected ErrAggregateDeleted on save, got %v", err)
+	}
+	if err := repo.SoftDelete(ctx, agg); !errors.Is(err, ErrAggregateDeleted) {
+		t.Fatalf("expected a second tombstone to be rejected, got %v", err)
+	}
+}
+
+func TestRepositoryHardDelete(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, incremented("a", 0, 1))
+	snapshots := newTestSnapshotStore()
+	_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 1, Data: []byte("1")})
+
+	repo := NewRepository(WithEventStore(events), WithSnapshotStrategy(neverSnapshot{snapshots}))
+	if err := repo.HardDelete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Load(ctx, &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}, nil); !errors.Is(err, ErrNoEvents) {
+		t.Fatalf("expected ErrNoEvents after hard delete, got %v", err)
+	}
+
+	unsupported := NewRepository(WithEventStore(unlistableStore{events}))
+	if err := unsupported.HardDelete(ctx, "a"); !errors.Is(err, ErrDeleteNotSupported) {
+		t.Fatalf("expected ErrDeleteNotSupported, got %v", err)
+	}
+}
+
+func TestRepositoryArchive(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, incremented("a", 0, 4))
+	snapshots := newTestSnapshotStore()
+	cold := newTestEventStore()
+
+	repo := NewRepository(WithEventStore(events), WithQueue(&testQueue{}), WithSnapshotStrategy(neverSnapshot{snapshots}))
+
+	if err := repo.Archive(ctx, &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}, cold); err != nil {
+		t.Fatal(err)
+	}
+
+	if archived, _ := cold.Load(ctx, "a", LoadEventsOptions{}); len(archived) != 5 {
+		t.Fatalf("expected 5 events in the cold store, got %d", len(archived))
+	}
+	if hot, _ := events.Load(ctx, "a", LoadEventsOptions{}); len(hot) != 1 || hot[0].Type != ArchiveMarkerEventType || hot[0].Version != 4 {
+		t.Fatalf("expected only the archive marker in the hot store, got %+v", hot)
+	}
+	if history, _ := repo.LoadEvents(ctx, "a", nil); len(history) != 0 {
+		t.Fatalf("expected the marker to be left out of the history, got %+v", history)
+	}
+
+	agg := &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}
+	if err := repo.Load(ctx, agg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if agg.Count != 5 || agg.GetVersion() != 5 {
+		t.Fatalf("expected archived aggregate to load from its snapshot, got %d at %d", agg.Count, agg.GetVersion())
+	}
+
+	// the archived aggregate continues from its last version
+	if err := repo.Save(ctx, 5, incremented("a", 5, 5)); err != nil {
+		t.Fatal(err)
+	}
+	agg = &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}
+	if err := repo.Load(ctx, agg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if agg.Count != 6 || agg.GetVersion() != 6 {
+		t.Fatalf("expected the event saved after archiving to be applied, got %d at %d", agg.Count, agg.GetVersion())
+	}
+
+	// archiving again moves the events saved since
+	if err := repo.Archive(ctx, &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}, cold); err != nil {
+		t.Fatal(err)
+	}
+	if archived, _ := cold.Load(ctx, "a", LoadEventsOptions{}); len(archived) != 6 || archived[5].Version != 5 {
+		t.Fatalf("expected 6 events in the cold store, got %+v", archived)
+	}
+	if hot, _ := events.Load(ctx, "a", LoadEventsOptions{}); len(hot) != 1 || hot[0].Version != 5 {
+		t.Fatalf("expected the marker to move to the last version, got %+v", hot)
+	}
+
+	// a new snapshot revision can't be rebuilt from the archived events
+	revised := &revisedCounter{counter{DefaultAggregate: DefaultAggregate{ID: "a"}}}
+	purging := NewRepository(WithEventStore(events), WithSnapshotStrategy(neverSnapshot{snapshots}), WithStaleSnapshotPurge())
+	if err := purging.Load(ctx, revised, nil); err != nil {
+		t.Fatal(err)
+	}
+	if revised.Count != 6 {
+		t.Fatalf("expected the stale snapshot of an archived aggregate to be used, got %d", revised.Count)
+	}
+	if _, ok := snapshots.get("a"); !ok {
+		t.Fatal("expected the snapshot of an archived aggregate to be kept")
+	}
+
+	noSnapshots := NewRepository(WithEventStore(events))
+	if err := noSnapshots.Archive(ctx, &counter{}, cold); !errors.Is(err, ErrNoSnapshotStore) {
+		t.Fatalf("expected ErrNoSnapshotStore, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// WithLogger logs the repository's operations: stores and loads at debug level, snapshot and
+// publish failures at warn or error level. Records carry the gosignal.LogKey attributes.
+func WithLogger(logger *slog.Logger) func(*Repository) {
+	return func(r *Repository) {
+		r.logger = logger
+	}
+}
+
+func (r *Repository) log() *slog.Logger {
+	if r.logger == nil {
+		return gosignal.DiscardLogger()
+	}
+	return r.logger
+}
+
+// logAttrs describes the aggregate and, when given, an event of it
+func (r *Repository) logAttrs(aggregateID string, event *gosignal.Event) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String(gosignal.LogKeyAggregateType, r.aggregateType),
+		slog.String(gosignal.LogKeyAggregateID, aggregateID),
+	}
+	if event != nil {
+		attrs = append(attrs,
+			slog.String(gosignal.LogKeyEventType, event.Type),
+			slog.Uint64(gosignal.LogKeyVersion, event.Version))
+	}
+	return attrs
+}
+
+// logStore logs the outcome of Store, publish failures are logged where they happen
+func (r *Repository) logStore(ctx context.Context, events []gosignal.Event, start time.Time, err error) {
+	if len(events) == 0 {
+		return
+	}
+
+	last := events[len(events)-1]
+	attrs := append(r.logAttrs(last.AggregateID, &last),
+		slog.Int("events", len(events)), slog.Duration(gosignal.LogKeyDuration, time.Since(start)))
+
+	var perr *PublishError
+	switch {
+	case err == nil:
+		r.log().LogAttrs(ctx, slog.LevelDebug, "events stored", attrs...)
+	case errors.Is(err, ErrVersionConflict):
+		r.log().LogAttrs(ctx, slog.LevelDebug, "version conflict storing events", attrs...)
+	case !errors.As(err, &perr):
+		r.log().LogAttrs(ctx, slog.LevelError, "storing events failed", append(attrs, slog.Any("error", err))...)
+	}
+}
+
+// logLoad logs the outcome of a load, aggregates without events are not a failure
+func (r *Repository) logLoad(ctx context.Context, agg Aggregate, start time.Time, err error) {
+	attrs := append(r.logAttrs(agg.GetID(), nil),
+		slog.Uint64(gosignal.LogKeyVersion, agg.GetVersion()), slog.Duration(gosignal.LogKeyDuration, time.Since(start)))
+
+	switch {
+	case err == nil:
+		r.log().LogAttrs(ctx, slog.LevelDebug, "aggregate loaded", attrs...)
+	case errors.Is(err, ErrNoEvents):
+		r.log().LogAttrs(ctx, slog.LevelDebug, "aggregate has no events", attrs...)
+	case !errors.Is(err, ErrSnapshotFailed):
+		r.log().LogAttrs(ctx, slog.LevelError, "loading aggregate failed", append(attrs, slog.Any("error", err))...)
+	}
+}
+
+// logSnapshotFailure logs a snapshot that couldn't be built or stored, loads leave snapshot
+// failures to it
+func (r *Repository) logSnapshotFailure(ctx context.Context, aggID string, version uint64, err error) {
+	attrs := append(r.logAttrs(aggID, nil), slog.Uint64(gosignal.LogKeyVersion, version), slog.Any("error", err))
+	r.log().LogAttrs(ctx, slog.LevelWarn, "storing snapshot failed", attrs...)
+}
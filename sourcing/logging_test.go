@@ -0,0 +1,115 @@
+package sourcing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/Howard3/gosignal"
+)
+
+// logRecords decodes the records written by a JSON handler
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRepositoryLogging(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	errBroker := errors.New("broker down")
+	queue := &testQueue{sendErr: func(string) error { return errBroker }}
+	repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(queue),
+		WithAggregateType("counter"), WithLogger(logger))
+
+	if err := repo.Store(ctx, incremented("c1", 0, 1)); !errors.Is(err, errBroker) {
+		t.Fatalf("expected the queue error, got %v", err)
+	}
+	c := &counter{}
+	c.SetID("c1")
+	if err := repo.Load(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	records := logRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %v", records)
+	}
+
+	failed := records[0]
+	if failed["level"] != "ERROR" || failed["msg"] != "publishing events failed" {
+		t.Fatalf("unexpected publish record %v", failed)
+	}
+	want := map[string]any{
+		gosignal.LogKeyAggregateType: "counter",
+		gosignal.LogKeyAggregateID:   "c1",
+		gosignal.LogKeyEventType:     "incremented",
+		gosignal.LogKeyVersion:       float64(0),
+		"unpublished":                float64(2),
+		"error":                      "broker down",
+	}
+	for key, value := range want {
+		if failed[key] != value {
+			t.Errorf("publish record %s: got %v, want %v", key, failed[key], value)
+		}
+	}
+
+	loaded := records[1]
+	if loaded["level"] != "DEBUG" || loaded["msg"] != "aggregate loaded" ||
+		loaded[gosignal.LogKeyVersion] != float64(2) || loaded[gosignal.LogKeyDuration] == nil {
+		t.Fatalf("unexpected load record %v", loaded)
+	}
+}
+
+// failingSnapshotStore fails to store snapshots
+type failingSnapshotStore struct {
+	*testSnapshotStore
+}
+
+func (failingSnapshotStore) Store(context.Context, string, Snapshot) error {
+	return errors.New("disk full")
+}
+
+func TestRepositoryLogsSnapshotFailuresOnce(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	events := newTestEventStore()
+	_ = events.Store(ctx, incremented("c1", 0, 1))
+	repo := NewRepository(WithEventStore(events), WithLogger(logger),
+		WithSnapshotStrategy(alwaysSnapshot{failingSnapshotStore{newTestSnapshotStore()}}))
+
+	c := &counter{}
+	c.SetID("c1")
+	if err := repo.Load(ctx, c, nil); !errors.Is(err, ErrSnapshotFailed) {
+		t.Fatalf("expected ErrSnapshotFailed, got %v", err)
+	}
+
+	records := logRecords(t, &buf)
+	if len(records) != 1 || records[0]["msg"] != "storing snapshot failed" || records[0][gosignal.LogKeyVersion] != float64(2) {
+		t.Fatalf("expected a single snapshot failure record, got %v", records)
+	}
+}
+
+func TestRepositoryWithoutLogger(t *testing.T) {
+	repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(&testQueue{}))
+	if err := repo.Store(context.Background(), incremented("c1", 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,243 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// ErrQueueNotReady is the error returned by Store when the policy requires a ready queue and the
+// queue reports it isn't, it is joined with the queue's error. Nothing is stored in that case.
+var ErrQueueNotReady = errors.New("queue not ready")
+
+// ErrReadinessUnknown is the error returned by Store when the policy requires a ready queue and the
+// queue does not implement gosignal.ReadinessChecker. Nothing is stored in that case.
+var ErrReadinessUnknown = errors.New("queue can't report readiness")
+
+// ErrNoPendingStore is the error returned by PublishPending without a pending publication store
+var ErrNoPendingStore = errors.New("no pending publication store")
+
+// ErrRecordingPending is the error returned when unpublished events can't be recorded in the
+// pending publication store, it is joined with the underlying error
+var ErrRecordingPending = errors.New("error recording pending publication")
+
+// PendingPublicationStore keeps stored events that could not be published, so they can be
+// redelivered later with Repository.PublishPending
+type PendingPublicationStore interface {
+	// AddPending records events for later publication
+	AddPending(ctx context.Context, events []gosignal.Event) error
+	// LoadPending returns up to limit pending events, in the order they were added
+	LoadPending(ctx context.Context, limit int) ([]gosignal.Event, error)
+	// RemovePending removes a published event, identified by its aggregate type, id and version
+	RemovePending(ctx context.Context, event gosignal.Event) error
+	// HasPending reports whether events of the event's aggregate, identified by its aggregate type
+	// and id, are pending
+	HasPending(ctx context.Context, event gosignal.Event) (bool, error)
+}
+
+// PublishFailurePolicy controls what Store does when events can't be published to the queue. The
+// zero value publishes each event once and stops at the first failure.
+type PublishFailurePolicy struct {
+	// Retry retries each failed send with backoff, only when Attempts is above one
+	Retry RetryPolicy
+	// Pending records the events that still couldn't be published, Store then succeeds and the
+	// events are delivered by PublishPending. Later events of an aggregate with pending events are
+	// recorded behind them rather than sent, so each aggregate's events reach subscribers in order.
+	//
+	// Events are recorded after the event store committed them, not in the same transaction, so a
+	// crash in between loses their publication even when both use the same database.
+	Pending PendingPublicationStore
+	// RequireReady makes Store fail with ErrQueueNotReady before storing anything if the queue
+	// reports it isn't ready, or with ErrReadinessUnknown if it doesn't implement
+	// gosignal.ReadinessChecker
+	RequireReady bool
+}
+
+// WithPublishFailurePolicy sets how Store handles queue failures
+func WithPublishFailurePolicy(policy PublishFailurePolicy) func(*Repository) {
+	return func(r *Repository) {
+		r.publishPolicy = policy
+	}
+}
+
+// PublishError is the error returned by Store when its events were stored but not all of them were
+// published, errors.Is(err, ErrSendingEvent) holds for it
+type PublishError struct {
+	Published   []gosignal.Event // events sent to the queue, in order
+	Unpublished []gosignal.Event // events from the first failed send on
+	Err         error            // the queue's error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("%s: published %d of %d events: %v",
+		ErrSendingEvent, len(e.Published), len(e.Published)+len(e.Unpublished), e.Err)
+}
+
+func (e *PublishError) Unwrap() []error {
+	return []error{ErrSendingEvent, e.Err}
+}
+
+// checkQueueReady applies the RequireReady policy
+func (r *Repository) checkQueueReady() error {
+	if !r.publishPolicy.RequireReady {
+		return nil
+	}
+
+	checker, ok := r.queue.(gosignal.ReadinessChecker)
+	if !ok {
+		return ErrReadinessUnknown
+	}
+
+	if err := checker.Ready(); err != nil {
+		return errors.Join(ErrQueueNotReady, err)
+	}
+
+	return nil
+}
+
+// publish sends stored events to the queue following the failure policy. Events of aggregates with
+// pending events are held back and recorded as pending too.
+func (r *Repository) publish(ctx context.Context, events []gosignal.Event) error {
+	var published, held []gosignal.Event
+	pending := make(map[streamKey]bool)
+
+	for i, event := range events {
+		hold, err := r.hasPending(ctx, pending, event)
+		if err == nil && hold {
+			held = append(held, event)
+			continue
+		}
+
+		if err == nil {
+			err = r.send(ctx, event)
+		}
+		if err == nil {
+			published = append(published, event)
+			continue
+		}
+
+		r.instr().Count(MetricPublishFailures, int64(len(events)-i), r.metricAttrs()...)
+		return r.recordUnpublished(ctx, published, append(held, events[i:]...), err)
+	}
+
+	if len(held) == 0 {
+		return nil
+	}
+
+	return r.recordUnpublished(ctx, published, held, nil)
+}
+
+// hasPending reports whether the event's aggregate has pending events, remembering the answer for
+// the aggregate's later events
+func (r *Repository) hasPending(ctx context.Context, pending map[streamKey]bool, event gosignal.Event) (bool, error) {
+	if r.publishPolicy.Pending == nil {
+		return false, nil
+	}
+
+	key := streamKey{aggregateType: event.AggregateType, aggregateID: event.AggregateID}
+	if hold, ok := pending[key]; ok {
+		return hold, nil
+	}
+
+	hold, err := r.publishPolicy.Pending.HasPending(ctx, event)
+	if err != nil {
+		return false, errors.Join(ErrRecordingPending, err)
+	}
+	pending[key] = hold
+
+	return hold, nil
+}
+
+// recordUnpublished records events in the pending publication store, cause is the queue's error or
+// nil for events held behind pending ones. Without a pending store, or when recording fails, it
+// returns a *PublishError.
+func (r *Repository) recordUnpublished(ctx context.Context, published, unpublished []gosignal.Event, cause error) error {
+	perr := &PublishError{Published: published, Unpublished: unpublished, Err: cause}
+
+	event := unpublished[0]
+	attrs := append(r.logAttrs(event.AggregateID, &event),
+		slog.Int("published", len(published)), slog.Int("unpublished", len(unpublished)))
+
+	if r.publishPolicy.Pending == nil {
+		r.log().LogAttrs(ctx, slog.LevelError, "publishing events failed", append(attrs, slog.Any("error", cause))...)
+		return perr
+	}
+
+	if err := r.publishPolicy.Pending.AddPending(ctx, unpublished); err != nil {
+		perr.Err = errors.Join(perr.Err, ErrRecordingPending, err)
+		r.log().LogAttrs(ctx, slog.LevelError, "recording pending publication failed",
+			append(attrs, slog.Any("error", perr.Err))...)
+		return perr
+	}
+
+	if cause == nil {
+		r.log().LogAttrs(ctx, slog.LevelInfo, "events recorded behind pending publications", attrs...)
+		return nil
+	}
+
+	r.log().LogAttrs(ctx, slog.LevelWarn, "publishing events failed, recorded for later publication",
+		append(attrs, slog.Any("error", cause))...)
+	return nil
+}
+
+// send sends an event, retrying with backoff when the policy asks for it
+func (r *Repository) send(ctx context.Context, event gosignal.Event) error {
+	err := r.queue.Send(event.Type, event.Data)
+	if err == nil || r.publishPolicy.Retry.Attempts <= 1 {
+		return err
+	}
+
+	policy := r.publishPolicy.Retry.withDefaults()
+	for retry := 1; retry < policy.Attempts; retry++ {
+		timer := time.NewTimer(policy.delay(retry))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+
+		if err = r.queue.Send(event.Type, event.Data); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// PublishPending sends up to limit events from the pending publication store, oldest first, and
+// removes each one once it was sent. It returns the number of events published.
+func (r *Repository) PublishPending(ctx context.Context, limit int) (int, error) {
+	if r.publishPolicy.Pending == nil {
+		return 0, ErrNoPendingStore
+	}
+	if r.queue == nil {
+		return 0, ErrNoQueueDefined
+	}
+
+	events, err := r.publishPolicy.Pending.LoadPending(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		if err := r.send(ctx, event); err != nil {
+			r.log().LogAttrs(ctx, slog.LevelWarn, "publishing pending events failed",
+				append(r.logAttrs(event.AggregateID, &event), slog.Int("published", i), slog.Any("error", err))...)
+			return i, &PublishError{Published: events[:i], Unpublished: events[i:], Err: err}
+		}
+		if err := r.publishPolicy.Pending.RemovePending(ctx, event); err != nil {
+			return i + 1, err
+		}
+	}
+
+	if len(events) > 0 {
+		r.log().LogAttrs(ctx, slog.LevelInfo, "pending events published", slog.Int("events", len(events)))
+	}
+
+	return len(events), nil
+}
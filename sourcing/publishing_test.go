@@ -0,0 +1,201 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// testPendingStore is an in-memory PendingPublicationStore
+type testPendingStore struct {
+	mu     sync.Mutex
+	events []gosignal.Event
+}
+
+func (s *testPendingStore) AddPending(_ context.Context, events []gosignal.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *testPendingStore) LoadPending(_ context.Context, limit int) ([]gosignal.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]gosignal.Event{}, s.events[:min(limit, len(s.events))]...), nil
+}
+
+func (s *testPendingStore) RemovePending(_ context.Context, event gosignal.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, pending := range s.events {
+		if pending.AggregateID == event.AggregateID && pending.Version == event.Version {
+			s.events = append(s.events[:i], s.events[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *testPendingStore) HasPending(_ context.Context, event gosignal.Event) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pending := range s.events {
+		if pending.AggregateType == event.AggregateType && pending.AggregateID == event.AggregateID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// flakyQueue fails the given number of sends before succeeding
+type flakyQueue struct {
+	testQueue
+	failures int
+	notReady error
+}
+
+func newFlakyQueue(failures int) *flakyQueue {
+	q := &flakyQueue{failures: failures}
+	q.sendErr = func(string) error {
+		if q.failures > 0 {
+			q.failures--
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}
+	return q
+}
+
+func (q *flakyQueue) Ready() error {
+	return q.notReady
+}
+
+func typedEvents(aggID string, types ...string) []gosignal.Event {
+	events := incremented(aggID, 0, uint64(len(types)-1))
+	for i := range events {
+		events[i].Type = types[i]
+	}
+	return events
+}
+
+func TestRepositoryStoreReportsUnpublishedEvents(t *testing.T) {
+	ctx := context.Background()
+	store := newTestEventStore()
+	queue := &testQueue{sendErr: func(messageType string) error {
+		if messageType == "b" {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}}
+	repo := NewRepository(WithEventStore(store), WithQueue(queue))
+
+	err := repo.Store(ctx, typedEvents("c1", "a", "b", "c"))
+
+	var perr *PublishError
+	if !errors.As(err, &perr) || !errors.Is(err, ErrSendingEvent) {
+		t.Fatalf("expected a PublishError, got %v", err)
+	}
+	if len(perr.Published) != 1 || perr.Published[0].Type != "a" || len(perr.Unpublished) != 2 || perr.Unpublished[0].Type != "b" {
+		t.Fatalf("unexpected split: published %v, unpublished %v", perr.Published, perr.Unpublished)
+	}
+	if events, _ := store.Load(ctx, "c1", LoadEventsOptions{}); len(events) != 3 {
+		t.Fatalf("expected the events to stay stored, got %d", len(events))
+	}
+}
+
+func TestRepositoryStoreRetriesSends(t *testing.T) {
+	queue := newFlakyQueue(2)
+	repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(queue),
+		WithPublishFailurePolicy(PublishFailurePolicy{Retry: RetryPolicy{Attempts: 3, BaseDelay: time.Microsecond}}))
+
+	if err := repo.Store(context.Background(), incremented("c1", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.sent) != 2 {
+		t.Fatalf("expected both events to be published, got %v", queue.sent)
+	}
+}
+
+func TestRepositoryStoreRecordsPendingPublications(t *testing.T) {
+	ctx := context.Background()
+	queue := newFlakyQueue(1)
+	pending := &testPendingStore{}
+	repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(queue),
+		WithPublishFailurePolicy(PublishFailurePolicy{Pending: pending}))
+
+	if err := repo.Store(ctx, incremented("c1", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending.events) != 2 || len(queue.sent) != 0 {
+		t.Fatalf("expected both events to be pending, pending %d, sent %v", len(pending.events), queue.sent)
+	}
+
+	n, err := repo.PublishPending(ctx, 10)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 redelivered events, got %d: %v", n, err)
+	}
+	if len(pending.events) != 0 || len(queue.sent) != 2 {
+		t.Fatalf("expected pending events to be published and removed, pending %d, sent %v", len(pending.events), queue.sent)
+	}
+}
+
+func TestRepositoryStoreRequiresReadyQueue(t *testing.T) {
+	ctx := context.Background()
+	store := newTestEventStore()
+	queue := newFlakyQueue(0)
+	queue.notReady = errors.New("disconnected")
+	repo := NewRepository(WithEventStore(store), WithQueue(queue),
+		WithPublishFailurePolicy(PublishFailurePolicy{RequireReady: true}))
+
+	if err := repo.Store(ctx, incremented("c1", 0, 0)); !errors.Is(err, ErrQueueNotReady) {
+		t.Fatalf("expected ErrQueueNotReady, got %v", err)
+	}
+	if events, _ := store.Load(ctx, "c1", LoadEventsOptions{}); len(events) != 0 {
+		t.Fatalf("expected nothing to be stored, got %d events", len(events))
+	}
+}
+
+func TestRepositoryStoreHoldsEventsBehindPendingOnes(t *testing.T) {
+	ctx := context.Background()
+	queue := newFlakyQueue(1)
+	pending := &testPendingStore{}
+	repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(queue),
+		WithPublishFailurePolicy(PublishFailurePolicy{Pending: pending}))
+
+	if err := repo.Store(ctx, typedEvents("c1", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the queue is back, but c1's next event must not overtake its pending one
+	events := typedEvents("c1", "a", "b")[1:]
+	events = append(events, typedEvents("c2", "c")...)
+	if err := repo.Store(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.sent) != 1 || queue.sent[0] != "c" {
+		t.Fatalf("expected only the other aggregate's event to be sent, got %v", queue.sent)
+	}
+
+	if _, err := repo.PublishPending(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c", "a", "b"}; fmt.Sprint(queue.sent) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, queue.sent)
+	}
+}
+
+func TestRepositoryStoreRequiresReadinessChecker(t *testing.T) {
+	store := newTestEventStore()
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}),
+		WithPublishFailurePolicy(PublishFailurePolicy{RequireReady: true}))
+
+	if err := repo.Store(context.Background(), incremented("c1", 0, 0)); !errors.Is(err, ErrReadinessUnknown) {
+		t.Fatalf("expected ErrReadinessUnknown, got %v", err)
+	}
+}
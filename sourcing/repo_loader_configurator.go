@@ -37,7 +37,8 @@ func (c *RepoLoaderConfigurator) MaxVersion(version uint64) *RepoLoaderConfigura
 	return c
 }
 
-// EventTypes sets the event types to load and returns the configurator.
+// EventTypes sets the event types to load and returns the configurator. When the repository has
+// upcasters the types are matched after upcasting, so every event is read from the store.
 // NOTE: this will lead to inconsistent state if you're loading against an aggregate. Only use
 // this if you're directly querying events.
 func (c *RepoLoaderConfigurator) EventTypes(types ...string) *RepoLoaderConfigurator {
@@ -54,6 +55,8 @@ func (c *RepoLoaderConfigurator) FromTime(time time.Time) *RepoLoaderConfigurato
 }
 
 // ToTime sets the ending time to which to load events and returns the configurator.
+// NOTE: Repository.Load still starts from the latest snapshot, use Repository.LoadAsOf to load an
+// aggregate as it was at a point in time.
 func (c *RepoLoaderConfigurator) ToTime(time time.Time) *RepoLoaderConfigurator {
 	c.loadOptions.lev.ToTime = &time
 	return c
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/Howard3/gosignal"
@@ -36,14 +38,36 @@ var ErrStoringEvents = errors.New("error storing events")
 var ErrNoQueueDefined = errors.New("no queue defined")
 
 // ErrSendingEvent is the error returned when an error occurs while sending an event to the Queue
-// it is joined with the underlying error
+// it is wrapped by PublishError together with the underlying error
 var ErrSendingEvent = errors.New("error sending event")
 
+// ErrVersionConflict is the error returned when events can't be stored because another writer
+// stored events for the same aggregate versions first
+var ErrVersionConflict = errors.New("version conflict")
+
+// ErrUnexpectedVersion is the error returned by Save when the events don't continue from the
+// expected version
+var ErrUnexpectedVersion = errors.New("unexpected event version")
+
+// ErrAggregateTypeMismatch is the error returned when an event's aggregate type does not match the
+// aggregate type the repository is bound to
+var ErrAggregateTypeMismatch = errors.New("aggregate type mismatch")
+
 // Repository is a struct that interacts with the event store, snapshot store, and aggregate
 type Repository struct {
 	eventStore       EventStore
 	snapshotStrategy SnapshotStrategy
 	queue            gosignal.Queue
+	aggregateType    string
+	snapshotWorkers  *SnapshotWorkerPool
+	purgeStale       bool
+	upcasters        *UpcasterChain
+	retryPolicy      RetryPolicy
+	cache            *AggregateCache
+	publishPolicy    PublishFailurePolicy
+	hooks            []RepositoryHooks
+	instrumentation  gosignal.Instrumentation
+	logger           *slog.Logger
 }
 
 type NewRepoOptions func(*Repository)
@@ -66,6 +90,42 @@ func WithQueue(q gosignal.Queue) func(*Repository) {
 	}
 }
 
+// WithAggregateType binds the repository to an aggregate type (stream category). Events stored
+// through the repository are stamped with it and loads are filtered by it, which allows many
+// aggregate types to safely share a single event store. Snapshot stores are keyed by aggregate id
+// only, aggregate types that can share ids need a snapshot store, or SQL table, each.
+func WithAggregateType(aggregateType string) func(*Repository) {
+	return func(r *Repository) {
+		r.aggregateType = aggregateType
+	}
+}
+
+// WithBackgroundSnapshots hands snapshot creation during Load to the worker pool, so loads neither
+// wait on nor fail because of the snapshot store. The pool is owned by the caller, who must Close it.
+func WithBackgroundSnapshots(pool *SnapshotWorkerPool) func(*Repository) {
+	return func(r *Repository) {
+		r.snapshotWorkers = pool
+	}
+}
+
+// WithStaleSnapshotPurge deletes snapshots whose revision doesn't match the aggregate's
+// SnapshotRevision when they are encountered by Load, and regenerates them after the full replay.
+// Without it stale snapshots are only ignored.
+func WithStaleSnapshotPurge() func(*Repository) {
+	return func(r *Repository) {
+		r.purgeStale = true
+	}
+}
+
+// WithUpcasters runs the chain on every event loaded by the repository before it is returned or
+// applied. Stored events without a schema version are written at the chain's current version of
+// their type.
+func WithUpcasters(chain *UpcasterChain) func(*Repository) {
+	return func(r *Repository) {
+		r.upcasters = chain
+	}
+}
+
 // NewRepository creates a new repository
 func NewRepository(options ...NewRepoOptions) *Repository {
 	r := &Repository{}
@@ -75,40 +135,255 @@ func NewRepository(options ...NewRepoOptions) *Repository {
 	return r
 }
 
-// Store stores events in the event store
+// Store stores events in the event store and then publishes them to the queue. When publishing
+// fails the events remain stored and a *PublishError is returned, unless the PublishFailurePolicy
+// records them for later delivery.
 func (r *Repository) Store(ctx context.Context, events []gosignal.Event) error {
+	return r.storeEvents(ctx, storeRequest{events: events})
+}
+
+// storeRequest is the events stored by Store, Save and UnitOfWork.Commit
+type storeRequest struct {
+	events []gosignal.Event
+	// expected holds the version every stream must be at, when set the events are appended
+	// through a TransactionalEventStore, or stored after checking the versions
+	expected map[streamKey]uint64
+	// typed events already carry their aggregate type, which may differ from the repository's
+	typed bool
+}
+
+// streamKey identifies the stream of an aggregate within its aggregate type
+type streamKey struct {
+	aggregateType string
+	aggregateID   string
+}
+
+func (r *Repository) storeEvents(ctx context.Context, req storeRequest) error {
+	start := time.Now()
+	ctx, done := gosignal.StartOperation(ctx, r.instr(), MetricStore, r.metricAttrs()...)
+
+	events := req.events
+	stamped, err := r.store(ctx, req)
+	if stamped != nil {
+		events = stamped
+	}
+	err = r.afterStore(ctx, events, err)
+
+	if err == nil {
+		r.instr().Count(MetricEventsStored, int64(len(events)), r.metricAttrs()...)
+	}
+	done(err)
+	r.logStore(ctx, events, start, err)
+
+	return err
+}
+
+// store stores and publishes the events, it returns the events as stamped with the aggregate type
+// and schema version
+func (r *Repository) store(ctx context.Context, req storeRequest) ([]gosignal.Event, error) {
 	if r.queue == nil {
-		return ErrNoQueueDefined
+		return nil, ErrNoQueueDefined
 	}
 
-	if err := r.eventStore.Store(ctx, events); err != nil {
-		return errors.Join(ErrStoringEvents, err)
+	if err := r.checkQueueReady(); err != nil {
+		return nil, errors.Join(ErrStoringEvents, err)
 	}
 
-	for _, event := range events {
-		if err := r.queue.Send(event.Type, event.Data); err != nil {
-			// NOTE: this should probably roll back the stored event. TBD on preferred behavior here.
-			return errors.Join(ErrSendingEvent, err)
+	events := req.events
+	if !req.typed {
+		var err error
+		if events, err = r.stampAggregateType(events); err != nil {
+			return nil, errors.Join(ErrStoringEvents, err)
+		}
+	}
+	if r.upcasters != nil {
+		events = r.upcasters.stamp(events)
+	}
+
+	if err := r.beforeStore(ctx, events); err != nil {
+		return events, errors.Join(ErrStoringEvents, err)
+	}
+
+	if err := r.write(ctx, events, req.expected); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			for _, event := range events {
+				r.invalidateCached(event.AggregateID)
+			}
+		}
+		return events, errors.Join(ErrStoringEvents, err)
+	}
+
+	return events, r.publish(ctx, events)
+}
+
+// write stores the events, appending them as streams at the expected versions when any are given
+func (r *Repository) write(ctx context.Context, events []gosignal.Event, expected map[streamKey]uint64) error {
+	if expected == nil {
+		return r.eventStore.Store(ctx, events)
+	}
+
+	streams := appendStreams(events, expected)
+	if tx, ok := r.eventStore.(TransactionalEventStore); ok {
+		return tx.Append(ctx, streams)
+	}
+
+	if err := r.checkVersions(ctx, streams); err != nil {
+		return err
+	}
+
+	return r.eventStore.Store(ctx, events)
+}
+
+// checkVersions returns ErrVersionConflict when a stream has events at or past its expected
+// version, for event stores that can't check versions themselves
+func (r *Repository) checkVersions(ctx context.Context, streams []StreamAppend) error {
+	for _, stream := range streams {
+		expected := stream.ExpectedVersion
+		opts := LoadEventsOptions{MinVersion: &expected, AggregateType: stream.AggregateType}
+
+		events, err := r.eventStore.Load(ctx, stream.AggregateID, opts)
+		if err != nil {
+			return errors.Join(ErrLoadingEvents, err)
+		}
+
+		if len(events) > 0 {
+			return errors.Join(ErrVersionConflict, fmt.Errorf("aggregate %s has events at or past version %d",
+				stream.AggregateID, expected))
 		}
 	}
 
 	return nil
 }
 
+// appendStreams groups the events by stream, in the order the streams first appear. Streams without
+// an expected version are expected at the version of their first event.
+func appendStreams(events []gosignal.Event, expected map[streamKey]uint64) []StreamAppend {
+	index := make(map[streamKey]int)
+	var streams []StreamAppend
+
+	for _, event := range events {
+		key := streamKey{aggregateType: event.AggregateType, aggregateID: event.AggregateID}
+		i, ok := index[key]
+		if !ok {
+			version, ok := expected[key]
+			if !ok {
+				version = event.Version
+			}
+
+			i = len(streams)
+			index[key] = i
+			streams = append(streams, StreamAppend{
+				AggregateID:     key.aggregateID,
+				AggregateType:   key.aggregateType,
+				ExpectedVersion: version,
+			})
+		}
+		streams[i].Events = append(streams[i].Events, event)
+	}
+
+	return streams
+}
+
+// Save stores events continuing an aggregate at the expected version, the version it was loaded at.
+// The events must be numbered expectedVersion, expectedVersion+1, ... and belong to one aggregate.
+// When another writer stored events for the aggregate since it was loaded Save fails with
+// ErrVersionConflict.
+//
+// Event stores implementing TransactionalEventStore check the version as part of storing. With
+// other stores Save checks it before storing, a writer racing that check is only caught by a store
+// enforcing unique versions, such as SQLStore with its unique constraint and IsUniqueViolation.
+func (r *Repository) Save(ctx context.Context, expectedVersion uint64, events []gosignal.Event) error {
+	if len(events) == 0 {
+		return r.Store(ctx, events)
+	}
+
+	for i, event := range events {
+		if event.Version != expectedVersion+uint64(i) || event.AggregateID != events[0].AggregateID {
+			return errors.Join(ErrUnexpectedVersion, fmt.Errorf("event %d: aggregate %s version %d, expected aggregate %s version %d",
+				i, event.AggregateID, event.Version, events[0].AggregateID, expectedVersion+uint64(i)))
+		}
+	}
+
+	// the events are stamped with the repository's aggregate type when it is set
+	key := streamKey{aggregateType: events[0].AggregateType, aggregateID: events[0].AggregateID}
+	if r.aggregateType != "" {
+		key.aggregateType = r.aggregateType
+	}
+
+	return r.storeEvents(ctx, storeRequest{events: events, expected: map[streamKey]uint64{key: expectedVersion}})
+}
+
+// stampAggregateType sets the repository's aggregate type on events that don't have one, it returns
+// a copy of the events so the caller's slice is left untouched
+func (r *Repository) stampAggregateType(events []gosignal.Event) ([]gosignal.Event, error) {
+	if r.aggregateType == "" {
+		return events, nil
+	}
+
+	stamped := make([]gosignal.Event, len(events))
+	for i, event := range events {
+		if event.AggregateType == "" {
+			event.AggregateType = r.aggregateType
+		}
+
+		if event.AggregateType != r.aggregateType {
+			return nil, errors.Join(
+				ErrAggregateTypeMismatch,
+				fmt.Errorf("event aggregate type: %q, repository aggregate type: %q", event.AggregateType, r.aggregateType),
+			)
+		}
+
+		stamped[i] = event
+	}
+
+	return stamped, nil
+}
+
 // Load loads an aggregate from the event store, reconstructing it from its events and snapshot
 func (r *Repository) Load(ctx context.Context, agg Aggregate, opts *RepoLoadOptions) error {
+	start := time.Now()
+	ctx, done := gosignal.StartOperation(ctx, r.instr(), MetricLoad, r.metricAttrs()...)
+	err := r.afterLoad(ctx, agg, r.load(ctx, agg, opts))
+	done(loadFailure(err))
+	r.logLoad(ctx, agg, start, err)
+	return err
+}
+
+func (r *Repository) load(ctx context.Context, agg Aggregate, opts *RepoLoadOptions) error {
 	var err error
 	var snapshot *Snapshot
+	var regenerate bool
+
+	start := time.Now()
+
+	cacheable := opts == nil && r.cache != nil
+	if cacheable {
+		if cached, err := r.loadCached(ctx, agg); cached {
+			return err
+		}
+	}
 
 	if opts == nil {
 		opts = NewRepoLoaderConfigurator().Build()
 	}
 
 	if !opts.skipSnapshot {
-		snapshot, err = r.snapshotLoader(ctx, agg.GetID())
+		snapshot, err = r.snapshotLoader(ctx, agg.GetID(), opts)
 		if err != nil {
 			return err
 		}
+
+		if snapshot, regenerate, err = r.discardStaleSnapshot(ctx, agg, snapshot, opts); err != nil {
+			return err
+		}
+
+		if r.snapshotStrategy != nil && r.snapshotStrategy.GetStore() != nil {
+			if snapshot != nil {
+				r.instr().Count(MetricSnapshotHits, 1, r.metricAttrs()...)
+			} else {
+				r.instr().Count(MetricSnapshotMisses, 1, r.metricAttrs()...)
+			}
+		}
 	}
 
 	if err := r.applySnapshot(ctx, agg, snapshot, opts); err != nil {
@@ -124,13 +399,28 @@ func (r *Repository) Load(ctx context.Context, agg Aggregate, opts *RepoLoadOpti
 		return ErrNoEvents
 	}
 
+	if isTombstoned(events) {
+		return ErrAggregateDeleted
+	}
+
 	if err := r.ApplyEvents(agg, events); err != nil {
 		return errors.Join(ErrApplyingEvent, err)
 	}
+	r.instr().Observe(MetricEventsApplied, float64(len(events)), r.metricAttrs()...)
 
-	skipSnapshot := opts.skipSnapshot || r.snapshotStrategy == nil
-	if !skipSnapshot && r.snapshotStrategy.ShouldSnapshot(snapshot, events) {
-		if err := r.generateSnapshot(ctx, agg.GetID(), agg); err != nil {
+	if cacheable {
+		r.cache.put(agg)
+	}
+
+	stats := LoadStats{Duration: time.Since(start), EventsApplied: len(events)}
+
+	// point-in-time loads must not replace the latest snapshot with an older one
+	pointInTime := opts.lev.MaxVersion != nil
+	skipSnapshot := opts.skipSnapshot || r.snapshotStrategy == nil || pointInTime
+	if !skipSnapshot && (regenerate || r.shouldSnapshot(snapshot, events, stats)) {
+		if r.snapshotWorkers != nil {
+			r.submitSnapshot(agg)
+		} else if err := r.generateSnapshot(ctx, agg.GetID(), agg); err != nil {
 			return errors.Join(ErrSnapshotFailed, err)
 		}
 	}
@@ -138,12 +428,185 @@ func (r *Repository) Load(ctx context.Context, agg Aggregate, opts *RepoLoadOpti
 	return nil
 }
 
+// LoadAsOf loads the aggregate as it was at the given instant. It starts from the most recent
+// snapshot taken at or before the instant whose events all precede it, or from scratch, and
+// replays events up to the instant. Replay stops at the first event recorded after the instant,
+// even if later versions carry earlier timestamps.
+//
+// LoadAsOf never stores a snapshot and never modifies the stores, which makes it safe for
+// auditing and reporting on historical state.
+func (r *Repository) LoadAsOf(ctx context.Context, agg Aggregate, asOf time.Time) error {
+	start := time.Now()
+	ctx, done := gosignal.StartOperation(ctx, r.instr(), MetricLoadAsOf, r.metricAttrs()...)
+	err := r.afterLoad(ctx, agg, r.loadAsOf(ctx, agg, asOf))
+	done(loadFailure(err))
+	r.logLoad(ctx, agg, start, err)
+	return err
+}
+
+func (r *Repository) loadAsOf(ctx context.Context, agg Aggregate, asOf time.Time) error {
+	aggID := agg.GetID()
+
+	snapshot, err := r.snapshotAsOf(ctx, agg, asOf)
+	if err != nil {
+		return err
+	}
+
+	opts := NewRepoLoaderConfigurator().ToTime(asOf).Build()
+	if snapshot != nil {
+		opts.lev.MinVersion = &snapshot.Version
+		if err := r.importState(ctx, agg, snapshot); err != nil {
+			return fmt.Errorf("error applying snapshot: %w", err)
+		}
+	}
+
+	events, err := r.LoadEvents(ctx, aggID, opts)
+	if err != nil {
+		return errors.Join(ErrLoadingEvents, err)
+	}
+
+	next := agg.GetVersion()
+	for i, event := range events {
+		if event.Version != next+uint64(i) {
+			events = events[:i]
+			break
+		}
+	}
+
+	if len(events) == 0 && snapshot == nil {
+		return ErrNoEvents
+	}
+
+	if isTombstoned(events) {
+		return ErrAggregateDeleted
+	}
+
+	if err := r.ApplyEvents(agg, events); err != nil {
+		return errors.Join(ErrApplyingEvent, err)
+	}
+
+	return nil
+}
+
+// snapshotAsOf finds a usable snapshot taken at or before the instant. Snapshots are only used if
+// the last event they contain happened at or before the instant too.
+func (r *Repository) snapshotAsOf(ctx context.Context, agg Aggregate, asOf time.Time) (*Snapshot, error) {
+	if r.snapshotStrategy == nil || r.snapshotStrategy.GetStore() == nil {
+		return nil, nil
+	}
+
+	var snapshot *Snapshot
+	var err error
+
+	store := r.snapshotStrategy.GetStore()
+	if timed, ok := store.(SnapshotAsOfStore); ok {
+		snapshot, err = timed.LoadTakenAtOrBefore(ctx, agg.GetID(), asOf)
+	} else {
+		snapshot, err = store.Load(ctx, agg.GetID())
+	}
+	if err != nil {
+		return nil, errors.Join(ErrFailedToLoadSnapshot, err)
+	}
+
+	if snapshot == nil || snapshot.Timestamp.After(asOf) || snapshot.Revision != snapshotRevision(agg) {
+		return nil, nil
+	}
+	if snapshot.Version == 0 {
+		return snapshot, nil
+	}
+
+	last := snapshot.Version - 1
+	events, err := r.LoadEvents(ctx, agg.GetID(), NewRepoLoaderConfigurator().MinVersion(last).MaxVersion(last).Build())
+	if err != nil {
+		return nil, errors.Join(ErrLoadingEvents, err)
+	}
+	if len(events) != 1 || events[0].Timestamp.After(asOf) {
+		return nil, nil
+	}
+
+	return snapshot, nil
+}
+
+// submitSnapshot hands the snapshot of the aggregate to the background workers. The state of
+// aggregates implementing AggregateCloner is exported by the worker, otherwise it is exported here
+// because the caller is free to mutate the aggregate once Load returns.
+func (r *Repository) submitSnapshot(agg Aggregate) {
+	aggID := agg.GetID()
+
+	if cloner, ok := agg.(AggregateCloner); ok {
+		clone := cloner.Clone()
+		r.snapshotWorkers.Submit(aggID, func(ctx context.Context) error {
+			return r.generateSnapshot(ctx, aggID, clone)
+		})
+		return
+	}
+
+	ss, err := r.buildSnapshot(agg)
+	if err != nil {
+		r.logSnapshotFailure(context.Background(), aggID, agg.GetVersion(), err)
+		r.snapshotWorkers.onError(aggID, errors.Join(ErrSnapshotFailed, err))
+		return
+	}
+
+	r.snapshotWorkers.Submit(aggID, func(ctx context.Context) error {
+		return r.storeSnapshot(ctx, aggID, ss)
+	})
+}
+
+// discardStaleSnapshot drops a snapshot whose revision doesn't match the aggregate's, so the
+// aggregate is rebuilt from a full replay. It reports whether the snapshot should be regenerated.
+// Point-in-time loads only ignore the snapshot, purging it would also delete the snapshots later
+// versions are loaded from.
+func (r *Repository) discardStaleSnapshot(ctx context.Context, agg Aggregate, ss *Snapshot, opts *RepoLoadOptions) (*Snapshot, bool, error) {
+	if ss == nil || ss.Revision == snapshotRevision(agg) {
+		return ss, false, nil
+	}
+
+	// the snapshot of an archived aggregate is all that is left of its history
+	if archived, err := r.isArchived(ctx, agg.GetID(), ss); err != nil || archived {
+		return ss, false, err
+	}
+
+	if !r.purgeStale || opts.lev.MaxVersion != nil {
+		return nil, false, nil
+	}
+
+	if err := r.snapshotStrategy.GetStore().Delete(ctx, agg.GetID()); err != nil {
+		return nil, false, errors.Join(ErrFailedToLoadSnapshot, err)
+	}
+
+	return nil, true, nil
+}
+
+// isArchived reports whether the events preceding the snapshot are gone from the event store, as
+// after Archive, which leaves its marker or nothing at the first version
+func (r *Repository) isArchived(ctx context.Context, aggID string, ss *Snapshot) (bool, error) {
+	if ss.Version == 0 {
+		return false, nil
+	}
+
+	first := uint64(0)
+	events, err := r.eventStore.Load(ctx, aggID, LoadEventsOptions{MaxVersion: &first, AggregateType: r.aggregateType})
+	if err != nil {
+		return false, errors.Join(ErrLoadingEvents, err)
+	}
+
+	return len(events) == 0 || isArchiveMarker(events[0]), nil
+}
+
+func (r *Repository) shouldSnapshot(snapshot *Snapshot, events []gosignal.Event, stats LoadStats) bool {
+	if s, ok := r.snapshotStrategy.(StatsAwareSnapshotStrategy); ok {
+		return s.ShouldSnapshotWithStats(snapshot, events, stats)
+	}
+	return r.snapshotStrategy.ShouldSnapshot(snapshot, events)
+}
+
 func (r *Repository) applySnapshot(ctx context.Context, agg Aggregate, ss *Snapshot, opts *RepoLoadOptions) error {
 	var err error
 
 	// we need to not use the snapshot, and skip snapshot generation if the max version is lower
 	// than the snapshot
-	maxVerLowerThanSnapshot := opts.lev.MaxVersion != nil && ss.Version > *opts.lev.MaxVersion
+	maxVerLowerThanSnapshot := ss != nil && opts.lev.MaxVersion != nil && ss.Version > *opts.lev.MaxVersion
 	opts.skipSnapshot = opts.skipSnapshot || maxVerLowerThanSnapshot
 
 	if !maxVerLowerThanSnapshot && ss != nil {
@@ -174,31 +637,72 @@ func (r *Repository) generateSnapshot(ctx context.Context, aggID string, agg Agg
 		return nil // nothing to do
 	}
 
+	ss, err := r.buildSnapshot(agg)
+	if err != nil {
+		r.logSnapshotFailure(ctx, aggID, agg.GetVersion(), err)
+		return err
+	}
+
+	return r.storeSnapshot(ctx, aggID, ss)
+}
+
+func (r *Repository) buildSnapshot(agg Aggregate) (Snapshot, error) {
 	state, err := agg.ExportState()
 	if err != nil {
-		return errors.Join(ErrFailedToExportState, err)
+		return Snapshot{}, errors.Join(ErrFailedToExportState, err)
 	}
 
-	ss := Snapshot{
+	return Snapshot{
 		Data:      state,
 		Timestamp: time.Now(),
 		Version:   agg.GetVersion(),
 		ID:        agg.GetID(),
+		Revision:  snapshotRevision(agg),
+	}, nil
+}
+
+func (r *Repository) storeSnapshot(ctx context.Context, aggID string, ss Snapshot) error {
+	if r.snapshotStrategy == nil || r.snapshotStrategy.GetStore() == nil {
+		return nil // nothing to do
 	}
 
-	if err := r.snapshotStrategy.GetStore().Store(ctx, aggID, ss); err != nil {
-		return errors.Join(ErrFailedToExportState, err)
+	start := time.Now()
+
+	err := r.onSnapshot(ctx, ss)
+	if err != nil {
+		err = errors.Join(ErrSnapshotFailed, err)
+	} else if err = r.snapshotStrategy.GetStore().Store(ctx, aggID, ss); err != nil {
+		err = errors.Join(ErrFailedToExportState, err)
+	}
+
+	if err != nil {
+		r.logSnapshotFailure(ctx, aggID, ss.Version, err)
+		return err
 	}
 
+	r.instr().Count(MetricSnapshotsStored, 1, r.metricAttrs()...)
+	r.log().LogAttrs(ctx, slog.LevelDebug, "snapshot stored", append(r.logAttrs(aggID, nil),
+		slog.Uint64(gosignal.LogKeyVersion, ss.Version), slog.Duration(gosignal.LogKeyDuration, time.Since(start)))...)
+
 	return nil
 }
 
-func (r *Repository) snapshotLoader(ctx context.Context, aggID string) (*Snapshot, error) {
+// snapshotLoader loads the latest snapshot, or with a MaxVersion and a store keeping snapshot
+// history, the nearest snapshot at or below that version
+func (r *Repository) snapshotLoader(ctx context.Context, aggID string, opts *RepoLoadOptions) (*Snapshot, error) {
 	if r.snapshotStrategy == nil || r.snapshotStrategy.GetStore() == nil {
 		return nil, nil // nothing to do
 	}
 
-	snapshot, err := r.snapshotStrategy.GetStore().Load(ctx, aggID)
+	var snapshot *Snapshot
+	var err error
+
+	store := r.snapshotStrategy.GetStore()
+	if history, ok := store.(SnapshotHistoryStore); ok && opts.lev.MaxVersion != nil {
+		snapshot, err = history.LoadAtOrBefore(ctx, aggID, *opts.lev.MaxVersion)
+	} else {
+		snapshot, err = store.Load(ctx, aggID)
+	}
 	if err != nil {
 		return nil, errors.Join(ErrFailedToLoadSnapshot, err)
 	}
@@ -209,6 +713,9 @@ func (r *Repository) snapshotLoader(ctx context.Context, aggID string) (*Snapsho
 // ApplyEvents iteratively applies events to an aggregate
 func (r *Repository) ApplyEvents(agg Aggregate, events []gosignal.Event) error {
 	for _, event := range events {
+		if err := r.beforeApply(agg, event); err != nil {
+			return errors.Join(ErrApplyingEvent, err)
+		}
 		if err := agg.Apply(event); err != nil {
 			return errors.Join(ErrApplyingEvent, err)
 		}
@@ -216,17 +723,43 @@ func (r *Repository) ApplyEvents(agg Aggregate, events []gosignal.Event) error {
 	return nil
 }
 
-// LoadEvents loads events from the event store
+// LoadEvents loads events from the event store, upcasting them when upcasters are configured
 func (r *Repository) LoadEvents(ctx context.Context, aggregateID string, opts *RepoLoadOptions) ([]gosignal.Event, error) {
 	if opts == nil {
 		opts = NewRepoLoaderConfigurator().Build()
 	}
 
-	event, err := r.eventStore.Load(ctx, aggregateID, *opts.lev)
+	lev := *opts.lev
+	if r.aggregateType != "" {
+		lev.AggregateType = r.aggregateType
+	}
+
+	// upcasters can rename event types, so the stored types can't be filtered on
+	types := lev.EventTypes
+	if r.upcasters != nil {
+		lev.EventTypes = nil
+	}
+
+	event, err := r.eventStore.Load(ctx, aggregateID, lev)
 	if err != nil {
 		return nil, errors.Join(ErrLoadingEvents, err)
 	}
 
+	// only the first event can be the marker of an archive
+	if len(event) > 0 && isArchiveMarker(event[0]) {
+		event = event[1:]
+	}
+
+	if r.upcasters != nil {
+		if event, err = r.upcasters.UpcastAll(event); err != nil {
+			return nil, errors.Join(ErrLoadingEvents, err)
+		}
+
+		if len(types) > 0 {
+			event = slices.DeleteFunc(event, func(e gosignal.Event) bool { return !slices.Contains(types, e.Type) })
+		}
+	}
+
 	return event, nil
 }
 
@@ -246,6 +779,12 @@ func (r *Repository) ReplaceVersion(ctx context.Context, aggID string, agg Aggre
 		return ErrNoEvents
 	}
 
+	stamped, err := r.stampAggregateType([]gosignal.Event{e})
+	if err != nil {
+		return errors.Join(ErrReplacingVersion, err)
+	}
+	e = stamped[0]
+
 	events, err = r.replaceVersionInEventSlice(events, ver, e)
 	if err != nil {
 		return errors.Join(ErrReplacingVersion, err)
@@ -256,6 +795,10 @@ func (r *Repository) ReplaceVersion(ctx context.Context, aggID string, agg Aggre
 		return errors.Join(ErrReplacingVersion, err)
 	}
 
+	// invalidate on both sides of the replace, a load running meanwhile may cache the old event
+	r.invalidateCached(aggID)
+	defer r.invalidateCached(aggID)
+
 	if err := r.eventStore.Replace(ctx, aggID, ver, e); err != nil {
 		return errors.Join(ErrReplacingVersion, err)
 	}
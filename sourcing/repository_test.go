@@ -0,0 +1,256 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// revisedCounter is a counter whose snapshot format is at revision 2
+type revisedCounter struct {
+	counter
+}
+
+func (c *revisedCounter) SnapshotRevision() uint64 { return 2 }
+
+func TestRepositoryLoadIgnoresStaleSnapshotRevision(t *testing.T) {
+	ctx := context.Background()
+
+	for _, purge := range []bool{false, true} {
+		name := "ignore"
+		if purge {
+			name = "purge"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			events := newTestEventStore()
+			_ = events.Store(ctx, incremented("a", 0, 3))
+
+			// a revision 1 snapshot at version 2 whose state can't be imported by revision 2
+			snapshots := newTestSnapshotStore()
+			_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 2, Data: []byte(`{"legacy":true}`), Revision: 1})
+
+			options := []NewRepoOptions{WithEventStore(events), WithSnapshotStrategy(neverSnapshot{snapshots})}
+			if purge {
+				options = append(options, WithStaleSnapshotPurge())
+			}
+			repo := NewRepository(options...)
+
+			agg := &revisedCounter{counter{DefaultAggregate: DefaultAggregate{ID: "a"}}}
+			if err := repo.Load(ctx, agg, nil); err != nil {
+				t.Fatal(err)
+			}
+			if agg.Count != 4 || agg.GetVersion() != 4 {
+				t.Fatalf("expected full replay to count 4 at version 4, got %d at %d", agg.Count, agg.GetVersion())
+			}
+
+			ss, _ := snapshots.get("a")
+			if purge && (ss.Revision != 2 || ss.Version != 4) {
+				t.Fatalf("expected snapshot regenerated at revision 2, got %+v", ss)
+			}
+			if !purge && ss.Revision != 1 {
+				t.Fatalf("expected stale snapshot to be left alone, got %+v", ss)
+			}
+		})
+	}
+}
+
+func TestRepositoryLoadUsesMatchingSnapshotRevision(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, incremented("a", 0, 3))
+
+	snapshots := newTestSnapshotStore()
+	_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 2, Data: []byte("10"), Revision: 2})
+
+	repo := NewRepository(WithEventStore(events), WithSnapshotStrategy(neverSnapshot{snapshots}))
+
+	agg := &revisedCounter{counter{DefaultAggregate: DefaultAggregate{ID: "a"}}}
+	if err := repo.Load(ctx, agg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if agg.Count != 12 {
+		t.Fatalf("expected snapshot state plus two events, got %d", agg.Count)
+	}
+}
+
+func TestRepositoryLoadPointInTimeUsesNearestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, incremented("a", 0, 9))
+
+	snapshots := newTestHistoryStore()
+	_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 2, Data: []byte("100")})
+	_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 8, Data: []byte("200")})
+	snapshots.stored = 0
+
+	repo := NewRepository(WithEventStore(events), WithSnapshotStrategy(alwaysSnapshot{snapshots}))
+
+	agg := &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}
+	if err := repo.Load(ctx, agg, NewRepoLoaderConfigurator().MaxVersion(4).Build()); err != nil {
+		t.Fatal(err)
+	}
+
+	// snapshot at version 2 plus events 2, 3 and 4
+	if agg.Count != 103 || agg.GetVersion() != 5 {
+		t.Fatalf("expected count 103 at version 5, got %d at %d", agg.Count, agg.GetVersion())
+	}
+	if snapshots.stored != 0 {
+		t.Fatal("point-in-time load must not store a snapshot")
+	}
+}
+
+func TestRepositoryLoadPointInTimeKeepsStaleSnapshots(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, incremented("a", 0, 9))
+
+	// an old revision 1 snapshot and a current revision 2 one
+	snapshots := newTestHistoryStore()
+	_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 2, Data: []byte(`{"legacy":true}`), Revision: 1})
+	_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 8, Data: []byte("200"), Revision: 2})
+
+	repo := NewRepository(WithEventStore(events), WithSnapshotStrategy(neverSnapshot{snapshots}), WithStaleSnapshotPurge())
+
+	agg := &revisedCounter{counter{DefaultAggregate: DefaultAggregate{ID: "a"}}}
+	if err := repo.Load(ctx, agg, NewRepoLoaderConfigurator().MaxVersion(4).Build()); err != nil {
+		t.Fatal(err)
+	}
+	if agg.Count != 5 || agg.GetVersion() != 5 {
+		t.Fatalf("expected full replay to count 5 at version 5, got %d at %d", agg.Count, agg.GetVersion())
+	}
+
+	if ss, _ := snapshots.Load(ctx, "a"); ss == nil || ss.Version != 8 {
+		t.Fatalf("expected the latest snapshot to survive a point-in-time load, got %+v", ss)
+	}
+}
+
+func timedEvents(aggID string, base time.Time, n uint64) []gosignal.Event {
+	events := incremented(aggID, 0, n-1)
+	for i := range events {
+		events[i].Timestamp = base.Add(time.Duration(i) * time.Minute)
+	}
+	return events
+}
+
+func TestRepositoryLoadAsOf(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	asOf := base.Add(4*time.Minute + 30*time.Second) // after version 4, before version 5
+
+	events := newTestEventStore()
+	_ = events.Store(ctx, timedEvents("a", base, 10))
+
+	t.Run("snapshot taken before the instant", func(t *testing.T) {
+		snapshots := newTestSnapshotStore()
+		_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 3, Data: []byte("100"), Timestamp: base.Add(3 * time.Minute)})
+		snapshots.stored = 0
+
+		repo := NewRepository(WithEventStore(events), WithSnapshotStrategy(alwaysSnapshot{snapshots}))
+		agg := &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}
+		if err := repo.LoadAsOf(ctx, agg, asOf); err != nil {
+			t.Fatal(err)
+		}
+
+		if agg.Count != 102 || agg.GetVersion() != 5 {
+			t.Fatalf("expected count 102 at version 5, got %d at %d", agg.Count, agg.GetVersion())
+		}
+		if snapshots.stored != 0 {
+			t.Fatal("LoadAsOf must not store snapshots")
+		}
+	})
+
+	t.Run("snapshot taken after the instant", func(t *testing.T) {
+		snapshots := newTestSnapshotStore()
+		_ = snapshots.Store(ctx, "a", Snapshot{ID: "a", Version: 8, Data: []byte("100"), Timestamp: base.Add(8 * time.Minute)})
+
+		repo := NewRepository(WithEventStore(events), WithSnapshotStrategy(alwaysSnapshot{snapshots}))
+		agg := &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}
+		if err := repo.LoadAsOf(ctx, agg, asOf); err != nil {
+			t.Fatal(err)
+		}
+
+		if agg.Count != 5 || agg.GetVersion() != 5 {
+			t.Fatalf("expected full replay to count 5 at version 5, got %d at %d", agg.Count, agg.GetVersion())
+		}
+	})
+
+	t.Run("before the first event", func(t *testing.T) {
+		repo := NewRepository(WithEventStore(events))
+		err := repo.LoadAsOf(ctx, &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}, base.Add(-time.Hour))
+		if !errors.Is(err, ErrNoEvents) {
+			t.Fatalf("expected ErrNoEvents, got %v", err)
+		}
+	})
+}
+
+func TestRepositoryAggregateTypesShareEventStore(t *testing.T) {
+	ctx := context.Background()
+	store := newTestEventStore()
+	orders := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateType("order"))
+	invoices := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateType("invoice"))
+
+	events := incremented("42", 0, 1)
+	if err := orders.Store(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	if events[0].AggregateType != "" {
+		t.Fatal("expected the caller's events to be left untouched")
+	}
+	if err := invoices.Store(ctx, incremented("42", 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	for repo, want := range map[*Repository]uint64{orders: 2, invoices: 1} {
+		c := &counter{}
+		c.SetID("42")
+		if err := repo.Load(ctx, c, nil); err != nil {
+			t.Fatal(err)
+		}
+		if c.GetVersion() != want {
+			t.Fatalf("%s: expected version %d, got %d", repo.aggregateType, want, c.GetVersion())
+		}
+	}
+
+	mismatched := incremented("42", 2, 2)
+	mismatched[0].AggregateType = "invoice"
+	if err := orders.Store(ctx, mismatched); !errors.Is(err, ErrAggregateTypeMismatch) {
+		t.Fatalf("expected ErrAggregateTypeMismatch, got %v", err)
+	}
+
+	replacement := gosignal.Event{Type: "incremented", Version: 0, AggregateID: "42", AggregateType: "invoice"}
+	if err := orders.ReplaceVersion(ctx, "42", &counter{}, 0, replacement); !errors.Is(err, ErrAggregateTypeMismatch) {
+		t.Fatalf("expected ErrAggregateTypeMismatch replacing with another type's event, got %v", err)
+	}
+}
+
+func TestRepositorySaveChecksExpectedVersion(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range map[string]EventStore{
+		"transactional":          newTestEventStore(),
+		"checked before storing": struct{ EventStore }{newTestEventStore()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateType("counter"))
+			if err := repo.Save(ctx, 0, incremented("a", 0, 1)); err != nil {
+				t.Fatal(err)
+			}
+
+			// a writer that loaded the aggregate at version 1 is behind
+			if err := repo.Save(ctx, 1, incremented("a", 1, 1)); !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("expected ErrVersionConflict, got %v", err)
+			}
+			if err := repo.Save(ctx, 2, incremented("a", 2, 2)); err != nil {
+				t.Fatal(err)
+			}
+
+			if events, _ := store.Load(ctx, "a", LoadEventsOptions{}); len(events) != 3 {
+				t.Fatalf("expected 3 events, got %d", len(events))
+			}
+		})
+	}
+}
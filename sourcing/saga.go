@@ -0,0 +1,789 @@
+package sourcing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// Event types recorded in the stream of every saga instance
+const (
+	SagaStateEventType          = "gosignal.saga.state"
+	SagaStepEventType           = "gosignal.saga.step"
+	SagaTimeoutSetEventType     = "gosignal.saga.timeout_set"
+	SagaTimeoutClearedEventType = "gosignal.saga.timeout_cleared"
+	SagaCompletedEventType      = "gosignal.saga.completed"
+	SagaAbortedEventType        = "gosignal.saga.aborted"
+	SagaOutboxEventType         = "gosignal.saga.outbox"
+	SagaDispatchedEventType     = "gosignal.saga.dispatched"
+)
+
+// ErrSagaCorrelation is the error returned when a message can't be correlated to a saga instance,
+// it is joined with the correlator's error if there is one
+var ErrSagaCorrelation = errors.New("could not correlate message to a saga")
+
+// ErrNoSagaRoute is the error returned when a saga receives a message type it doesn't handle
+var ErrNoSagaRoute = errors.New("saga does not handle message type")
+
+// ErrSagaRouteExists is the error returned when registering a second handler for a message type
+var ErrSagaRouteExists = errors.New("saga already handles message type")
+
+// ErrSagaRunning is the error returned when Run is called on a saga that is already running
+var ErrSagaRunning = errors.New("saga is already running")
+
+// ErrSagaCommand is the error returned when a command emitted by a saga fails, it is joined with
+// the command bus error
+var ErrSagaCommand = errors.New("saga command failed")
+
+// ErrSchedulingTimeout is the error returned when a timeout can't be scheduled, it is retried like
+// a failed command
+var ErrSchedulingTimeout = errors.New("could not schedule saga timeout")
+
+// SagaStatus is the lifecycle state of a saga instance
+type SagaStatus int
+
+const (
+	SagaActive SagaStatus = iota
+	SagaCompleted
+	SagaAborted
+)
+
+// Correlator extracts the id of the saga instance a message belongs to, typically a business key
+// such as the order id
+type Correlator func(msg []byte) (string, error)
+
+// SagaHandler reacts to a message for one saga instance. It changes sc.State and records steps,
+// commands and timeouts on sc, which are saved to the instance's stream before any command is sent.
+// Like an UpdateFunc it is called again after a version conflict.
+type SagaHandler[S any] func(ctx context.Context, sc *SagaContext[S], msg []byte) error
+
+// SagaTimeoutHandler reacts to a timeout set with SagaContext.SetTimeout expiring
+type SagaTimeoutHandler[S any] func(ctx context.Context, sc *SagaContext[S]) error
+
+// SagaErrorHandler receives errors from messages and timeouts handled in the background by Run
+type SagaErrorHandler func(sagaID string, err error)
+
+// SagaOption configures a Saga
+type SagaOption func(*sagaConfig)
+
+type sagaConfig struct {
+	onError     SagaErrorHandler
+	logger      *slog.Logger
+	scheduler   gosignal.ScheduledQueue
+	timeoutType string
+}
+
+// WithSagaErrorHandler sets the callback that receives errors from Run, failed messages are
+// nacked regardless
+func WithSagaErrorHandler(fn SagaErrorHandler) SagaOption {
+	return func(c *sagaConfig) {
+		c.onError = fn
+	}
+}
+
+// WithSagaLogger logs the errors from messages and timeouts handled in the background at error level,
+// in addition to the error handler
+func WithSagaLogger(logger *slog.Logger) SagaOption {
+	return func(c *sagaConfig) {
+		c.logger = logger
+	}
+}
+
+// WithSagaScheduler sends timeouts as messages of the given type through the scheduled queue instead
+// of keeping in-process timers, so they survive restarts. Run must receive a queue the scheduled
+// messages are published to, and the message type must be unique to the saga.
+func WithSagaScheduler(queue gosignal.ScheduledQueue, messageType string) SagaOption {
+	return func(c *sagaConfig) {
+		c.scheduler = queue
+		c.timeoutType = messageType
+	}
+}
+
+// Saga is a process manager coordinating a long-running workflow across aggregates. Each instance
+// is identified by a correlation key and its state S is event-sourced through the repository, so
+// the repository should be bound to an aggregate type of its own. S is stored as JSON.
+//
+// Messages are routed to instances by type; StartOn handlers may create an instance, On handlers
+// only see existing ones and messages for unknown or finished instances are ignored. Handlers emit
+// commands and may set timeouts. Steps recorded with StepCompleted are undone when the saga aborts
+// by sending the commands registered with Compensate, in reverse order.
+//
+// Commands are saved to the instance's outbox along with its events and dispatched through the
+// command bus afterwards, so they must survive a JSON round trip. A command that fails stops the
+// ones after it and is sent again with the instance's next message or when Run starts, commands are
+// therefore delivered at least once.
+//
+// Timeouts use in-process timers by default, see WithSagaScheduler for durable timeouts.
+// Handlers must be registered before Run is called.
+type Saga[S any] struct {
+	repo          *Repository
+	bus           *CommandBus
+	config        sagaConfig
+	routes        map[string]sagaRoute[S]
+	timeouts      map[string]SagaTimeoutHandler[S]
+	compensations map[string]func(state S) []Command
+
+	mu     sync.Mutex
+	runCtx context.Context
+	timers map[sagaTimerKey]*time.Timer
+}
+
+type sagaRoute[S any] struct {
+	correlate Correlator
+	handle    SagaHandler[S]
+	starts    bool
+}
+
+type sagaTimerKey struct {
+	id, name string
+}
+
+// NewSaga creates a saga storing its instances through repo and sending commands through bus
+func NewSaga[S any](repo *Repository, bus *CommandBus, options ...SagaOption) *Saga[S] {
+	s := &Saga[S]{
+		repo:          repo,
+		bus:           bus,
+		routes:        make(map[string]sagaRoute[S]),
+		timeouts:      make(map[string]SagaTimeoutHandler[S]),
+		compensations: make(map[string]func(state S) []Command),
+		timers:        make(map[sagaTimerKey]*time.Timer),
+	}
+	for _, option := range options {
+		option(&s.config)
+	}
+	return s
+}
+
+// StartOn registers a handler for a message type that starts new instances, it also receives the
+// message for instances that are already running
+func (s *Saga[S]) StartOn(messageType string, correlate Correlator, handler SagaHandler[S]) error {
+	return s.route(messageType, sagaRoute[S]{correlate: correlate, handle: handler, starts: true})
+}
+
+// On registers a handler for a message type, messages without a running instance are ignored
+func (s *Saga[S]) On(messageType string, correlate Correlator, handler SagaHandler[S]) error {
+	return s.route(messageType, sagaRoute[S]{correlate: correlate, handle: handler})
+}
+
+func (s *Saga[S]) route(messageType string, route sagaRoute[S]) error {
+	if _, ok := s.routes[messageType]; ok {
+		return errors.Join(ErrSagaRouteExists, fmt.Errorf("message type %s", messageType))
+	}
+	s.routes[messageType] = route
+	return nil
+}
+
+// OnTimeout registers the handler for the named timeout, timeouts without a handler only expire
+func (s *Saga[S]) OnTimeout(name string, handler SagaTimeoutHandler[S]) {
+	s.timeouts[name] = handler
+}
+
+// Compensate registers the commands undoing a step, they are built from the state at the time the
+// saga aborts
+func (s *Saga[S]) Compensate(step string, fn func(state S) []Command) {
+	s.compensations[step] = fn
+}
+
+// Load returns the state and status of a saga instance
+func (s *Saga[S]) Load(ctx context.Context, id string) (S, SagaStatus, error) {
+	inst := &sagaInstance[S]{}
+	inst.SetID(id)
+	err := s.repo.Load(ctx, inst, nil)
+	return inst.State, inst.Status, err
+}
+
+// HandleMessage routes a message to its saga instance, Run calls it for every message received
+// from the queue
+func (s *Saga[S]) HandleMessage(ctx context.Context, messageType string, msg []byte) error {
+	_, err := s.handle(ctx, messageType, msg)
+	return err
+}
+
+// handle routes a message to its saga instance and returns the instance's id, which is empty when
+// the message can't be correlated
+func (s *Saga[S]) handle(ctx context.Context, messageType string, msg []byte) (string, error) {
+	if s.config.scheduler != nil && messageType == s.config.timeoutType {
+		var timeout sagaTimeout
+		if err := json.Unmarshal(msg, &timeout); err != nil || timeout.ID == "" {
+			return "", errors.Join(ErrSagaCorrelation, fmt.Errorf("message type %s", messageType), err)
+		}
+		return timeout.ID, s.fireTimeout(ctx, timeout.ID, timeout.Name, timeout.Deadline)
+	}
+
+	route, ok := s.routes[messageType]
+	if !ok {
+		return "", errors.Join(ErrNoSagaRoute, fmt.Errorf("message type %s", messageType))
+	}
+
+	id, err := route.correlate(msg)
+	if err != nil || id == "" {
+		return "", errors.Join(ErrSagaCorrelation, fmt.Errorf("message type %s", messageType), err)
+	}
+
+	return id, s.process(ctx, id, route.starts, nil, func(ctx context.Context, sc *SagaContext[S]) error {
+		return route.handle(ctx, sc, msg)
+	})
+}
+
+// Run subscribes to every routed message type and handles messages until ctx is done. Messages are
+// acked once handled and nacked on error. In-process timeouts only fire while the saga runs; on
+// start, once subscribed, pending timeouts are rearmed and outboxes are sent if the event store is an
+// AggregateLister.
+func (s *Saga[S]) Run(ctx context.Context, queue gosignal.Queue) error {
+	s.mu.Lock()
+	if s.runCtx != nil {
+		s.mu.Unlock()
+		return ErrSagaRunning
+	}
+	s.runCtx = ctx
+	s.mu.Unlock()
+
+	defer s.stopTimers()
+
+	messageTypes := make([]string, 0, len(s.routes)+1)
+	for messageType := range s.routes {
+		messageTypes = append(messageTypes, messageType)
+	}
+
+	if s.config.scheduler != nil {
+		messageTypes = append(messageTypes, s.config.timeoutType)
+	}
+
+	type subscription struct {
+		messageType, id string
+		ch              chan gosignal.QueueMessage
+	}
+
+	var subscriptions []subscription
+	unsubscribe := func() error {
+		var errs []error
+		for _, sub := range subscriptions {
+			errs = append(errs, queue.Unsubscribe(sub.messageType, sub.id))
+		}
+		return errors.Join(errs...)
+	}
+
+	for _, messageType := range messageTypes {
+		id, ch, err := queue.Subscribe(messageType)
+		if err != nil {
+			return errors.Join(err, unsubscribe())
+		}
+		subscriptions = append(subscriptions, subscription{messageType: messageType, id: id, ch: ch})
+	}
+
+	// resuming after subscribing leaves no window in which replies to resent commands are missed
+	if err := s.resume(ctx); err != nil {
+		return errors.Join(err, unsubscribe())
+	}
+
+	var wg sync.WaitGroup
+	for _, sub := range subscriptions {
+		wg.Add(1)
+		go func(ch chan gosignal.QueueMessage) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg, ok := <-ch:
+					if !ok {
+						return
+					}
+					s.deliver(ctx, msg)
+				}
+			}
+		}(sub.ch)
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+
+	return unsubscribe()
+}
+
+// deliver handles a queue message and acknowledges it
+func (s *Saga[S]) deliver(ctx context.Context, msg gosignal.QueueMessage) {
+	id, err := s.handle(ctx, msg.Type(), msg.Message())
+	if err != nil {
+		s.reportError(ctx, id, err)
+		s.reportError(ctx, id, msg.Nack())
+		return
+	}
+	s.reportError(ctx, id, msg.Ack())
+}
+
+func (s *Saga[S]) reportError(ctx context.Context, id string, err error) {
+	if err == nil {
+		return
+	}
+
+	if s.config.logger != nil {
+		s.config.logger.LogAttrs(ctx, slog.LevelError, "saga failed", slog.String(gosignal.LogKeySagaID, id), slog.Any("error", err))
+	}
+	if s.config.onError != nil {
+		s.config.onError(id, err)
+	}
+}
+
+// process runs a handler against the saga instance, saves the outcome and then sends the outbox,
+// which still holds the entries earlier attempts failed to send
+func (s *Saga[S]) process(ctx context.Context, id string, start bool, guard func(*sagaInstance[S]) bool, handle func(context.Context, *SagaContext[S]) error) error {
+	var sc *SagaContext[S]
+	var outbox []sagaOutboxEntry
+
+	err := s.repo.Update(ctx, id, newSagaInstance[S], func(ctx context.Context, agg Aggregate) ([]gosignal.Event, error) {
+		inst := agg.(*sagaInstance[S])
+
+		sc, outbox = nil, inst.Outbox
+		if (inst.GetVersion() == 0 && !start) || inst.Status != SagaActive || (guard != nil && !guard(inst)) {
+			return nil, nil
+		}
+
+		sc = newSagaContext(inst)
+		if err := handle(ctx, sc); err != nil {
+			return nil, err
+		}
+
+		events, entries, err := s.decide(inst, sc)
+		outbox = append(slices.Clone(inst.Outbox), entries...)
+		return events, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if sc != nil && s.config.scheduler == nil {
+		for _, name := range sc.cleared {
+			s.disarmTimeout(id, name)
+		}
+		if sc.completed || sc.aborted {
+			s.disarmAll(id)
+		} else {
+			for name, deadline := range sc.set {
+				s.armTimeout(id, name, deadline)
+			}
+		}
+	}
+
+	return s.dispatchOutbox(ctx, id, outbox)
+}
+
+// dispatchOutbox sends the outbox entries in order and records the sent ones in the instance's
+// stream, it stops at the first failure so the rest is sent again later
+func (s *Saga[S]) dispatchOutbox(ctx context.Context, id string, outbox []sagaOutboxEntry) error {
+	var sent uint64
+	var sendErr error
+	for _, entry := range outbox {
+		if sendErr = s.send(ctx, id, entry); sendErr != nil {
+			break
+		}
+		sent = entry.Seq
+	}
+	if sent == 0 {
+		return sendErr
+	}
+
+	err := s.repo.Update(ctx, id, newSagaInstance[S], func(_ context.Context, agg Aggregate) ([]gosignal.Event, error) {
+		inst := agg.(*sagaInstance[S])
+		if len(inst.Outbox) == 0 || inst.Outbox[0].Seq > sent {
+			return nil, nil
+		}
+		return []gosignal.Event{{Type: SagaDispatchedEventType, Data: []byte(strconv.FormatUint(sent, 10))}}, nil
+	})
+
+	return errors.Join(sendErr, err)
+}
+
+// send schedules an outbox timeout or dispatches an outbox command
+func (s *Saga[S]) send(ctx context.Context, id string, entry sagaOutboxEntry) error {
+	if entry.Timeout != nil {
+		// timeouts left over from running with a scheduler, in-process timers are armed separately
+		if s.config.scheduler == nil {
+			return nil
+		}
+
+		data, err := json.Marshal(entry.Timeout)
+		if err == nil {
+			err = s.config.scheduler.SendAt(s.config.timeoutType, data, entry.Timeout.Deadline)
+		}
+		if err != nil {
+			return errors.Join(ErrSchedulingTimeout, fmt.Errorf("saga %s timeout %s", id, entry.Timeout.Name), err)
+		}
+		return nil
+	}
+
+	cmd, err := s.bus.decodeCommand(entry.Command, entry.Data)
+	if err == nil {
+		err = s.bus.Dispatch(ctx, cmd)
+	}
+	if err != nil {
+		return errors.Join(ErrSagaCommand, fmt.Errorf("saga %s command %s", id, entry.Command), err)
+	}
+	return nil
+}
+
+// decide turns what the handler recorded into events for the instance's stream, and returns the
+// commands and scheduled timeouts to add to the outbox. Compensation commands for an abort are
+// appended to the context's commands.
+func (s *Saga[S]) decide(inst *sagaInstance[S], sc *SagaContext[S]) ([]gosignal.Event, []sagaOutboxEntry, error) {
+	var events []gosignal.Event
+
+	before, err := json.Marshal(inst.State)
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := json.Marshal(sc.State)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inst.GetVersion() == 0 || !bytes.Equal(before, after) {
+		events = append(events, gosignal.Event{Type: SagaStateEventType, Data: after})
+	}
+
+	for _, step := range sc.steps {
+		events = append(events, gosignal.Event{Type: SagaStepEventType, Data: []byte(step)})
+	}
+
+	for _, name := range sc.cleared {
+		if _, ok := inst.Timeouts[name]; ok {
+			events = append(events, gosignal.Event{Type: SagaTimeoutClearedEventType, Data: []byte(name)})
+		}
+	}
+
+	names := make([]string, 0, len(sc.set))
+	for name := range sc.set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var entries []sagaOutboxEntry
+	for _, name := range names {
+		timeout := sagaTimeout{ID: inst.ID, Name: name, Deadline: sc.set[name]}
+		data, err := json.Marshal(timeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, gosignal.Event{Type: SagaTimeoutSetEventType, Data: data})
+
+		if s.config.scheduler != nil && !sc.completed && !sc.aborted {
+			entries = append(entries, sagaOutboxEntry{Timeout: &timeout})
+		}
+	}
+
+	switch {
+	case sc.aborted:
+		events = append(events, gosignal.Event{Type: SagaAbortedEventType, Data: []byte(sc.reason)})
+
+		steps := append(append([]string{}, inst.Steps...), sc.steps...)
+		for i := len(steps) - 1; i >= 0; i-- {
+			if compensate, ok := s.compensations[steps[i]]; ok {
+				sc.commands = append(sc.commands, compensate(sc.State)...)
+			}
+		}
+	case sc.completed:
+		events = append(events, gosignal.Event{Type: SagaCompletedEventType})
+	}
+
+	for _, cmd := range sc.commands {
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			return nil, nil, errors.Join(ErrSagaCommand, fmt.Errorf("saga %s command %T", inst.ID, cmd), err)
+		}
+		entries = append(entries, sagaOutboxEntry{Command: commandName(reflect.TypeOf(cmd)), Data: data})
+	}
+
+	if len(entries) > 0 {
+		for i := range entries {
+			entries[i].Seq = inst.OutboxSeq + uint64(i) + 1
+		}
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, gosignal.Event{Type: SagaOutboxEventType, Data: data})
+	}
+
+	return events, entries, nil
+}
+
+// armTimeout starts the in-process timer for a timeout, timers are only started while the saga is
+// running
+func (s *Saga[S]) armTimeout(id, name string, deadline time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.runCtx == nil {
+		return
+	}
+
+	key := sagaTimerKey{id: id, name: name}
+	if timer, ok := s.timers[key]; ok {
+		timer.Stop()
+	}
+
+	ctx := s.runCtx
+	s.timers[key] = time.AfterFunc(time.Until(deadline), func() {
+		s.mu.Lock()
+		delete(s.timers, key)
+		s.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		s.reportError(ctx, id, s.fireTimeout(ctx, id, name, deadline))
+	})
+}
+
+func (s *Saga[S]) disarmTimeout(id, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sagaTimerKey{id: id, name: name}
+	if timer, ok := s.timers[key]; ok {
+		timer.Stop()
+		delete(s.timers, key)
+	}
+}
+
+func (s *Saga[S]) disarmAll(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, timer := range s.timers {
+		if key.id == id {
+			timer.Stop()
+			delete(s.timers, key)
+		}
+	}
+}
+
+func (s *Saga[S]) stopTimers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, timer := range s.timers {
+		timer.Stop()
+		delete(s.timers, key)
+	}
+	s.runCtx = nil
+}
+
+// fireTimeout clears an expired timeout and runs its handler, unless the timeout was cleared or
+// set again in the meantime
+func (s *Saga[S]) fireTimeout(ctx context.Context, id, name string, deadline time.Time) error {
+	guard := func(inst *sagaInstance[S]) bool {
+		current, ok := inst.Timeouts[name]
+		return ok && current.Equal(deadline)
+	}
+
+	return s.process(ctx, id, false, guard, func(ctx context.Context, sc *SagaContext[S]) error {
+		sc.ClearTimeout(name)
+		if handler, ok := s.timeouts[name]; ok {
+			return handler(ctx, sc)
+		}
+		return nil
+	})
+}
+
+// resume arms the in-process timeouts and sends the outbox of every instance in the event store,
+// outboxes that fail to send are reported and left for the instance's next message
+func (s *Saga[S]) resume(ctx context.Context) error {
+	ids, err := s.instanceIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		inst := &sagaInstance[S]{}
+		inst.SetID(id)
+		if err := s.repo.Load(ctx, inst, nil); err != nil {
+			if errors.Is(err, ErrNoEvents) || errors.Is(err, ErrAggregateDeleted) {
+				continue
+			}
+			return err
+		}
+
+		if s.config.scheduler == nil {
+			for name, deadline := range inst.Timeouts {
+				s.armTimeout(id, name, deadline)
+			}
+		}
+		s.reportError(ctx, id, s.dispatchOutbox(ctx, id, inst.Outbox))
+	}
+
+	return nil
+}
+
+// instanceIDs lists the aggregates of the saga's type, or every aggregate when the repository has
+// no aggregate type or the store can't list by type
+func (s *Saga[S]) instanceIDs(ctx context.Context) ([]string, error) {
+	if lister, ok := s.repo.eventStore.(TypedAggregateLister); ok && s.repo.aggregateType != "" {
+		return lister.AggregateIDsOfType(ctx, s.repo.aggregateType)
+	}
+	if lister, ok := s.repo.eventStore.(AggregateLister); ok {
+		return lister.AggregateIDs(ctx)
+	}
+	return nil, nil
+}
+
+// SagaContext is passed to saga handlers to read and change one saga instance
+type SagaContext[S any] struct {
+	ID    string // correlation key of the instance
+	State S      // state of the instance, changes are saved after the handler returns
+
+	isNew     bool
+	steps     []string
+	commands  []Command
+	set       map[string]time.Time
+	cleared   []string
+	completed bool
+	aborted   bool
+	reason    string
+}
+
+func newSagaContext[S any](inst *sagaInstance[S]) *SagaContext[S] {
+	return &SagaContext[S]{
+		ID:    inst.ID,
+		State: inst.State,
+		isNew: inst.GetVersion() == 0,
+		set:   make(map[string]time.Time),
+	}
+}
+
+// IsNew reports whether the message starts the instance
+func (sc *SagaContext[S]) IsNew() bool {
+	return sc.isNew
+}
+
+// Send queues commands to dispatch once the instance is saved
+func (sc *SagaContext[S]) Send(commands ...Command) {
+	sc.commands = append(sc.commands, commands...)
+}
+
+// StepCompleted records a step whose compensation runs if the saga aborts later
+func (sc *SagaContext[S]) StepCompleted(step string) {
+	sc.steps = append(sc.steps, step)
+}
+
+// SetTimeout sets the named timeout to expire after d, replacing an earlier one with the same name
+func (sc *SagaContext[S]) SetTimeout(name string, d time.Duration) {
+	sc.set[name] = time.Now().Add(d)
+}
+
+// ClearTimeout cancels the named timeout
+func (sc *SagaContext[S]) ClearTimeout(name string) {
+	delete(sc.set, name)
+	sc.cleared = append(sc.cleared, name)
+}
+
+// Complete ends the saga successfully, later messages for the instance are ignored
+func (sc *SagaContext[S]) Complete() {
+	sc.completed = true
+}
+
+// Abort ends the saga and sends the compensation commands of all completed steps
+func (sc *SagaContext[S]) Abort(reason string) {
+	sc.aborted = true
+	sc.reason = reason
+}
+
+// sagaTimeout is the payload of SagaTimeoutSetEventType and of scheduled timeout messages
+type sagaTimeout struct {
+	ID       string    `json:"id,omitempty"`
+	Name     string    `json:"name"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// sagaOutboxEntry is a command or scheduled timeout waiting to be sent, Data is the JSON encoding of
+// the command recorded under its type name
+type sagaOutboxEntry struct {
+	Seq     uint64          `json:"seq"`
+	Command string          `json:"command,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Timeout *sagaTimeout    `json:"timeout,omitempty"`
+}
+
+// sagaInstance is the event-sourced aggregate behind one saga instance
+type sagaInstance[S any] struct {
+	DefaultAggregate
+	sagaInstanceState[S]
+}
+
+func newSagaInstance[S any]() Aggregate {
+	return &sagaInstance[S]{}
+}
+
+type sagaInstanceState[S any] struct {
+	State     S                    `json:"state"`
+	Status    SagaStatus           `json:"status"`
+	Steps     []string             `json:"steps,omitempty"`
+	Timeouts  map[string]time.Time `json:"timeouts,omitempty"`
+	Outbox    []sagaOutboxEntry    `json:"outbox,omitempty"`
+	OutboxSeq uint64               `json:"outbox_seq,omitempty"`
+}
+
+func (i *sagaInstance[S]) Apply(event gosignal.Event) error {
+	return SafeApply(event, i, func(event gosignal.Event) error {
+		switch event.Type {
+		case SagaStateEventType:
+			var state S
+			if err := json.Unmarshal(event.Data, &state); err != nil {
+				return err
+			}
+			i.State = state
+		case SagaStepEventType:
+			i.Steps = append(i.Steps, string(event.Data))
+		case SagaTimeoutSetEventType:
+			var timeout sagaTimeout
+			if err := json.Unmarshal(event.Data, &timeout); err != nil {
+				return err
+			}
+			if i.Timeouts == nil {
+				i.Timeouts = make(map[string]time.Time)
+			}
+			i.Timeouts[timeout.Name] = timeout.Deadline
+		case SagaTimeoutClearedEventType:
+			delete(i.Timeouts, string(event.Data))
+		case SagaCompletedEventType:
+			i.Status = SagaCompleted
+			i.Timeouts = nil
+		case SagaAbortedEventType:
+			i.Status = SagaAborted
+			i.Timeouts = nil
+		case SagaOutboxEventType:
+			var entries []sagaOutboxEntry
+			if err := json.Unmarshal(event.Data, &entries); err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				i.Outbox = append(i.Outbox, entry)
+				i.OutboxSeq = entry.Seq
+			}
+		case SagaDispatchedEventType:
+			sent, err := strconv.ParseUint(string(event.Data), 10, 64)
+			if err != nil {
+				return err
+			}
+			i.Outbox = slices.DeleteFunc(slices.Clone(i.Outbox), func(entry sagaOutboxEntry) bool { return entry.Seq <= sent })
+		}
+		return nil
+	})
+}
+
+func (i *sagaInstance[S]) ImportState(data []byte) error {
+	return json.Unmarshal(data, &i.sagaInstanceState)
+}
+
+func (i *sagaInstance[S]) ExportState() ([]byte, error) {
+	return json.Marshal(i.sagaInstanceState)
+}
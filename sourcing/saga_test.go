@@ -0,0 +1,506 @@
+package sourcing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// chanQueue is a gosignal.Queue delivering sent messages to a buffered channel per message type
+type chanQueue struct {
+	mu    sync.Mutex
+	subs  map[string]chan gosignal.QueueMessage
+	acked []string
+}
+
+type chanMessage struct {
+	queue   *chanQueue
+	mType   string
+	message []byte
+}
+
+func (m *chanMessage) Attempts() int                    { return 0 }
+func (m *chanMessage) Message() []byte                  { return m.message }
+func (m *chanMessage) Nack() error                      { return nil }
+func (m *chanMessage) Retry(gosignal.RetryParams) error { return nil }
+func (m *chanMessage) Type() string                     { return m.mType }
+
+func (m *chanMessage) Ack() error {
+	m.queue.mu.Lock()
+	defer m.queue.mu.Unlock()
+	m.queue.acked = append(m.queue.acked, m.mType)
+	return nil
+}
+
+func (q *chanQueue) Send(messageType string, message []byte) error {
+	q.mu.Lock()
+	ch, ok := q.subs[messageType]
+	q.mu.Unlock()
+	if ok {
+		ch <- &chanMessage{queue: q, mType: messageType, message: message}
+	}
+	return nil
+}
+
+func (q *chanQueue) Subscribe(messageType string) (string, chan gosignal.QueueMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.subs == nil {
+		q.subs = make(map[string]chan gosignal.QueueMessage)
+	}
+	q.subs[messageType] = make(chan gosignal.QueueMessage, 16)
+	return messageType, q.subs[messageType], nil
+}
+
+func (q *chanQueue) Unsubscribe(messageType, _ string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.subs, messageType)
+	return nil
+}
+
+// orderSagaState is the state of the test saga
+type orderSagaState struct {
+	OrderID string
+	Paid    bool
+}
+
+type orderMessage struct {
+	OrderID string
+}
+
+func correlateOrder(msg []byte) (string, error) {
+	var m orderMessage
+	err := json.Unmarshal(msg, &m)
+	return m.OrderID, err
+}
+
+func orderMsg(id string) []byte {
+	data, _ := json.Marshal(orderMessage{OrderID: id})
+	return data
+}
+
+// note is a command recording its name, the saga tests use it to observe sent commands
+type note struct {
+	ID   string
+	Name string
+}
+
+func (n note) AggregateID() string { return n.ID }
+
+type noteRecorder struct {
+	mu    sync.Mutex
+	names []string
+	fail  string // name of the next note to fail
+}
+
+func (r *noteRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.names...)
+}
+
+func newOrderSaga(t *testing.T, store EventStore, options ...SagaOption) (*Saga[orderSagaState], *noteRecorder) {
+	t.Helper()
+
+	recorder := &noteRecorder{}
+	bus := NewCommandBus(NewRepository(WithEventStore(newTestEventStore()), WithQueue(&testQueue{})))
+	err := Handle(bus, newCounter, func(_ context.Context, cmd note, _ *counter) ([]gosignal.Event, error) {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		if cmd.Name == recorder.fail {
+			recorder.fail = ""
+			return nil, errors.New("note failed")
+		}
+		recorder.names = append(recorder.names, cmd.Name)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateType("order_saga"))
+	saga := NewSaga[orderSagaState](repo, bus, options...)
+
+	err = errors.Join(
+		saga.StartOn("order.placed", correlateOrder, func(_ context.Context, sc *SagaContext[orderSagaState], _ []byte) error {
+			sc.State.OrderID = sc.ID
+			sc.Send(note{ID: sc.ID, Name: "reserve stock"})
+			sc.StepCompleted("stock")
+			sc.SetTimeout("payment", 200*time.Millisecond)
+			return nil
+		}),
+		saga.On("payment.charged", correlateOrder, func(_ context.Context, sc *SagaContext[orderSagaState], _ []byte) error {
+			sc.State.Paid = true
+			sc.ClearTimeout("payment")
+			sc.Send(note{ID: sc.ID, Name: "ship"})
+			sc.StepCompleted("payment")
+			return nil
+		}),
+		saga.On("shipping.failed", correlateOrder, func(_ context.Context, sc *SagaContext[orderSagaState], _ []byte) error {
+			sc.Abort("shipping failed")
+			return nil
+		}),
+		saga.On("order.delivered", correlateOrder, func(_ context.Context, sc *SagaContext[orderSagaState], _ []byte) error {
+			sc.Complete()
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saga.OnTimeout("payment", func(_ context.Context, sc *SagaContext[orderSagaState]) error {
+		sc.Abort("payment timed out")
+		return nil
+	})
+	saga.Compensate("stock", func(state orderSagaState) []Command {
+		return []Command{note{ID: state.OrderID, Name: "release stock"}}
+	})
+	saga.Compensate("payment", func(state orderSagaState) []Command {
+		return []Command{note{ID: state.OrderID, Name: "refund"}}
+	})
+
+	return saga, recorder
+}
+
+func TestSagaHandlesMessagesUntilComplete(t *testing.T) {
+	ctx := context.Background()
+	saga, recorder := newOrderSaga(t, newTestEventStore())
+
+	if err := saga.HandleMessage(ctx, "payment.charged", orderMsg("o1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := saga.Load(ctx, "o1"); !errors.Is(err, ErrNoEvents) {
+		t.Fatalf("expected messages without a running instance to be ignored, got %v", err)
+	}
+
+	for _, messageType := range []string{"order.placed", "payment.charged", "order.delivered", "shipping.failed"} {
+		if err := saga.HandleMessage(ctx, messageType, orderMsg("o1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state, status, err := saga.Load(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Paid || state.OrderID != "o1" || status != SagaCompleted {
+		t.Fatalf("unexpected saga: %+v, status %d", state, status)
+	}
+	if got := recorder.get(); len(got) != 2 || got[0] != "reserve stock" || got[1] != "ship" {
+		t.Fatalf("expected messages after completion to be ignored, sent %v", got)
+	}
+}
+
+func TestSagaAbortRunsCompensationsInReverse(t *testing.T) {
+	ctx := context.Background()
+	saga, recorder := newOrderSaga(t, newTestEventStore())
+
+	for _, messageType := range []string{"order.placed", "payment.charged", "shipping.failed"} {
+		if err := saga.HandleMessage(ctx, messageType, orderMsg("o1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"reserve stock", "ship", "refund", "release stock"}
+	got := recorder.get()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+	if _, status, _ := saga.Load(ctx, "o1"); status != SagaAborted {
+		t.Fatalf("expected the saga to be aborted, got status %d", status)
+	}
+}
+
+func TestSagaRedeliversFailedCommands(t *testing.T) {
+	ctx := context.Background()
+	saga, recorder := newOrderSaga(t, newTestEventStore())
+
+	if err := saga.HandleMessage(ctx, "order.placed", orderMsg("o1")); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.fail = "ship"
+	if err := saga.HandleMessage(ctx, "payment.charged", orderMsg("o1")); !errors.Is(err, ErrSagaCommand) {
+		t.Fatalf("expected ErrSagaCommand, got %v", err)
+	}
+	if state, _, _ := saga.Load(ctx, "o1"); !state.Paid {
+		t.Fatal("expected the instance to be saved before its commands are sent")
+	}
+
+	// the failed command is sent before the next message's commands
+	if err := saga.HandleMessage(ctx, "shipping.failed", orderMsg("o1")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"reserve stock", "ship", "refund", "release stock"}
+	if got := recorder.get(); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// a finished instance's outbox is empty, redelivered messages send nothing
+	if err := saga.HandleMessage(ctx, "shipping.failed", orderMsg("o1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.get(); len(got) != len(want) {
+		t.Fatalf("expected commands to be sent once, got %v", got)
+	}
+}
+
+// listingStore records how many message types were subscribed to when the saga instances were
+// listed
+type listingStore struct {
+	*loadRecordingStore
+	queue      *chanQueue
+	subscribed int
+}
+
+func (s *listingStore) AggregateIDsOfType(ctx context.Context, aggregateType string) ([]string, error) {
+	s.queue.mu.Lock()
+	s.subscribed = len(s.queue.subs)
+	s.queue.mu.Unlock()
+	return s.loadRecordingStore.AggregateIDsOfType(ctx, aggregateType)
+}
+
+func TestSagaRunSendsPendingCommands(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := &chanQueue{}
+	store := &listingStore{loadRecordingStore: &loadRecordingStore{testEventStore: newTestEventStore()}, queue: queue}
+	_ = store.Store(ctx, []gosignal.Event{{Type: "incremented", AggregateID: "other", AggregateType: "counter"}})
+
+	saga, recorder := newOrderSaga(t, store)
+	recorder.fail = "reserve stock"
+	if err := saga.HandleMessage(ctx, "order.placed", orderMsg("o1")); !errors.Is(err, ErrSagaCommand) {
+		t.Fatalf("expected ErrSagaCommand, got %v", err)
+	}
+
+	store.loaded = nil
+	done := make(chan error)
+	go func() { done <- saga.Run(ctx, queue) }()
+
+	waitFor(t, func() bool { return len(recorder.get()) == 1 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if store.subscribed != 4 {
+		t.Fatalf("expected every message type to be subscribed to before resuming, got %d", store.subscribed)
+	}
+
+	if slices.Contains(store.loaded, "other") {
+		t.Fatalf("expected only saga instances to be loaded, loaded %v", store.loaded)
+	}
+}
+
+func TestSagaRejectsUnroutableMessages(t *testing.T) {
+	saga, _ := newOrderSaga(t, newTestEventStore())
+
+	if err := saga.HandleMessage(context.Background(), "unknown", orderMsg("o1")); !errors.Is(err, ErrNoSagaRoute) {
+		t.Fatalf("expected ErrNoSagaRoute, got %v", err)
+	}
+	if err := saga.HandleMessage(context.Background(), "order.placed", []byte("{}")); !errors.Is(err, ErrSagaCorrelation) {
+		t.Fatalf("expected ErrSagaCorrelation, got %v", err)
+	}
+	if err := saga.On("order.placed", correlateOrder, nil); !errors.Is(err, ErrSagaRouteExists) {
+		t.Fatalf("expected ErrSagaRouteExists, got %v", err)
+	}
+}
+
+func TestSagaRunDeliversMessagesAndFiresTimeouts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newTestEventStore()
+	saga, recorder := newOrderSaga(t, store, WithSagaErrorHandler(func(id string, err error) {
+		t.Errorf("saga %s: %v", id, err)
+	}))
+
+	// a saga started while nothing was running has its timeout rearmed by Run
+	if err := saga.HandleMessage(ctx, "order.placed", orderMsg("o1")); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := &chanQueue{}
+	done := make(chan error)
+	go func() { done <- saga.Run(ctx, queue) }()
+
+	waitFor(t, func() bool {
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		return len(queue.subs) == 4
+	})
+	if err := queue.Send("order.placed", orderMsg("o2")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, _, err := saga.Load(ctx, "o2")
+		return err == nil
+	})
+	if err := queue.Send("payment.charged", orderMsg("o2")); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		_, status, _ := saga.Load(ctx, "o1")
+		return status == SagaAborted
+	})
+	waitFor(t, func() bool {
+		state, _, _ := saga.Load(ctx, "o2")
+		return state.Paid
+	})
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, status, _ := saga.Load(context.Background(), "o2"); status != SagaActive {
+		t.Fatalf("expected the cleared timeout not to abort o2, got status %d", status)
+	}
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if len(queue.acked) != 2 {
+		t.Fatalf("expected both handled messages to be acked, acked %v", queue.acked)
+	}
+
+	var released int
+	for _, name := range recorder.get() {
+		if name == "release stock" {
+			released++
+		}
+	}
+	if released != 1 {
+		t.Fatalf("expected stock to be released once, sent %v", recorder.get())
+	}
+}
+
+func TestSagaReportsDeliveryErrorsWithID(t *testing.T) {
+	type report struct {
+		id  string
+		err error
+	}
+	var reports []report
+	saga, recorder := newOrderSaga(t, newTestEventStore(), WithSagaErrorHandler(func(id string, err error) {
+		reports = append(reports, report{id: id, err: err})
+	}))
+	queue := &chanQueue{}
+
+	recorder.fail = "reserve stock"
+	saga.deliver(context.Background(), &chanMessage{queue: queue, mType: "order.placed", message: orderMsg("o1")})
+	saga.deliver(context.Background(), &chanMessage{queue: queue, mType: "unknown", message: orderMsg("o1")})
+
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 reported errors, got %+v", reports)
+	}
+	if reports[0].id != "o1" || !errors.Is(reports[0].err, ErrSagaCommand) {
+		t.Fatalf("expected the failed command to be reported for o1, got %+v", reports[0])
+	}
+	if reports[1].id != "" || !errors.Is(reports[1].err, ErrNoSagaRoute) {
+		t.Fatalf("expected an uncorrelated message to be reported without an id, got %+v", reports[1])
+	}
+	if len(queue.acked) != 0 {
+		t.Fatalf("expected failed messages not to be acked, acked %v", queue.acked)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// scheduledChanQueue records messages sent with SendAt instead of delivering them
+type scheduledChanQueue struct {
+	chanQueue
+	pending   []gosignal.ScheduledMessage
+	sendAtErr error
+}
+
+func (q *scheduledChanQueue) SendAt(messageType string, message []byte, at time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.sendAtErr != nil {
+		return q.sendAtErr
+	}
+	q.pending = append(q.pending, gosignal.ScheduledMessage{Type: messageType, Message: message, DueAt: at})
+	return nil
+}
+
+func TestSagaScheduledTimeouts(t *testing.T) {
+	ctx := context.Background()
+	queue := &scheduledChanQueue{}
+	saga, recorder := newOrderSaga(t, newTestEventStore(), WithSagaScheduler(queue, "order_saga.timeout"))
+
+	for _, id := range []string{"o1", "o2"} {
+		if err := saga.HandleMessage(ctx, "order.placed", orderMsg(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := saga.HandleMessage(ctx, "payment.charged", orderMsg("o2")); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.pending) != 2 || queue.pending[0].Type != "order_saga.timeout" {
+		t.Fatalf("expected a scheduled timeout per instance, got %+v", queue.pending)
+	}
+
+	// timeout messages are handled directly, a cleared timeout arriving late is ignored
+	for _, msg := range queue.pending {
+		if err := saga.HandleMessage(ctx, msg.Type, msg.Message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, status, _ := saga.Load(ctx, "o1"); status != SagaAborted {
+		t.Fatalf("expected o1 to time out, got status %d", status)
+	}
+	if _, status, _ := saga.Load(ctx, "o2"); status != SagaActive {
+		t.Fatalf("expected the cleared timeout not to abort o2, got status %d", status)
+	}
+	if got := recorder.get(); got[len(got)-1] != "release stock" {
+		t.Fatalf("expected the timeout to compensate, sent %v", got)
+	}
+}
+
+func TestSagaRetriesFailedScheduling(t *testing.T) {
+	ctx := context.Background()
+	queue := &scheduledChanQueue{sendAtErr: errors.New("unavailable")}
+	saga, recorder := newOrderSaga(t, newTestEventStore(), WithSagaScheduler(queue, "order_saga.timeout"))
+
+	if err := saga.HandleMessage(ctx, "order.placed", orderMsg("o1")); !errors.Is(err, ErrSchedulingTimeout) {
+		t.Fatalf("expected ErrSchedulingTimeout, got %v", err)
+	}
+	if got := recorder.get(); len(got) != 0 {
+		t.Fatalf("expected commands to wait for the timeout, sent %v", got)
+	}
+
+	// the timeout and the command are sent with the instance's next message
+	queue.sendAtErr = nil
+	if err := saga.HandleMessage(ctx, "order.delivered", orderMsg("o1")); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.pending) != 1 {
+		t.Fatalf("expected the timeout to be scheduled, got %+v", queue.pending)
+	}
+	if got := recorder.get(); !slices.Equal(got, []string{"reserve stock"}) {
+		t.Fatalf("expected the command to be sent, got %v", got)
+	}
+}
@@ -0,0 +1,193 @@
+package sourcing
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// ErrUnregisteredPayload is the error returned when a payload's Go type has no registered event type
+var ErrUnregisteredPayload = errors.New("unregistered event payload")
+
+// ErrEventTypeConflict is the error returned when an event type or Go type is registered twice with
+// different counterparts
+var ErrEventTypeConflict = errors.New("event type already registered")
+
+// GobSerializer serializes payloads with encoding/gob
+type GobSerializer struct{}
+
+func (GobSerializer) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobSerializer) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// TypeRegistry maps Go payload types to event type names, building gosignal.Event values from
+// payloads and decoding them back with its Serializer
+type TypeRegistry struct {
+	mu         sync.RWMutex
+	serializer Serializer
+	byName     map[string]reflect.Type
+	byType     map[reflect.Type]string
+	upcasters  *UpcasterChain
+	now        func() time.Time
+}
+
+// NewTypeRegistry creates a registry using the serializer, JSONSerializer if nil
+func NewTypeRegistry(serializer Serializer) *TypeRegistry {
+	if serializer == nil {
+		serializer = JSONSerializer{}
+	}
+
+	return &TypeRegistry{
+		serializer: serializer,
+		byName:     make(map[string]reflect.Type),
+		byType:     make(map[reflect.Type]string),
+		now:        time.Now,
+	}
+}
+
+// Serializer returns the serializer of the registry
+func (tr *TypeRegistry) Serializer() Serializer {
+	return tr.serializer
+}
+
+// SetUpcasters makes NewEvent write events at the chain's current schema version of their type
+func (tr *TypeRegistry) SetUpcasters(chain *UpcasterChain) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.upcasters = chain
+}
+
+// Register registers T under the event type named by T, see EventTypeOf
+func Register[T any](tr *TypeRegistry) error {
+	return RegisterAs[T](tr, EventTypeOf[T]())
+}
+
+// RegisterAs registers T under the given event type
+func RegisterAs[T any](tr *TypeRegistry, eventType string) error {
+	t := payloadType(reflect.TypeOf((*T)(nil)).Elem())
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if existing, ok := tr.byName[eventType]; ok && existing != t {
+		return errors.Join(ErrEventTypeConflict, fmt.Errorf("%q is registered to %s", eventType, existing))
+	}
+	if existing, ok := tr.byType[t]; ok && existing != eventType {
+		return errors.Join(ErrEventTypeConflict, fmt.Errorf("%s is registered as %q", t, existing))
+	}
+
+	tr.byName[eventType] = t
+	tr.byType[t] = eventType
+
+	return nil
+}
+
+// NameOf returns the event type registered for the payload's Go type
+func (tr *TypeRegistry) NameOf(payload any) (string, error) {
+	if payload == nil {
+		return "", ErrUnregisteredPayload
+	}
+	return tr.nameOfType(reflect.TypeOf(payload))
+}
+
+func (tr *TypeRegistry) nameOfType(t reflect.Type) (string, error) {
+	t = payloadType(t)
+
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+
+	name, ok := tr.byType[t]
+	if !ok {
+		return "", errors.Join(ErrUnregisteredPayload, fmt.Errorf("type %s", t))
+	}
+	return name, nil
+}
+
+// NewEvent builds an event for the aggregate from a registered payload, filling Type, Data,
+// Timestamp and, with SetUpcasters, SchemaVersion
+func (tr *TypeRegistry) NewEvent(aggregateID string, version uint64, payload any) (gosignal.Event, error) {
+	name, err := tr.NameOf(payload)
+	if err != nil {
+		return gosignal.Event{}, err
+	}
+
+	data, err := tr.serializer.Marshal(payload)
+	if err != nil {
+		return gosignal.Event{}, err
+	}
+
+	event := gosignal.Event{
+		Type:        name,
+		Data:        data,
+		Version:     version,
+		Timestamp:   tr.now(),
+		AggregateID: aggregateID,
+	}
+
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	if tr.upcasters != nil {
+		event.SchemaVersion = tr.upcasters.CurrentVersion(name)
+	}
+
+	return event, nil
+}
+
+// Decode decodes the event's data into a new value of the Go type registered for its type
+func (tr *TypeRegistry) Decode(event gosignal.Event) (any, error) {
+	tr.mu.RLock()
+	t, ok := tr.byName[event.Type]
+	tr.mu.RUnlock()
+
+	if !ok {
+		return nil, errors.Join(ErrUnknownEventType, fmt.Errorf("event type: %q", event.Type))
+	}
+
+	v := reflect.New(t)
+	if err := tr.serializer.Unmarshal(event.Data, v.Interface()); err != nil {
+		return nil, errors.Join(ErrDecodingEvent, err)
+	}
+
+	return v.Elem().Interface(), nil
+}
+
+// DecodeAs decodes the event's data into T, checking that T is registered for the event's type
+func DecodeAs[T any](tr *TypeRegistry, event gosignal.Event) (T, error) {
+	var payload T
+
+	name, err := tr.nameOfType(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return payload, err
+	}
+	if name != event.Type {
+		return payload, errors.Join(ErrUnknownEventType, fmt.Errorf("event type %q is not %q", event.Type, name))
+	}
+
+	if err := tr.serializer.Unmarshal(event.Data, &payload); err != nil {
+		return payload, errors.Join(ErrDecodingEvent, err)
+	}
+
+	return payload, nil
+}
+
+// payloadType strips pointers so T and *T share a registration
+func payloadType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
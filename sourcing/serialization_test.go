@@ -0,0 +1,107 @@
+package sourcing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTypeRegistryRoundTrip(t *testing.T) {
+	for name, serializer := range map[string]Serializer{"json": JSONSerializer{}, "gob": GobSerializer{}} {
+		t.Run(name, func(t *testing.T) {
+			tr := NewTypeRegistry(serializer)
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			tr.now = func() time.Time { return now }
+
+			if err := Register[orderPlaced](tr); err != nil {
+				t.Fatal(err)
+			}
+			if err := Register[orderShipped](tr); err != nil {
+				t.Fatal(err)
+			}
+
+			event, err := tr.NewEvent("o-1", 4, orderShipped{Carrier: "post"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != "order.shipped" || event.Version != 4 || event.AggregateID != "o-1" || !event.Timestamp.Equal(now) {
+				t.Fatalf("unexpected event: %+v", event)
+			}
+
+			decoded, err := tr.Decode(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded != (orderShipped{Carrier: "post"}) {
+				t.Fatalf("unexpected payload: %#v", decoded)
+			}
+
+			typed, err := DecodeAs[orderShipped](tr, event)
+			if err != nil || typed.Carrier != "post" {
+				t.Fatalf("unexpected typed payload: %#v, %v", typed, err)
+			}
+
+			if _, err := DecodeAs[orderPlaced](tr, event); !errors.Is(err, ErrUnknownEventType) {
+				t.Fatalf("expected ErrUnknownEventType decoding into the wrong type, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTypeRegistryPointersShareRegistration(t *testing.T) {
+	tr := NewTypeRegistry(nil)
+	if err := RegisterAs[*orderPlaced](tr, "order.placed"); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := tr.NewEvent("o-1", 0, &orderPlaced{Total: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "order.placed" || string(event.Data) != `{"total":3}` {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+}
+
+func TestTypeRegistryErrors(t *testing.T) {
+	tr := NewTypeRegistry(nil)
+	if err := RegisterAs[orderPlaced](tr, "order.placed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterAs[orderShipped](tr, "order.placed"); !errors.Is(err, ErrEventTypeConflict) {
+		t.Fatalf("expected conflict on reused name, got %v", err)
+	}
+	if err := RegisterAs[orderPlaced](tr, "order.created"); !errors.Is(err, ErrEventTypeConflict) {
+		t.Fatalf("expected conflict on renamed type, got %v", err)
+	}
+	if err := RegisterAs[orderPlaced](tr, "order.placed"); err != nil {
+		t.Fatalf("expected identical registration to be accepted, got %v", err)
+	}
+
+	if _, err := tr.NewEvent("o-1", 0, orderShipped{}); !errors.Is(err, ErrUnregisteredPayload) {
+		t.Fatalf("expected ErrUnregisteredPayload, got %v", err)
+	}
+}
+
+func TestEventRegistryUsesTypeRegistry(t *testing.T) {
+	tr := NewTypeRegistry(GobSerializer{})
+	if err := RegisterAs[orderPlaced](tr, "order.placed"); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &order{}
+	o.events = NewEventRegistry(o, WithTypeRegistry(tr))
+	On(o.events, o.placed)
+
+	event, err := tr.NewEvent("o-1", 0, orderPlaced{Total: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Apply(event); err != nil {
+		t.Fatal(err)
+	}
+	if o.total != 7 {
+		t.Fatalf("expected total 7, got %d", o.total)
+	}
+}
@@ -26,6 +26,22 @@ type Snapshot struct {
 	Timestamp time.Time
 	Version   uint64
 	ID        string
+	Revision  uint64 // Revision of the ExportState format the snapshot was taken with
+}
+
+// SnapshotRevisioner is implemented by aggregates that version their ExportState format. Bump the
+// revision whenever the format changes, Repository.Load ignores snapshots taken with a different
+// revision and replays the aggregate from its events instead. Aggregates that don't implement it
+// are at revision 0.
+type SnapshotRevisioner interface {
+	SnapshotRevision() uint64
+}
+
+func snapshotRevision(agg Aggregate) uint64 {
+	if r, ok := agg.(SnapshotRevisioner); ok {
+		return r.SnapshotRevision()
+	}
+	return 0
 }
 
 // SnapshotStrategy is an adaptable strategy for when to take a snapshot
@@ -34,9 +50,43 @@ type SnapshotStrategy interface {
 	GetStore() SnapshotStore
 }
 
+// LoadStats describes the work done by Repository.Load before deciding whether to snapshot
+type LoadStats struct {
+	Duration      time.Duration // time spent loading the snapshot and replaying events
+	EventsApplied int           // number of events applied on top of the snapshot
+}
+
+// StatsAwareSnapshotStrategy is implemented by strategies that decide based on the cost of a load.
+// When the repository's strategy implements it, ShouldSnapshotWithStats is called instead of
+// ShouldSnapshot.
+type StatsAwareSnapshotStrategy interface {
+	SnapshotStrategy
+	ShouldSnapshotWithStats(snapshot *Snapshot, events []gosignal.Event, stats LoadStats) bool
+}
+
 // SnapshotStore is the interface that wraps the basic snapshot store operations
 type SnapshotStore interface {
 	Load(ctx context.Context, id string) (*Snapshot, error)
 	Store(ctx context.Context, aggregateID string, snapshot Snapshot) error
 	Delete(ctx context.Context, aggregateID string) error
 }
+
+// SnapshotHistoryStore is implemented by snapshot stores that keep several snapshots per aggregate.
+// Repository.Load uses it to start point-in-time loads (MaxVersion) from the nearest snapshot
+// instead of replaying from version zero.
+type SnapshotHistoryStore interface {
+	SnapshotStore
+	// LoadAtOrBefore loads the most recent snapshot whose version is at or below the given version,
+	// it returns nil if there is none
+	LoadAtOrBefore(ctx context.Context, id string, version uint64) (*Snapshot, error)
+	// Prune deletes all but the keep most recent snapshots of the aggregate
+	Prune(ctx context.Context, id string, keep int) error
+}
+
+// SnapshotAsOfStore is implemented by snapshot stores that can look snapshots up by the time they
+// were taken, Repository.LoadAsOf uses it to find a snapshot preceding the requested instant.
+type SnapshotAsOfStore interface {
+	// LoadTakenAtOrBefore loads the most recent snapshot taken at or before the instant, it
+	// returns nil if there is none
+	LoadTakenAtOrBefore(ctx context.Context, id string, t time.Time) (*Snapshot, error)
+}
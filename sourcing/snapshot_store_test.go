@@ -0,0 +1,132 @@
+package sourcing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Howard3/gosignal"
+)
+
+// testSnapshotStore is a minimal in-memory SnapshotStore used by the package tests
+type testSnapshotStore struct {
+	mu        sync.Mutex
+	snapshots map[string]Snapshot
+	storeErr  error
+	stored    int
+}
+
+func newTestSnapshotStore() *testSnapshotStore {
+	return &testSnapshotStore{snapshots: make(map[string]Snapshot)}
+}
+
+func (s *testSnapshotStore) Load(_ context.Context, id string) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.snapshots[id]
+	if !ok {
+		return nil, nil
+	}
+	return &ss, nil
+}
+
+func (s *testSnapshotStore) Store(_ context.Context, aggregateID string, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.storeErr != nil {
+		return s.storeErr
+	}
+	s.snapshots[aggregateID] = snapshot
+	s.stored++
+	return nil
+}
+
+func (s *testSnapshotStore) Delete(_ context.Context, aggregateID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.snapshots, aggregateID)
+	return nil
+}
+
+func (s *testSnapshotStore) get(id string) (Snapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.snapshots[id]
+	return ss, ok
+}
+
+// alwaysSnapshot is a SnapshotStrategy that snapshots on every load with events
+type alwaysSnapshot struct {
+	store SnapshotStore
+}
+
+func (a alwaysSnapshot) ShouldSnapshot(_ *Snapshot, events []gosignal.Event) bool {
+	return len(events) > 0
+}
+
+func (a alwaysSnapshot) GetStore() SnapshotStore {
+	return a.store
+}
+
+// neverSnapshot is a SnapshotStrategy that only loads snapshots
+type neverSnapshot struct {
+	store SnapshotStore
+}
+
+func (n neverSnapshot) ShouldSnapshot(*Snapshot, []gosignal.Event) bool {
+	return false
+}
+
+func (n neverSnapshot) GetStore() SnapshotStore {
+	return n.store
+}
+
+// testHistoryStore is a SnapshotHistoryStore keeping every snapshot in memory
+type testHistoryStore struct {
+	mu        sync.Mutex
+	snapshots map[string][]Snapshot
+	stored    int
+}
+
+func newTestHistoryStore() *testHistoryStore {
+	return &testHistoryStore{snapshots: make(map[string][]Snapshot)}
+}
+
+func (s *testHistoryStore) Load(ctx context.Context, id string) (*Snapshot, error) {
+	return s.LoadAtOrBefore(ctx, id, ^uint64(0))
+}
+
+func (s *testHistoryStore) LoadAtOrBefore(_ context.Context, id string, version uint64) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *Snapshot
+	for i, ss := range s.snapshots[id] {
+		if ss.Version <= version && (found == nil || ss.Version > found.Version) {
+			found = &s.snapshots[id][i]
+		}
+	}
+	return found, nil
+}
+
+func (s *testHistoryStore) Store(_ context.Context, aggregateID string, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots[aggregateID] = append(s.snapshots[aggregateID], snapshot)
+	s.stored++
+	return nil
+}
+
+func (s *testHistoryStore) Delete(_ context.Context, aggregateID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.snapshots, aggregateID)
+	return nil
+}
+
+func (s *testHistoryStore) Prune(_ context.Context, id string, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.snapshots[id]); n > keep {
+		s.snapshots[id] = s.snapshots[id][n-keep:]
+	}
+	return nil
+}
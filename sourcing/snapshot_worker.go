@@ -0,0 +1,188 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/Howard3/gosignal"
+)
+
+// ErrSnapshotQueueFull is the error reported when a background snapshot is dropped because the
+// worker pool's queue is full
+var ErrSnapshotQueueFull = errors.New("snapshot queue full")
+
+// ErrSnapshotWorkersClosed is the error reported when a background snapshot is submitted after the
+// worker pool was closed
+var ErrSnapshotWorkersClosed = errors.New("snapshot workers closed")
+
+// AggregateCloner is implemented by aggregates that can return a deep copy of themselves. Background
+// snapshotting exports the state of the copy off the read path, aggregates that don't implement it
+// have their state exported synchronously before the snapshot is handed to the workers. The
+// AggregateCache only caches aggregates implementing it; Clone must return a pointer of the same
+// type as the aggregate.
+type AggregateCloner interface {
+	Clone() Aggregate
+}
+
+// SnapshotErrorHandler receives errors from background snapshotting
+type SnapshotErrorHandler func(aggregateID string, err error)
+
+// SnapshotWorkerPool creates snapshots in the background with a bounded number of workers and a
+// bounded queue. Work is deduplicated per aggregate: while a snapshot for an aggregate is queued or
+// being written, newer requests for it replace the pending one instead of queueing again, and
+// snapshots of the same aggregate are never written concurrently.
+type SnapshotWorkerPool struct {
+	mu       sync.Mutex
+	queue    chan string
+	pending  map[string]func(context.Context) error
+	inflight map[string]bool
+	closed   bool
+	onError  SnapshotErrorHandler
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// SnapshotWorkerOption configures a SnapshotWorkerPool
+type SnapshotWorkerOption func(*SnapshotWorkerPool)
+
+// WithSnapshotErrorHandler sets the callback that receives background snapshot errors
+func WithSnapshotErrorHandler(fn SnapshotErrorHandler) SnapshotWorkerOption {
+	return func(p *SnapshotWorkerPool) {
+		p.onError = fn
+	}
+}
+
+// WithSnapshotLogger logs background snapshot errors, dropped snapshots at warn level and failed ones
+// at error level, in addition to the error handler
+func WithSnapshotLogger(logger *slog.Logger) SnapshotWorkerOption {
+	return func(p *SnapshotWorkerPool) {
+		p.logger = logger
+	}
+}
+
+// NewSnapshotWorkerPool starts a pool with the given number of workers and queue size
+func NewSnapshotWorkerPool(workers, queueSize int, options ...SnapshotWorkerOption) *SnapshotWorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &SnapshotWorkerPool{
+		queue:    make(chan string, queueSize),
+		pending:  make(map[string]func(context.Context) error),
+		inflight: make(map[string]bool),
+		onError:  func(string, error) {},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for _, option := range options {
+		option(p)
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Submit queues a snapshot job for the aggregate without blocking. Failures, including a full
+// queue, are reported to the error handler, which may call Submit again.
+func (p *SnapshotWorkerPool) Submit(aggregateID string, job func(context.Context) error) {
+	if err := p.enqueue(aggregateID, job); err != nil {
+		p.report(aggregateID, err)
+	}
+}
+
+func (p *SnapshotWorkerPool) enqueue(aggregateID string, job func(context.Context) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrSnapshotWorkersClosed
+	}
+
+	if _, queued := p.pending[aggregateID]; queued || p.inflight[aggregateID] {
+		p.pending[aggregateID] = job // picked up by the worker already responsible for the aggregate
+		return nil
+	}
+
+	select {
+	case p.queue <- aggregateID:
+		p.pending[aggregateID] = job
+		return nil
+	default:
+		return ErrSnapshotQueueFull
+	}
+}
+
+func (p *SnapshotWorkerPool) work() {
+	defer p.wg.Done()
+
+	for id := range p.queue {
+		for {
+			p.mu.Lock()
+			job, ok := p.pending[id]
+			if !ok {
+				delete(p.inflight, id)
+				p.mu.Unlock()
+				break
+			}
+			delete(p.pending, id)
+			p.inflight[id] = true
+			p.mu.Unlock()
+
+			if err := job(p.ctx); err != nil {
+				p.report(id, errors.Join(ErrSnapshotFailed, err))
+			}
+		}
+	}
+}
+
+// report hands an error to the error handler and the logger
+func (p *SnapshotWorkerPool) report(aggregateID string, err error) {
+	if p.logger != nil {
+		level := slog.LevelError
+		if errors.Is(err, ErrSnapshotQueueFull) || errors.Is(err, ErrSnapshotWorkersClosed) {
+			level = slog.LevelWarn
+		}
+		p.logger.LogAttrs(p.ctx, level, "background snapshot failed",
+			slog.String(gosignal.LogKeyAggregateID, aggregateID), slog.Any("error", err))
+	}
+	p.onError(aggregateID, err)
+}
+
+// Close stops accepting work and waits for queued snapshots to be written. If ctx is done first the
+// context passed to running jobs is cancelled and ctx's error is returned.
+func (p *SnapshotWorkerPool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,204 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (e *errorRecorder) handle(_ string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, err)
+}
+
+func (e *errorRecorder) all() []error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]error(nil), e.errs...)
+}
+
+func TestSnapshotWorkerPoolDedupesPerAggregate(t *testing.T) {
+	pool := NewSnapshotWorkerPool(2, 10)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var ran []int
+
+	job := func(n int) func(context.Context) error {
+		return func(context.Context) error {
+			<-release
+			mu.Lock()
+			ran = append(ran, n)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	for n := 1; n <= 5; n++ {
+		pool.Submit("a", job(n))
+	}
+	close(release)
+
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first job may already be running, all later ones collapse into the newest
+	if len(ran) > 2 || ran[len(ran)-1] != 5 {
+		t.Fatalf("expected at most two runs ending with the newest job, got %v", ran)
+	}
+}
+
+func TestSnapshotWorkerPoolReportsFullQueue(t *testing.T) {
+	errs := &errorRecorder{}
+	pool := NewSnapshotWorkerPool(1, 1, WithSnapshotErrorHandler(errs.handle))
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit("a", func(context.Context) error { close(started); <-block; return nil })
+	<-started
+
+	pool.Submit("b", func(context.Context) error { return nil })
+	pool.Submit("c", func(context.Context) error { return nil })
+	close(block)
+
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := errs.all()
+	if len(got) != 1 || !errors.Is(got[0], ErrSnapshotQueueFull) {
+		t.Fatalf("expected a single ErrSnapshotQueueFull, got %v", got)
+	}
+
+	pool.Submit("d", func(context.Context) error { return nil })
+	if got := errs.all(); len(got) != 2 || !errors.Is(got[1], ErrSnapshotWorkersClosed) {
+		t.Fatalf("expected ErrSnapshotWorkersClosed after close, got %v", got)
+	}
+}
+
+func TestSnapshotWorkerPoolErrorHandlerMaySubmit(t *testing.T) {
+	errs := &errorRecorder{}
+	var pool *SnapshotWorkerPool
+	var resubmitted atomic.Bool
+	pool = NewSnapshotWorkerPool(1, 1, WithSnapshotErrorHandler(func(id string, err error) {
+		errs.handle(id, err)
+		if resubmitted.CompareAndSwap(false, true) {
+			pool.Submit(id, func(context.Context) error { return nil }) // reports the full queue again
+		}
+	}))
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit("a", func(context.Context) error { close(started); <-block; return nil })
+	<-started
+	pool.Submit("b", func(context.Context) error { return nil })
+
+	done := make(chan struct{})
+	go func() {
+		pool.Submit("c", func(context.Context) error { return nil })
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Submit deadlocked reporting to a handler that submits")
+	}
+
+	close(block)
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := errs.all(); len(got) != 2 {
+		t.Fatalf("expected the full queue to be reported twice, got %v", got)
+	}
+}
+
+func TestSnapshotWorkerPoolCloseHonoursDeadline(t *testing.T) {
+	pool := NewSnapshotWorkerPool(1, 1)
+	pool.Submit("a", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := pool.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRepositoryBackgroundSnapshots(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name string
+		agg  func() Aggregate
+	}{
+		{"exported inline", func() Aggregate { return &counter{DefaultAggregate: DefaultAggregate{ID: "a"}} }},
+		{"exported from clone", func() Aggregate { return &cloningCounter{counter{DefaultAggregate: DefaultAggregate{ID: "a"}}} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newTestEventStore()
+			_ = events.Store(ctx, incremented("a", 0, 2))
+			snapshots := newTestSnapshotStore()
+
+			pool := NewSnapshotWorkerPool(1, 4)
+			repo := NewRepository(
+				WithEventStore(events),
+				WithSnapshotStrategy(alwaysSnapshot{snapshots}),
+				WithBackgroundSnapshots(pool),
+			)
+
+			if err := repo.Load(ctx, tt.agg(), nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := pool.Close(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			ss, ok := snapshots.get("a")
+			if !ok || ss.Version != 3 || string(ss.Data) != "3" {
+				t.Fatalf("expected snapshot at version 3, got %+v", ss)
+			}
+		})
+	}
+}
+
+func TestRepositoryBackgroundSnapshotFailureDoesNotFailLoad(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, incremented("a", 0, 2))
+	snapshots := newTestSnapshotStore()
+	snapshots.storeErr = errors.New("disk full")
+
+	errs := &errorRecorder{}
+	pool := NewSnapshotWorkerPool(1, 4, WithSnapshotErrorHandler(errs.handle))
+	repo := NewRepository(
+		WithEventStore(events),
+		WithSnapshotStrategy(alwaysSnapshot{snapshots}),
+		WithBackgroundSnapshots(pool),
+	)
+
+	if err := repo.Load(ctx, &counter{DefaultAggregate: DefaultAggregate{ID: "a"}}, nil); err != nil {
+		t.Fatalf("expected load to succeed, got %v", err)
+	}
+	if err := pool.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := errs.all(); len(got) != 1 || !errors.Is(got[0], ErrSnapshotFailed) {
+		t.Fatalf("expected ErrSnapshotFailed to be reported, got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Howard3/gosignal"
+)
+
+// ErrUnitOfWorkCommitted is the error returned when a unit of work is used after Commit
+var ErrUnitOfWorkCommitted = errors.New("unit of work already committed")
+
+// ErrTransactionsNotSupported is the error returned when committing a unit of work with an event
+// store that does not implement TransactionalEventStore
+var ErrTransactionsNotSupported = errors.New("event store does not support transactions")
+
+// UnitOfWork collects the events of several aggregates and stores them together, so a command
+// touching more than one aggregate either stores all of its events or none. The events are appended
+// in one transaction of the repository's event store, which must implement
+// TransactionalEventStore, and are published to the queue only after that succeeded.
+//
+// Every aggregate's events continue from the version it was loaded at, which is the version its
+// stream is expected to be at. When another writer got to any of the aggregates first the whole
+// unit fails with ErrVersionConflict. A UnitOfWork is not safe for concurrent use.
+type UnitOfWork struct {
+	repo      *Repository
+	expected  map[streamKey]uint64
+	next      map[streamKey]uint64
+	events    []gosignal.Event
+	committed bool
+}
+
+// NewUnitOfWork starts a unit of work storing through the repository
+func (r *Repository) NewUnitOfWork() *UnitOfWork {
+	u := &UnitOfWork{repo: r}
+	u.Discard()
+	return u
+}
+
+// Add records events for the aggregate, which must be loaded. The events only need Type and Data,
+// AggregateID, Version and a missing Timestamp are filled in. The first Add for an aggregate
+// continues from its current version, later ones continue after the previously added events.
+//
+// Events keep their AggregateType, so aggregates of other types can join the unit, events without
+// one get the repository's. All events of one Add must have the same aggregate type.
+func (u *UnitOfWork) Add(agg Aggregate, events ...gosignal.Event) error {
+	if u.committed {
+		return ErrUnitOfWorkCommitted
+	}
+
+	pending := append([]gosignal.Event{}, events...)
+
+	aggregateType := u.repo.aggregateType
+	if len(pending) > 0 && pending[0].AggregateType != "" {
+		aggregateType = pending[0].AggregateType
+	}
+	for i := range pending {
+		if pending[i].AggregateType == "" {
+			pending[i].AggregateType = aggregateType
+		}
+		if pending[i].AggregateType != aggregateType {
+			return errors.Join(ErrAggregateTypeMismatch,
+				fmt.Errorf("event aggregate type: %q, expected %q", pending[i].AggregateType, aggregateType))
+		}
+	}
+
+	key := streamKey{aggregateType: aggregateType, aggregateID: agg.GetID()}
+	next, ok := u.next[key]
+	if !ok {
+		next = agg.GetVersion()
+		u.expected[key] = next
+	}
+
+	stampEvents(key.aggregateID, next, pending)
+
+	u.events = append(u.events, pending...)
+	u.next[key] = next + uint64(len(pending))
+
+	return nil
+}
+
+// Pending returns the events added so far
+func (u *UnitOfWork) Pending() []gosignal.Event {
+	return append([]gosignal.Event{}, u.events...)
+}
+
+// Discard drops the pending events
+func (u *UnitOfWork) Discard() {
+	u.events = nil
+	u.expected = make(map[streamKey]uint64)
+	u.next = make(map[streamKey]uint64)
+}
+
+// Commit appends all pending events in one transaction and then publishes them. A unit of work can
+// only be committed once, even when committing fails.
+func (u *UnitOfWork) Commit(ctx context.Context) error {
+	if u.committed {
+		return ErrUnitOfWorkCommitted
+	}
+	u.committed = true
+
+	if len(u.events) == 0 {
+		return nil
+	}
+
+	if _, ok := u.repo.eventStore.(TransactionalEventStore); !ok {
+		return ErrTransactionsNotSupported
+	}
+
+	return u.repo.storeEvents(ctx, storeRequest{events: u.events, expected: u.expected, typed: true})
+}
@@ -0,0 +1,156 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Howard3/gosignal"
+)
+
+func TestUnitOfWorkCommitsAllAggregates(t *testing.T) {
+	ctx := context.Background()
+	store := newTestEventStore()
+	queue := &testQueue{}
+	repo := NewRepository(WithEventStore(store), WithQueue(queue))
+
+	if err := repo.Store(ctx, incremented("a", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	queue.sent = nil
+
+	a := &counter{}
+	a.SetID("a")
+	if err := repo.Load(ctx, a, nil); err != nil {
+		t.Fatal(err)
+	}
+	b := &counter{}
+	b.SetID("b")
+
+	uow := repo.NewUnitOfWork()
+	for _, err := range []error{
+		uow.Add(a, gosignal.Event{Type: "incremented"}),
+		uow.Add(b, gosignal.Event{Type: "incremented"}, gosignal.Event{Type: "incremented"}),
+		uow.Add(a, gosignal.Event{Type: "incremented"}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(queue.sent) != 0 {
+		t.Fatal("expected nothing to be published before commit")
+	}
+	if err := uow.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]int{"a": 4, "b": 2} {
+		c := &counter{}
+		c.SetID(id)
+		if err := repo.Load(ctx, c, nil); err != nil {
+			t.Fatal(err)
+		}
+		if c.Count != want || c.GetVersion() != uint64(want) {
+			t.Fatalf("aggregate %s: version %d, count %d, expected %d", id, c.GetVersion(), c.Count, want)
+		}
+	}
+	if len(queue.sent) != 4 {
+		t.Fatalf("expected all events to be published after commit, got %v", queue.sent)
+	}
+
+	if err := uow.Commit(ctx); !errors.Is(err, ErrUnitOfWorkCommitted) {
+		t.Fatalf("expected ErrUnitOfWorkCommitted, got %v", err)
+	}
+}
+
+func TestUnitOfWorkConflictStoresNothing(t *testing.T) {
+	ctx := context.Background()
+	store := &uniqueVersionStore{testEventStore: newTestEventStore()}
+	queue := &testQueue{}
+	repo := NewRepository(WithEventStore(store), WithQueue(queue))
+
+	a, b := &counter{}, &counter{}
+	a.SetID("a")
+	b.SetID("b")
+
+	uow := repo.NewUnitOfWork()
+	_ = uow.Add(a, gosignal.Event{Type: "incremented"})
+	_ = uow.Add(b, gosignal.Event{Type: "incremented"})
+
+	// another writer gets to b first
+	if err := store.testEventStore.Store(ctx, incremented("b", 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := uow.Commit(ctx); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+	if events, _ := store.Load(ctx, "a", LoadEventsOptions{}); len(events) != 0 {
+		t.Fatalf("expected no events for a, got %d", len(events))
+	}
+	if len(queue.sent) != 0 {
+		t.Fatalf("expected nothing to be published, got %v", queue.sent)
+	}
+}
+
+func TestUnitOfWorkSpansAggregateTypes(t *testing.T) {
+	ctx := context.Background()
+	store := newTestEventStore()
+	orders := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateType("order"))
+	invoices := NewRepository(WithEventStore(store), WithQueue(&testQueue{}), WithAggregateType("invoice"))
+
+	// an invoice sharing the order's id already has an event
+	if err := invoices.Store(ctx, incremented("42", 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	order, invoice := &counter{}, &counter{}
+	order.SetID("42")
+	invoice.SetID("42")
+	if err := invoices.Load(ctx, invoice, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uow := orders.NewUnitOfWork()
+	if err := uow.Add(order, gosignal.Event{Type: "incremented"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := uow.Add(invoice, gosignal.Event{Type: "incremented", AggregateType: "invoice"}); err != nil {
+		t.Fatal(err)
+	}
+	err := uow.Add(invoice, gosignal.Event{Type: "incremented", AggregateType: "invoice"}, gosignal.Event{Type: "incremented", AggregateType: "order"})
+	if !errors.Is(err, ErrAggregateTypeMismatch) {
+		t.Fatalf("expected ErrAggregateTypeMismatch, got %v", err)
+	}
+
+	if err := uow.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for repo, want := range map[*Repository]uint64{orders: 1, invoices: 2} {
+		c := &counter{}
+		c.SetID("42")
+		if err := repo.Load(ctx, c, nil); err != nil {
+			t.Fatal(err)
+		}
+		if c.GetVersion() != want {
+			t.Fatalf("%s: expected version %d, got %d", repo.aggregateType, want, c.GetVersion())
+		}
+	}
+}
+
+func TestUnitOfWorkRequiresTransactions(t *testing.T) {
+	ctx := context.Background()
+	store := struct{ EventStore }{newTestEventStore()}
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}))
+
+	c := &counter{}
+	c.SetID("a")
+	uow := repo.NewUnitOfWork()
+	_ = uow.Add(c, gosignal.Event{Type: "incremented"})
+
+	if err := uow.Commit(ctx); !errors.Is(err, ErrTransactionsNotSupported) {
+		t.Fatalf("expected ErrTransactionsNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package sourcing
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Howard3/gosignal"
+)
+
+// ErrUpcastFailed is the error returned when an upcaster fails, it is joined with the underlying
+// error
+var ErrUpcastFailed = errors.New("upcast failed")
+
+// UpcastFunc converts an event from one schema version to the next. It may rewrite Data and rename
+// Type, the chain sets SchemaVersion to the next version afterwards.
+type UpcastFunc func(gosignal.Event) (gosignal.Event, error)
+
+type upcastKey struct {
+	eventType     string
+	schemaVersion uint64
+}
+
+// UpcasterChain upgrades stored events to their current payload shape before they are applied, so
+// Aggregate.Apply only handles the latest schema of each event type. Upcasters are registered per
+// event type and schema version and run one after another (v1 -> v2 -> v3) until no upcaster is
+// registered for the event's type and schema version.
+type UpcasterChain struct {
+	steps   map[upcastKey]UpcastFunc
+	current map[string]uint64
+}
+
+// NewUpcasterChain creates an empty chain
+func NewUpcasterChain() *UpcasterChain {
+	return &UpcasterChain{steps: make(map[upcastKey]UpcastFunc), current: make(map[string]uint64)}
+}
+
+// Register adds the upcaster converting events of the type from schema version from to from+1
+func (c *UpcasterChain) Register(eventType string, from uint64, fn UpcastFunc) *UpcasterChain {
+	c.steps[upcastKey{eventType, from}] = fn
+	c.current[eventType] = max(c.current[eventType], from+1)
+	return c
+}
+
+// CurrentVersion returns the schema version new events of the type are written at, one past the
+// last registered upcaster of the type or 0 without one
+func (c *UpcasterChain) CurrentVersion(eventType string) uint64 {
+	return c.current[eventType]
+}
+
+// stamp sets the current schema version on events that have none, it returns a copy of the events
+// when any is changed so the caller's slice is left untouched
+func (c *UpcasterChain) stamp(events []gosignal.Event) []gosignal.Event {
+	var stamped []gosignal.Event
+	for i, event := range events {
+		if event.SchemaVersion != 0 || c.current[event.Type] == 0 {
+			continue
+		}
+		if stamped == nil {
+			stamped = append([]gosignal.Event{}, events...)
+		}
+		stamped[i].SchemaVersion = c.current[event.Type]
+	}
+
+	if stamped == nil {
+		return events
+	}
+	return stamped
+}
+
+// Upcast runs all applicable upcasters on the event. The schema version advances with every step,
+// so the chain always terminates even when upcasters rename event types.
+func (c *UpcasterChain) Upcast(event gosignal.Event) (gosignal.Event, error) {
+	for {
+		fn, ok := c.steps[upcastKey{event.Type, event.SchemaVersion}]
+		if !ok {
+			return event, nil
+		}
+
+		from := event.SchemaVersion
+		upcasted, err := fn(event)
+		if err != nil {
+			return event, errors.Join(ErrUpcastFailed,
+				fmt.Errorf("event %q version %d schema version %d", event.Type, event.Version, from), err)
+		}
+
+		upcasted.SchemaVersion = from + 1
+		event = upcasted
+	}
+}
+
+// UpcastAll upcasts every event, returning a new slice
+func (c *UpcasterChain) UpcastAll(events []gosignal.Event) ([]gosignal.Event, error) {
+	upcasted := make([]gosignal.Event, len(events))
+	for i, event := range events {
+		var err error
+		if upcasted[i], err = c.Upcast(event); err != nil {
+			return nil, err
+		}
+	}
+	return upcasted, nil
+}
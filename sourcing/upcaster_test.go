@@ -0,0 +1,191 @@
+package sourcing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Howard3/gosignal"
+)
+
+// placedV1 carried the total in cents as a string, v2 renamed it, v3 stores an int under "total"
+func orderUpcasters() *UpcasterChain {
+	return NewUpcasterChain().
+		Register("orderPlaced", 1, func(e gosignal.Event) (gosignal.Event, error) {
+			var v1 struct{ Cents string }
+			if err := json.Unmarshal(e.Data, &v1); err != nil {
+				return e, err
+			}
+			e.Data, _ = json.Marshal(map[string]string{"amount": v1.Cents})
+			return e, nil
+		}).
+		Register("orderPlaced", 2, func(e gosignal.Event) (gosignal.Event, error) {
+			var v2 struct{ Amount json.Number }
+			if err := json.Unmarshal(e.Data, &v2); err != nil {
+				return e, err
+			}
+			e.Data = []byte(`{"total":` + v2.Amount.String() + `}`)
+			return e, nil
+		})
+}
+
+func TestUpcasterChainMultiStep(t *testing.T) {
+	chain := orderUpcasters()
+
+	tests := []struct {
+		name  string
+		event gosignal.Event
+	}{
+		{"from v1", gosignal.Event{Type: "orderPlaced", SchemaVersion: 1, Data: []byte(`{"Cents":"42"}`)}},
+		{"from v2", gosignal.Event{Type: "orderPlaced", SchemaVersion: 2, Data: []byte(`{"amount":42}`)}},
+		{"already current", gosignal.Event{Type: "orderPlaced", SchemaVersion: 3, Data: []byte(`{"total":42}`)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chain.Upcast(tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.SchemaVersion != 3 || string(got.Data) != `{"total":42}` {
+				t.Fatalf("unexpected upcast result: schema %d, data %s", got.SchemaVersion, got.Data)
+			}
+		})
+	}
+}
+
+func TestUpcasterChainRenamesType(t *testing.T) {
+	chain := NewUpcasterChain().
+		Register("placed", 0, func(e gosignal.Event) (gosignal.Event, error) {
+			e.Type = "orderPlaced"
+			return e, nil
+		}).
+		Register("orderPlaced", 1, func(e gosignal.Event) (gosignal.Event, error) {
+			e.Data = []byte(`{"total":1}`)
+			return e, nil
+		})
+
+	got, err := chain.Upcast(gosignal.Event{Type: "placed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "orderPlaced" || got.SchemaVersion != 2 || string(got.Data) != `{"total":1}` {
+		t.Fatalf("unexpected upcast result: %+v", got)
+	}
+}
+
+func TestUpcasterChainErrors(t *testing.T) {
+	chain := orderUpcasters()
+	_, err := chain.Upcast(gosignal.Event{Type: "orderPlaced", SchemaVersion: 1, Data: []byte("{")})
+	if !errors.Is(err, ErrUpcastFailed) {
+		t.Fatalf("expected ErrUpcastFailed, got %v", err)
+	}
+}
+
+func TestUpcasterChainAlwaysAdvancesSchemaVersion(t *testing.T) {
+	chain := NewUpcasterChain().Register("a", 0, func(e gosignal.Event) (gosignal.Event, error) {
+		e.SchemaVersion = 0 // overwritten by the chain, so this can't loop
+		return e, nil
+	})
+
+	if got, err := chain.Upcast(gosignal.Event{Type: "a"}); err != nil || got.SchemaVersion != 1 {
+		t.Fatalf("expected schema version 1, got %+v, %v", got, err)
+	}
+}
+
+func TestRepositoryUpcastsBeforeApply(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, []gosignal.Event{
+		{Type: "orderPlaced", Version: 0, AggregateID: "o-1", SchemaVersion: 1, Data: []byte(`{"Cents":"42"}`)},
+	})
+
+	repo := NewRepository(WithEventStore(events), WithUpcasters(orderUpcasters()))
+
+	o := newOrder()
+	o.SetID("o-1")
+	if err := repo.Load(ctx, o, nil); err != nil {
+		t.Fatal(err)
+	}
+	if o.total != 42 {
+		t.Fatalf("expected upcast total 42, got %d", o.total)
+	}
+}
+
+func TestRepositoryFiltersEventTypesAfterUpcasting(t *testing.T) {
+	ctx := context.Background()
+	events := newTestEventStore()
+	_ = events.Store(ctx, []gosignal.Event{
+		{Type: "placed", Version: 0, AggregateID: "o-1"},
+		{Type: "orderPlaced", Version: 1, AggregateID: "o-1", SchemaVersion: 1, Data: []byte(`{"Cents":"42"}`)},
+		{Type: "shipped", Version: 2, AggregateID: "o-1"},
+	})
+
+	chain := orderUpcasters().Register("placed", 0, func(e gosignal.Event) (gosignal.Event, error) {
+		e.Type = "orderPlaced"
+		e.Data = []byte(`{"Cents":"7"}`)
+		return e, nil
+	})
+	repo := NewRepository(WithEventStore(events), WithUpcasters(chain))
+
+	loaded, err := repo.LoadEvents(ctx, "o-1", NewRepoLoaderConfigurator().EventTypes("orderPlaced").Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].Version != 0 || loaded[1].Version != 1 {
+		t.Fatalf("expected both upcast orderPlaced events, got %+v", loaded)
+	}
+
+	loaded, err = repo.LoadEvents(ctx, "o-1", NewRepoLoaderConfigurator().EventTypes("placed").Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 0 {
+		t.Fatalf("expected the legacy type to be upcast away, got %+v", loaded)
+	}
+}
+
+func TestUpcasterChainCurrentVersion(t *testing.T) {
+	chain := orderUpcasters()
+	if got := chain.CurrentVersion("orderPlaced"); got != 3 {
+		t.Fatalf("expected orderPlaced at schema version 3, got %d", got)
+	}
+	if got := chain.CurrentVersion("orderShipped"); got != 0 {
+		t.Fatalf("expected a type without upcasters at schema version 0, got %d", got)
+	}
+}
+
+func TestNewEventsGetCurrentSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	chain := orderUpcasters()
+
+	tr := NewTypeRegistry(nil)
+	tr.SetUpcasters(chain)
+	if err := Register[orderPlaced](tr); err != nil {
+		t.Fatal(err)
+	}
+	if event, err := tr.NewEvent("o-1", 0, orderPlaced{Total: 5}); err != nil || event.SchemaVersion != 3 {
+		t.Fatalf("expected NewEvent to write schema version 3, got %+v, %v", event, err)
+	}
+
+	events := newTestEventStore()
+	repo := NewRepository(WithEventStore(events), WithQueue(&testQueue{}), WithUpcasters(chain))
+
+	pending := []gosignal.Event{
+		{Type: "orderPlaced", Version: 0, AggregateID: "o-1", Data: []byte(`{"total":5}`)},
+		{Type: "orderPlaced", Version: 1, AggregateID: "o-1", SchemaVersion: 2, Data: []byte(`{"amount":7}`)},
+		{Type: "shipped", Version: 2, AggregateID: "o-1"},
+	}
+	if err := repo.Store(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending[0].SchemaVersion != 0 {
+		t.Fatal("expected the caller's events to be left untouched")
+	}
+
+	stored, _ := events.Load(ctx, "o-1", LoadEventsOptions{})
+	if len(stored) != 3 || stored[0].SchemaVersion != 3 || stored[1].SchemaVersion != 2 || stored[2].SchemaVersion != 0 {
+		t.Fatalf("expected only the unversioned orderPlaced event to be stamped, got %+v", stored)
+	}
+}
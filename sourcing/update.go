@@ -0,0 +1,129 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+// ErrRetriesExhausted is the error returned by Update when every attempt ran into a version
+// conflict, it is joined with the last ErrVersionConflict
+var ErrRetriesExhausted = errors.New("retries exhausted")
+
+// RetryPolicy controls how Update retries on version conflicts. The delay before retry n is drawn
+// uniformly from [d/2, d] with d = BaseDelay * 2^(n-1), capped at MaxDelay, so racing writers
+// spread out instead of colliding again.
+type RetryPolicy struct {
+	Attempts  int           // total attempts including the first, defaults to 3
+	BaseDelay time.Duration // defaults to 10ms
+	MaxDelay  time.Duration // defaults to 1s
+}
+
+// DefaultRetryPolicy is used by repositories without WithRetryPolicy
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second}
+
+// WithRetryPolicy sets the policy Update uses on version conflicts, zero fields fall back to
+// DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) func(*Repository) {
+	return func(r *Repository) {
+		r.retryPolicy = policy
+	}
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts < 1 {
+		p.Attempts = DefaultRetryPolicy.Attempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	return p
+}
+
+// delay returns the jittered backoff before the given retry, starting at 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxDelay)
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// UpdateFunc decides the events for the loaded aggregate. The events only need Type and Data,
+// Update fills in AggregateID, Version and a missing Timestamp. It is called again with a freshly
+// loaded aggregate after a conflict, so it must not have side effects beyond its return values.
+type UpdateFunc func(ctx context.Context, agg Aggregate) ([]gosignal.Event, error)
+
+// Update loads the aggregate with the given id into a new aggregate from newAgg, runs mutate and
+// saves the returned events at the version the aggregate was loaded at. Aggregates without events
+// are passed to mutate at version 0. On ErrVersionConflict the load and mutate are repeated
+// following the repository's RetryPolicy, once the attempts are used up ErrRetriesExhausted is
+// returned. Errors from mutate are returned as is without retrying.
+func (r *Repository) Update(ctx context.Context, id string, newAgg func() Aggregate, mutate UpdateFunc) error {
+	policy := r.retryPolicy.withDefaults()
+
+	var err error
+	for attempt := 0; attempt < policy.Attempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(policy.delay(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return errors.Join(ctx.Err(), err)
+			case <-timer.C:
+			}
+		}
+
+		if err = r.update(ctx, id, newAgg(), mutate); !errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+		r.log().LogAttrs(ctx, slog.LevelDebug, "retrying update after version conflict",
+			append(r.logAttrs(id, nil), slog.Int("attempt", attempt+1))...)
+	}
+
+	return errors.Join(ErrRetriesExhausted, fmt.Errorf("aggregate %s after %d attempts", id, policy.Attempts), err)
+}
+
+// update runs a single load-mutate-save attempt
+func (r *Repository) update(ctx context.Context, id string, agg Aggregate, mutate UpdateFunc) error {
+	agg.SetID(id)
+
+	if err := r.Load(ctx, agg, nil); err != nil && !errors.Is(err, ErrNoEvents) {
+		return err
+	}
+
+	expected := agg.GetVersion()
+
+	events, err := mutate(ctx, agg)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+
+	stampEvents(id, expected, events)
+
+	return r.Save(ctx, expected, events)
+}
+
+// stampEvents numbers the events from the expected version and sets their aggregate id and any
+// missing timestamp
+func stampEvents(id string, expected uint64, events []gosignal.Event) {
+	now := time.Now()
+	for i := range events {
+		events[i].AggregateID = id
+		events[i].Version = expected + uint64(i)
+		if events[i].Timestamp.IsZero() {
+			events[i].Timestamp = now
+		}
+	}
+}
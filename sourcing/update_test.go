@@ -0,0 +1,91 @@
+package sourcing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Howard3/gosignal"
+)
+
+func incrementOnce(context.Context, Aggregate) ([]gosignal.Event, error) {
+	return []gosignal.Event{{Type: "incremented"}}, nil
+}
+
+func newCounterAggregate() Aggregate { return &counter{} }
+
+func TestRepositoryUpdateRetriesAfterConflict(t *testing.T) {
+	ctx := context.Background()
+	store := &uniqueVersionStore{testEventStore: newTestEventStore()}
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}),
+		WithRetryPolicy(RetryPolicy{Attempts: 2, BaseDelay: time.Microsecond}))
+
+	conflicts := 1
+	store.beforeStore = func() {
+		if conflicts > 0 {
+			conflicts--
+			_ = store.testEventStore.Store(ctx, incremented("c1", 0, 0))
+		}
+	}
+
+	var seen []uint64
+	err := repo.Update(ctx, "c1", newCounterAggregate, func(ctx context.Context, agg Aggregate) ([]gosignal.Event, error) {
+		seen = append(seen, agg.GetVersion())
+		return incrementOnce(ctx, agg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[0] != 0 || seen[1] != 1 {
+		t.Fatalf("expected the mutation to rerun on the reloaded aggregate, saw versions %v", seen)
+	}
+}
+
+func TestRepositoryUpdateRetriesExhausted(t *testing.T) {
+	ctx := context.Background()
+	store := &uniqueVersionStore{testEventStore: newTestEventStore()}
+	repo := NewRepository(WithEventStore(store), WithQueue(&testQueue{}),
+		WithRetryPolicy(RetryPolicy{Attempts: 3, BaseDelay: time.Microsecond}))
+
+	attempts := 0
+	store.beforeStore = func() {
+		attempts++
+		events, _ := store.testEventStore.Load(ctx, "c1", LoadEventsOptions{})
+		_ = store.testEventStore.Store(ctx, incremented("c1", uint64(len(events)), uint64(len(events))))
+	}
+
+	err := repo.Update(ctx, "c1", newCounterAggregate, incrementOnce)
+	if !errors.Is(err, ErrRetriesExhausted) || !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrRetriesExhausted joined with ErrVersionConflict, got %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestRepositoryUpdateDoesNotRetryOtherErrors(t *testing.T) {
+	repo := NewRepository(WithEventStore(newTestEventStore()), WithQueue(&testQueue{}))
+	boom := errors.New("boom")
+
+	calls := 0
+	err := repo.Update(context.Background(), "c1", newCounterAggregate, func(context.Context, Aggregate) ([]gosignal.Event, error) {
+		calls++
+		return nil, boom
+	})
+	if !errors.Is(err, boom) || errors.Is(err, ErrRetriesExhausted) || calls != 1 {
+		t.Fatalf("expected a single call returning boom, got %d calls and %v", calls, err)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}.withDefaults()
+
+	for retry, ceiling := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 6: 50 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			if d := policy.delay(retry); d < ceiling/2 || d > ceiling {
+				t.Fatalf("retry %d: delay %s outside [%s, %s]", retry, d, ceiling/2, ceiling)
+			}
+		}
+	}
+}